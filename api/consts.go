@@ -2,6 +2,7 @@ package api
 
 const LUA_MINSTACK = 20
 const LUAI_MAXSTACK = 1000000
+const LUAI_MAXCCALLS = 200000 // 嵌套调用（包括Lua函数之间的调用）的最大层数，超过时报告stack overflow
 const LUA_REGISTRYINDEX = -LUAI_MAXSTACK - 1000
 const LUA_RIDX_MAINTHREAD int64 = 1
 const LUA_RIDX_GLOBALS int64 = 2
@@ -62,4 +63,43 @@ const (
 	LUA_ERRGCMM
 	LUA_ERRERR
 	LUA_ERRFILE
+	LUA_ERRINTERRUPT // 触发了执行限制（上下文取消、指令数量或者调用层数超过上限）
+)
+
+// Ref的特殊返回值
+const (
+	LUA_NOREF  = -2 // 不代表任何值的引用
+	LUA_REFNIL = -1 // nil的引用
+)
+
+// garbage-collection options
+const (
+	LUA_GCSTOP       = 0
+	LUA_GCRESTART    = 1
+	LUA_GCCOLLECT    = 2
+	LUA_GCCOUNT      = 3
+	LUA_GCCOUNTB     = 4
+	LUA_GCSTEP       = 5
+	LUA_GCSETPAUSE   = 6
+	LUA_GCSETSTEPMUL = 7
+	LUA_GCISRUNNING  = 9
+	LUA_GCGEN        = 10
+	LUA_GCINC        = 11
+)
+
+// 钩子事件
+const (
+	LUA_HOOKCALL = iota
+	LUA_HOOKRET
+	LUA_HOOKLINE
+	LUA_HOOKCOUNT
+	LUA_HOOKTAILCALL
+)
+
+// 钩子掩码
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
 )
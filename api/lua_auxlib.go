@@ -6,6 +6,7 @@ type FuncReg map[string]GoFunction
 type AuxLib interface {
 	/* Error-report functions */
 	Error2(fmt string, a ...interface{}) int
+	Where(level int)
 	ArgError(arg int, extraMsg string) int
 	/* Argument check functions */
 	CheckStack2(sz int, msg string)
@@ -18,6 +19,8 @@ type AuxLib interface {
 	OptInteger(arg int, d int64) int64
 	OptNumber(arg int, d float64) float64
 	OptString(arg int, d string) string
+	TestUData(arg int, tname string) interface{}
+	CheckUData(arg int, tname string) interface{}
 	/* Load functions */
 	DoFile(filename string) bool
 	DoString(str string) bool
@@ -29,8 +32,14 @@ type AuxLib interface {
 	ToString2(idx int) string
 	Len2(idx int) int64
 	GetSubTable(idx int, fname string) bool
+	Traceback(l1 LuaState, msg string, level int)
 	GetMetafield(obj int, e string) LuaType
+	Ref(t int) int        // 弹出栈顶的值，保存在t处的表里，返回它的整数键（引用）
+	Unref(t int, ref int) // 释放t处的表里的引用ref，它的键可以被再次使用
 	CallMeta(obj int, e string) bool
+	NewMetatable(tname string) bool
+	GetMetatable2(tname string) LuaType
+	SetMetatable2(tname string)
 	OpenLibs()
 	RequireF(modname string, openf GoFunction, glb bool)
 	NewLib(list FuncReg)
@@ -0,0 +1,24 @@
+package api
+
+// 活动函数的调试信息，由GetStack和GetInfo填写
+// http://www.lua.org/manual/5.3/manual.html#lua_Debug
+type DebugInfo struct {
+	Event           int
+	Name            string      // 函数名，不知道时为空串（n）
+	NameWhat        string      // "global"、"local"、"method"、"field"、"upvalue"或者""（n）
+	What            string      // "Lua"、"C"或者"main"（S）
+	Source          string      // 源文件名（S）
+	ShortSrc        string      // 适合在错误信息里显示的源文件名（S）
+	CurrentLine     int         // 当前执行到的行号，没有行号信息时为-1（l）
+	LineDefined     int         // 函数定义的起始行号（S）
+	LastLineDefined int         // 函数定义的终止行号（S）
+	NUps            int         // upvalue数量（u）
+	NParams         int         // 固定参数数量（u）
+	IsVararg        bool        // 是否是vararg函数（u）
+	IsTailCall      bool        // 是否是通过尾调用进入的（t）
+	CallInfo        interface{} // 活动函数的调用帧，仅供内部使用
+}
+
+// 钩子函数，事件类型和行号可以从ar中取得
+// http://www.lua.org/manual/5.3/manual.html#lua_Hook
+type Hook func(ls LuaState, ar *DebugInfo)
@@ -1,11 +1,18 @@
 package api
 
+import "context"
+
 type LuaType = int
 type ArithOp = int   // 算术运算符
 type CompareOp = int // 比较运算符
 
 type GoFunction func(LuaState) int
 
+// 延续函数：Go函数调用的函数（或者Go函数自己）让出之后，协程恢复时调用延续函数完成Go函数剩下的工作
+// http://www.lua.org/manual/5.3/manual.html#lua_KFunction
+type KContext = int
+type KFunction func(ls LuaState, status int, ctx KContext) int
+
 func LuaUpvalueIndex(i int) int {
 	return LUA_REGISTRYINDEX - i
 }
@@ -40,6 +47,8 @@ type BasicAPI interface {
 	IsString(idx int) bool
 	IsTable(idx int) bool
 	IsThread(idx int) bool
+	IsUserData(idx int) bool      // 是否是完全用户数据或者轻量用户数据
+	IsLightUserData(idx int) bool // 是否是轻量用户数据
 	IsFunction(idx int) bool
 	IsGoFunction(idx int) bool // 判断给定索引处的值是否是Go函数
 	ToBoolean(idx int) bool
@@ -50,6 +59,7 @@ type BasicAPI interface {
 	ToString(idx int) string
 	ToStringX(idx int) (string, bool)
 	ToGoFunction(idx int) GoFunction
+	ToUserData(idx int) interface{} // 返回用户数据包装的Go值（NewUserData分配的是[]byte），不是用户数据时返回nil
 	ToPointer(idx int) interface{}
 	RawLen(idx int) uint
 	// push functions (Go -> stack)
@@ -62,6 +72,8 @@ type BasicAPI interface {
 	PushGoFunction(f GoFunction)       // 将Go函数推入栈顶
 	PushGoClosure(f GoFunction, n int) // 创建一个Go闭包，将其推入栈顶
 	PushGlobalTable()                  // 将全局环境表推入栈顶
+	PushLightUserData(p interface{})   // 将轻量用户数据推入栈顶，p必须是可比较的（通常是指针）
+	PushUserData(data interface{})     // 把Go值包装成完全用户数据，推入栈顶
 	// comparison and arithmetic functions
 	Arith(op ArithOp)
 	Compare(idx1, idx2 int, op CompareOp) bool
@@ -76,6 +88,8 @@ type BasicAPI interface {
 	RawGetI(idx int, i int64) LuaType
 	GetMetatable(idx int) bool
 	GetGlobal(name string) LuaType // 从全局环境表中获取一个字段的值，然后推入栈顶
+	NewUserData(size int) []byte   // 创建一个大小为size字节的完全用户数据，将其推入栈顶，返回它的内存块
+	GetUserValue(idx int) LuaType  // 把指定索引处完全用户数据关联的值推入栈顶
 
 	// set functions (Go -> stack)
 	SetTable(idx int)           // 从栈顶依次弹出value、key, 然后把value赋给指定索引处table[key]
@@ -86,29 +100,59 @@ type BasicAPI interface {
 	SetMetatable(idx int)
 	SetGlobal(name string)              // 从栈顶弹出一个值，然后将其设为全局环境表的一个字段
 	Register(name string, f GoFunction) // 将Go函数注册到全局环境表
+	SetUserValue(idx int)               // 从栈顶弹出一个值，把它关联到指定索引处的完全用户数据
 
 	// 'load' and 'call' functions (load and run Lua code)
 	Load(chunk []byte, chunkName, mode string) int // Load（）方法加载二进制chunk，把主函数原型实例化为闭包并推入栈顶。实际上该方法不仅可以加载预编译的二进制chunk，也可以直接加载Lua脚本。如果加载的是二进制chunk，那么只要读取文件、解析主函数原型、实例化为闭包、推入栈顶就可以了；如果加载的是Lua脚本，则要先进行编译。为了简化描述，后面把二进制chunk和Lua脚本统称为chunk。
 	Call(nArgs, nResults int)                      // Call（）方法对Lua函数进行调用。在执行Call（）方法之前，必须先把被调函数推入栈顶，然后把参数值依次推入栈顶。Call（）方法结束之后，参数值和函数会被弹出栈顶，取而代之的是指定数量的返回值。Call（）方法接收两个参数：第一个参数指定准备传递给被调函数的参数数量，同时也隐含给出了被调函数在栈里的位置；第二个参数指定需要的返回值数量（多退少补），如果是-1，则被调函数的返回值会全部留在栈顶。
 	PCall(nArgs, nResults, msgh int) int
+	CallK(nArgs, nResults int, ctx KContext, k KFunction)            // 和Call相同，但是被调函数可以让出，协程恢复后调用k
+	PCallK(nArgs, nResults, msgh int, ctx KContext, k KFunction) int // 和PCall相同，但是被调函数可以让出，协程恢复后调用k
+	Dump(strip bool) []byte                                          // 把栈顶的Lua函数序列化为二进制chunk，strip为true时去掉调试信息；栈顶不是Lua函数时返回nil
 	/* miscellaneous functions */
 	Len(idx int)  // 访问指定索引处的值，取其长度，然后推入栈顶
 	Concat(n int) // 从栈顶弹出n个值，对这些值进行拼接，然后把结果推入栈顶
 	Next(idx int) bool
 	Error() int
+	GC(what int, args ...int) int // 控制垃圾回收器，what是LUA_GC*常量之一
+	ToClose(idx int)              // 把索引处的值标记为待关闭变量，它离开栈时调用__close元方法
+	CloseSlot(idx int)            // 关闭索引处的待关闭变量，并把它设置为nil
 	StringToNumber(s string) bool
 
+	// execution limits
+	SetContext(ctx context.Context)       // ctx被取消或者超时的时候中断正在执行的脚本，nil表示不检查
+	Context() context.Context             // SetContext设置的上下文
+	SetInstructionLimit(n int64)          // 最多再执行n条指令，超过时中断脚本；0表示不限制
+	SetCallDepthLimit(n int)              // 嵌套调用超过n层时中断脚本；0表示只受LUAI_MAXCCALLS限制
+	SetInterruptCatchable(catchable bool) // 中断能否被脚本里的pcall捕获，默认不能
+	SetMemoryLimit(n int)                 // 估算的内存用量超过n字节时抛出内存错误（LUA_ERRMEM）；0表示不限制
+	CheckMemory(n int)                    // 分配n字节的大块内存之前调用，会超过内存上限时抛出内存错误
+
 	// corioutine functions
 	NewThread() LuaState
 	Resume(from LuaState, nArgs int) int
 	Yield(nResults int) int
+	YieldK(nResults int, ctx KContext, k KFunction) int // 让出协程，恢复时调用k。和Yield一样不会返回，Go函数应该写成 return ls.YieldK(...)
 	Status() int
 	IsYieldable() bool
+	CloseThread(from LuaState) int // 展开线程的调用栈，让它回到初始状态；线程出错结束时返回错误状态，错误对象留在栈顶
+	ResetThread() int              // 相当于CloseThread(nil)
 	ToThread(idx int) LuaState
 	PushThread() bool
 	XMove(to LuaState, n int)
-	GetStack() bool
 
 	// debug
 	PStack()
+	GetStack(level int, ar *DebugInfo) bool   // 取得第level层（0是当前运行的函数）活动函数的调用帧，level超过调用栈深度时返回false
+	GetInfo(what string, ar *DebugInfo) bool  // 按照what填写ar；what以'>'开头时从栈顶弹出一个函数，查询这个函数的信息
+	GetLocal(ar *DebugInfo, n int) string     // 把ar对应函数的第n个局部变量推入栈顶，返回变量名；ar为nil时只返回栈顶函数的第n个参数名
+	SetLocal(ar *DebugInfo, n int) string     // 从栈顶弹出一个值，赋给ar对应函数的第n个局部变量，返回变量名
+	GetUpvalue(funcIdx, n int) (string, bool) // 把指定函数的第n个upvalue推入栈顶，返回upvalue的名字；n无效时返回false且不推入任何值
+	SetUpvalue(funcIdx, n int) (string, bool) // 从栈顶弹出一个值，将其设为指定函数的第n个upvalue，返回upvalue的名字；n无效时返回false且不弹出任何值
+	UpvalueId(funcIdx, n int) interface{}     // 返回指定函数第n个upvalue的唯一标识，共享同一个upvalue的闭包返回相同的值
+	UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int)
+	SetHook(f Hook, mask, count int)
+	GetHook() Hook
+	GetHookMask() int
+	GetHookCount() int
 }
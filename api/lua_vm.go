@@ -11,4 +11,6 @@ type LuaVM interface {
 	LoadVararg(n int)   // 加载函数的变长参数到栈顶
 	LoadProto(idx int)  // 加载子函数原型到栈顶
 	CloseUpvalues(a int)
+	TailCall(nArgs int) bool               // 尾调用，被调函数是Go函数时返回false
+	RunError(fmt string, a ...interface{}) // 抛出运行时错误，错误信息前面加上当前指令所在的源文件名和行号
 }
@@ -0,0 +1,39 @@
+package binchunk
+
+import "strings"
+
+const LUA_IDSIZE = 60 // 调试信息里源文件名的最大长度
+
+// ChunkID 把chunk名转换成适合在错误信息里显示的形式：
+// "=name"原样显示name；"@filename"显示文件名，过长时保留结尾部分；其他情况视为源码本身，显示为[string "..."]
+// lua-5.3.4/src/lobject.c#luaO_chunkid()
+func ChunkID(source string) string {
+	const rets, pre, pos = "...", "[string \"", "\"]"
+
+	if len(source) > 0 && source[0] == '=' { /* 'literal' source */
+		if len(source) <= LUA_IDSIZE { /* small enough? */
+			return source[1:]
+		}
+		return source[1:LUA_IDSIZE] /* truncate it */
+	}
+	if len(source) > 0 && source[0] == '@' { /* file name */
+		if len(source) <= LUA_IDSIZE { /* small enough? */
+			return source[1:]
+		}
+		return rets + source[len(source)-(LUA_IDSIZE-len(rets)-1):] /* add '...' before rest of name */
+	}
+	/* string; format as [string "source"] */
+	bufflen := LUA_IDSIZE - len(pre+rets+pos) - 1 /* save space for prefix+suffix+'\0' */
+	nl := strings.IndexByte(source, '\n')         /* find first new line (if any) */
+	if len(source) < bufflen && nl < 0 {          /* small one-line source? */
+		return pre + source + pos
+	}
+	l := len(source)
+	if nl >= 0 {
+		l = nl /* stop at first newline */
+	}
+	if l > bufflen {
+		l = bufflen
+	}
+	return pre + source[:l] + rets + pos
+}
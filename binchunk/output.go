@@ -2,19 +2,29 @@ package binchunk
 
 import (
 	"fmt"
+	"io"
 	. "luago/vm"
+	"os"
 )
 
+// List 把函数原型（包括子函数）的完整列表打印到标准输出，相当于 luac -l -l
 func List(f *Prototype) {
-	printHeader(f)
-	printCode(f)
-	printDetail(f)
+	Fprint(os.Stdout, f, true)
+}
+
+// Fprint 把函数原型（包括子函数）的指令列表写入w，full为true时还会列出常量表、局部变量表和upvalue表
+func Fprint(w io.Writer, f *Prototype, full bool) {
+	printHeader(w, f)
+	printCode(w, f)
+	if full {
+		printDetail(w, f)
+	}
 	for _, p := range f.Protos {
-		List(p)
+		Fprint(w, p, full)
 	}
 }
 
-func printHeader(f *Prototype) {
+func printHeader(w io.Writer, f *Prototype) {
 	funcType := "main"
 	if f.LineDefined > 0 {
 		funcType = "function"
@@ -24,75 +34,75 @@ func printHeader(f *Prototype) {
 		varargFlag = "+"
 	}
 
-	fmt.Printf("\n%s <%s:%d,%d> (%d instructions)\n", funcType, f.Source, f.LineDefined, f.LastLineDefined, len(f.Code))
-	fmt.Printf("%d%s params, %d slots, %d upvalues, ", f.NumParams, varargFlag, f.MaxStackSize, len(f.Upvalues))
-	fmt.Printf("%d locals, %d constants, %d functions\n", len(f.LocVars), len(f.Constants), len(f.Protos))
+	fmt.Fprintf(w, "\n%s <%s:%d,%d> (%d instructions)\n", funcType, sourceName(f.Source), f.LineDefined, f.LastLineDefined, len(f.Code))
+	fmt.Fprintf(w, "%d%s params, %d slots, %d upvalues, ", f.NumParams, varargFlag, f.MaxStackSize, len(f.Upvalues))
+	fmt.Fprintf(w, "%d locals, %d constants, %d functions\n", len(f.LocVars), len(f.Constants), len(f.Protos))
 }
 
-func printCode(f *Prototype) {
+func printCode(w io.Writer, f *Prototype) {
 	for pc, c := range f.Code {
 		line := "-"
 		if len(f.LineInfo) > 0 {
 			line = fmt.Sprintf("%d", f.LineInfo[pc])
 		}
-		// fmt.Printf("\t%d\t[%s]\t0x%08X\n", pc+1, line, c)
+		// fmt.Fprintf(w, "\t%d\t[%s]\t0x%08X\n", pc+1, line, c)
 		i := Instruction(c)
-		fmt.Printf("\t%d\t[%s]\t%s \t", pc+1, line, i.OpName())
-		printOperands(i)
-		fmt.Printf("\n")
+		fmt.Fprintf(w, "\t%d\t[%s]\t%s \t", pc+1, line, i.OpName())
+		printOperands(w, i)
+		fmt.Fprintf(w, "\n")
 	}
 }
 
-func printOperands(i Instruction) {
+func printOperands(w io.Writer, i Instruction) {
 	switch i.OpMode() {
 	case IABC:
 		a, b, c := i.ABC()
 
-		fmt.Printf("%d", a)
+		fmt.Fprintf(w, "%d", a)
 		if i.BMode() != OpArgN {
 			if b > 0xFF { // 在iABC模式下，B和C操作数各占9个比特，如果B或C操作数属于OpArgK类型，那么就只能使用9个比特中的低8位，最高位的那个比特如果是1，则操作数表示常量表索引，否则表示寄存器索引。
-				fmt.Printf(" %d", -1-b&0xFF)
+				fmt.Fprintf(w, " %d", -1-b&0xFF)
 			} else {
-				fmt.Printf(" %d", b)
+				fmt.Fprintf(w, " %d", b)
 			}
 		}
 		if i.CMode() != OpArgN {
 			if c > 0xFF {
-				fmt.Printf(" %d", -1-c&0xFF)
+				fmt.Fprintf(w, " %d", -1-c&0xFF)
 			} else {
-				fmt.Printf(" %d", c)
+				fmt.Fprintf(w, " %d", c)
 			}
 		}
 	case IABx:
 		a, bx := i.ABx()
 
-		fmt.Printf("%d", a)
+		fmt.Fprintf(w, "%d", a)
 		if i.BMode() == OpArgK {
-			fmt.Printf(" %d", -1-bx)
+			fmt.Fprintf(w, " %d", -1-bx)
 		} else if i.BMode() == OpArgU {
-			fmt.Printf(" %d", bx)
+			fmt.Fprintf(w, " %d", bx)
 		}
 	case IAsBx:
 		a, sbx := i.AsBx()
-		fmt.Printf("%d %d", a, sbx)
+		fmt.Fprintf(w, "%d %d", a, sbx)
 	case IAx:
 		ax := i.Ax()
-		fmt.Printf("%d", -1-ax)
+		fmt.Fprintf(w, "%d", -1-ax)
 	}
 }
 
-func printDetail(f *Prototype) {
-	fmt.Printf("constants (%d):\n", len(f.Constants))
+func printDetail(w io.Writer, f *Prototype) {
+	fmt.Fprintf(w, "constants (%d):\n", len(f.Constants))
 	for i, k := range f.Constants {
-		fmt.Printf("\t%d\t%s\n", i+1, constantToString(k))
+		fmt.Fprintf(w, "\t%d\t%s\n", i+1, constantToString(k))
 	}
-	fmt.Printf("locals (%d):\n", len(f.LocVars))
+	fmt.Fprintf(w, "locals (%d):\n", len(f.LocVars))
 	for i, locVar := range f.LocVars {
-		fmt.Printf("\t%d\t%s\t%d\t%d\n", i, locVar.VarName, locVar.StartPC+1, locVar.EndPC+1)
+		fmt.Fprintf(w, "\t%d\t%s\t%d\t%d\n", i, locVar.VarName, locVar.StartPC+1, locVar.EndPC+1)
 	}
-	fmt.Printf("upvalues (%d):\n", len(f.Upvalues))
+	fmt.Fprintf(w, "upvalues (%d):\n", len(f.Upvalues))
 	for i, upval := range f.Upvalues {
-		fmt.Printf("\t%d\t%s\t%d\t%d\n", i, upvalName(f, i), upval.Instack, upval.Idx)
+		fmt.Fprintf(w, "\t%d\t%s\t%d\t%d\n", i, upvalName(f, i), upval.Instack, upval.Idx)
 	}
 }
 
@@ -119,3 +129,14 @@ func upvalName(f *Prototype, idx int) string {
 	}
 	return "-"
 }
+
+// 和luac一样，去掉chunk名开头的'@'或'='
+func sourceName(source string) string {
+	if source == "" {
+		return "?"
+	}
+	if source[0] == '@' || source[0] == '=' {
+		return source[1:]
+	}
+	return source
+}
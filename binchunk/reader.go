@@ -17,23 +17,35 @@ import (
 
 // 在二进制chunk内部，指令表、常量表、子函数原型表等信息都是按照列表的方式存储的。具体来说也很简单，先用一个cint类型记录列表长度，然后紧接着存储n个列表元素
 
+// 读取出错时panic，值是C实现的错误说明，Load在前面加上chunk名
+// lua-5.3.4/src/lundump.c#error()
 type reader struct {
 	data []byte
 }
 
+// 剩下的数据不够n字节时说明二进制chunk被截断了
+func (r *reader) check(n uint64) {
+	if n > uint64(len(r.data)) {
+		panic("truncated precompiled chunk")
+	}
+}
+
 func (r *reader) readByte() byte {
+	r.check(1)
 	b := r.data[0]
 	r.data = r.data[1:]
 	return b
 }
 
 func (r *reader) readUint32() uint32 {
+	r.check(4)
 	i := binary.LittleEndian.Uint32(r.data)
 	r.data = r.data[4:]
 	return i
 }
 
 func (r *reader) readUint64() uint64 {
+	r.check(8)
 	i := binary.LittleEndian.Uint64(r.data)
 	r.data = r.data[8:]
 	return i
@@ -48,11 +60,19 @@ func (r *reader) readLuaNumber() float64 {
 }
 
 func (r *reader) readBytes(n uint) []byte {
+	r.check(uint64(n))
 	bytes := r.data[:n]
 	r.data = r.data[n:]
 	return bytes
 }
 
+// 列表长度。每个元素至少占用minSize字节，剩下的数据不够时不用分配列表就知道chunk被截断了
+func (r *reader) readSize(minSize uint64) uint32 {
+	n := r.readUint32()
+	r.check(uint64(n) * minSize)
+	return n
+}
+
 func (r *reader) readString() string {
 	size := uint(r.readByte())
 	if size == 0 {
@@ -67,27 +87,27 @@ func (r *reader) readString() string {
 
 func (r *reader) checkHeader() {
 	if string(r.readBytes(4)) != LUA_SIGNATURE {
-		panic("not a precompiled chunk!")
+		panic("not a precompiled chunk")
 	} else if r.readByte() != LUAC_VERSION {
-		panic("version mismatch!")
+		panic("version mismatch in precompiled chunk")
 	} else if r.readByte() != LUAC_FORMAT {
-		panic("format mismatch!")
+		panic("format mismatch in precompiled chunk")
 	} else if string(r.readBytes(6)) != LUAC_DATA {
-		panic("corrupted!")
+		panic("corrupted precompiled chunk")
 	} else if r.readByte() != CINT_SIZE {
-		panic("int size mismatch!")
+		panic("int size mismatch in precompiled chunk")
 	} else if r.readByte() != CSIZET_SIZE {
-		panic("size_t size mismatch!")
+		panic("size_t size mismatch in precompiled chunk")
 	} else if r.readByte() != INSTRUCTION_SIZE {
-		panic("instruction size mismatch!")
+		panic("Instruction size mismatch in precompiled chunk")
 	} else if r.readByte() != LUA_INTEGER_SIZE {
-		panic("lua_Integer size mismatch!")
+		panic("lua_Integer size mismatch in precompiled chunk")
 	} else if r.readByte() != LUA_NUMBER_SIZE {
-		panic("lua_Number size mismatch!")
+		panic("lua_Number size mismatch in precompiled chunk")
 	} else if r.readLuaInteger() != LUAC_INT {
-		panic("endianness mismatch!")
+		panic("endianness mismatch in precompiled chunk")
 	} else if r.readLuaNumber() != LUAC_NUM {
-		panic("float format mismatch!")
+		panic("float format mismatch in precompiled chunk")
 	}
 }
 
@@ -114,7 +134,7 @@ func (r *reader) readProto(parentSource string) *Prototype {
 }
 
 func (r *reader) readCode() []uint32 {
-	code := make([]uint32, r.readUint32()) // 指令表大小
+	code := make([]uint32, r.readSize(4)) // 指令表大小
 	for i := range code {
 		code[i] = r.readUint32()
 	}
@@ -122,7 +142,7 @@ func (r *reader) readCode() []uint32 {
 }
 
 func (r *reader) readConstants() []interface{} {
-	constants := make([]interface{}, r.readUint32()) // 常量表大小
+	constants := make([]interface{}, r.readSize(1)) // 常量表大小
 	for i := range constants {
 		constants[i] = r.readConstant()
 	}
@@ -144,12 +164,12 @@ func (r *reader) readConstant() interface{} {
 	case TAG_LONG_STR:
 		return r.readString()
 	default:
-		panic("corrupted!")
+		panic("corrupted precompiled chunk")
 	}
 }
 
 func (r *reader) readUpvalues() []Upvalue {
-	upvalues := make([]Upvalue, r.readUint32()) // upvalue表大小
+	upvalues := make([]Upvalue, r.readSize(2)) // upvalue表大小
 	for i := range upvalues {
 		upvalues[i] = Upvalue{
 			Instack: r.readByte(),
@@ -160,7 +180,7 @@ func (r *reader) readUpvalues() []Upvalue {
 }
 
 func (r *reader) readProtos(parentSource string) []*Prototype {
-	protos := make([]*Prototype, r.readUint32()) // 子函数原型表大小
+	protos := make([]*Prototype, r.readSize(1)) // 子函数原型表大小
 	for i := range protos {
 		protos[i] = r.readProto(parentSource)
 	}
@@ -168,7 +188,7 @@ func (r *reader) readProtos(parentSource string) []*Prototype {
 }
 
 func (r *reader) readLineInfo() []uint32 {
-	lineInfo := make([]uint32, r.readUint32()) // 行号表大小
+	lineInfo := make([]uint32, r.readSize(4)) // 行号表大小
 	for i := range lineInfo {
 		lineInfo[i] = r.readUint32()
 	}
@@ -176,7 +196,7 @@ func (r *reader) readLineInfo() []uint32 {
 }
 
 func (r *reader) readLocVars() []LocVar {
-	locVars := make([]LocVar, r.readUint32()) // 局部变量表大小
+	locVars := make([]LocVar, r.readSize(9)) // 局部变量表大小
 	for i := range locVars {
 		locVars[i] = LocVar{
 			VarName: r.readString(),
@@ -188,7 +208,7 @@ func (r *reader) readLocVars() []LocVar {
 }
 
 func (r *reader) readUpvalueNames() []string {
-	upvalueNames := make([]string, r.readUint32()) // upvalue名列表大小
+	upvalueNames := make([]string, r.readSize(1)) // upvalue名列表大小
 	for i := range upvalueNames {
 		upvalueNames[i] = r.readString()
 	}
@@ -0,0 +1,177 @@
+package binchunk
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// writer 是reader的逆过程，按照同样的格式把函数原型序列化为二进制chunk
+// lua-5.3.4/src/ldump.c
+
+const LUAI_MAXSHORTLEN = 40 // 短字符串的最大长度
+
+type writer struct {
+	data  []byte
+	strip bool // 是否去掉调试信息（行号表、局部变量表、upvalue名列表和源文件名）
+}
+
+// Dump 把主函数原型序列化为二进制chunk，strip为true时不保存调试信息
+func Dump(proto *Prototype, strip bool) []byte {
+	w := &writer{strip: strip}
+	w.writeHeader()                        // 写入二进制chunk头部
+	w.writeByte(byte(len(proto.Upvalues))) // 主函数upvalue数量
+	w.writeProto(proto, "")                // 写入主函数原型
+	return w.data
+}
+
+func (w *writer) writeByte(b byte) {
+	w.data = append(w.data, b)
+}
+
+func (w *writer) writeUint32(i uint32) {
+	w.data = binary.LittleEndian.AppendUint32(w.data, i)
+}
+
+func (w *writer) writeUint64(i uint64) {
+	w.data = binary.LittleEndian.AppendUint64(w.data, i)
+}
+
+func (w *writer) writeLuaInteger(n int64) {
+	w.writeUint64(uint64(n))
+}
+
+func (w *writer) writeLuaNumber(n float64) {
+	w.writeUint64(math.Float64bits(n))
+}
+
+func (w *writer) writeBytes(bytes []byte) {
+	w.data = append(w.data, bytes...)
+}
+
+// 和luac一样，空字符串的长度写成1；长度0表示NULL字符串，只用于省略的源文件名
+// lua-5.3.4/src/ldump.c#DumpString()
+func (w *writer) writeString(s string) {
+	size := uint64(len(s)) + 1 // +1 for '\0'
+	if size < 0xFF {
+		w.writeByte(byte(size))
+	} else {
+		w.writeByte(0xFF)
+		w.writeUint64(size)
+	}
+	w.data = append(w.data, s...)
+}
+
+func (w *writer) writeHeader() {
+	w.writeBytes([]byte(LUA_SIGNATURE))
+	w.writeByte(LUAC_VERSION)
+	w.writeByte(LUAC_FORMAT)
+	w.writeBytes([]byte(LUAC_DATA))
+	w.writeByte(CINT_SIZE)
+	w.writeByte(CSIZET_SIZE)
+	w.writeByte(INSTRUCTION_SIZE)
+	w.writeByte(LUA_INTEGER_SIZE)
+	w.writeByte(LUA_NUMBER_SIZE)
+	w.writeLuaInteger(LUAC_INT)
+	w.writeLuaNumber(LUAC_NUM)
+}
+
+// 子函数的源文件名和父函数相同时不再重复保存
+func (w *writer) writeProto(proto *Prototype, parentSource string) {
+	if w.strip || proto.Source == parentSource || proto.Source == "" {
+		w.writeByte(0) /* NULL */
+	} else {
+		w.writeString(proto.Source)
+	}
+	w.writeUint32(proto.LineDefined)
+	w.writeUint32(proto.LastLineDefined)
+	w.writeByte(proto.NumParams)
+	w.writeByte(proto.IsVararg)
+	w.writeByte(proto.MaxStackSize)
+	w.writeCode(proto.Code)
+	w.writeConstants(proto.Constants)
+	w.writeUpvalues(proto.Upvalues)
+	w.writeProtos(proto.Protos, proto.Source)
+	w.writeDebug(proto)
+}
+
+func (w *writer) writeCode(code []uint32) {
+	w.writeUint32(uint32(len(code)))
+	for _, inst := range code {
+		w.writeUint32(inst)
+	}
+}
+
+func (w *writer) writeConstants(constants []interface{}) {
+	w.writeUint32(uint32(len(constants)))
+	for _, k := range constants {
+		w.writeConstant(k)
+	}
+}
+
+func (w *writer) writeConstant(k interface{}) {
+	switch x := k.(type) {
+	case nil:
+		w.writeByte(TAG_NIL)
+	case bool:
+		w.writeByte(TAG_BOOLEAN)
+		if x {
+			w.writeByte(1)
+		} else {
+			w.writeByte(0)
+		}
+	case int64:
+		w.writeByte(TAG_INTEGER)
+		w.writeLuaInteger(x)
+	case float64:
+		w.writeByte(TAG_NUMBER)
+		w.writeLuaNumber(x)
+	case string:
+		if len(x) <= LUAI_MAXSHORTLEN {
+			w.writeByte(TAG_SHORT_STR)
+		} else {
+			w.writeByte(TAG_LONG_STR)
+		}
+		w.writeString(x)
+	default:
+		panic("unknown constant type!")
+	}
+}
+
+func (w *writer) writeUpvalues(upvalues []Upvalue) {
+	w.writeUint32(uint32(len(upvalues)))
+	for _, uv := range upvalues {
+		w.writeByte(uv.Instack)
+		w.writeByte(uv.Idx)
+	}
+}
+
+func (w *writer) writeProtos(protos []*Prototype, parentSource string) {
+	w.writeUint32(uint32(len(protos)))
+	for _, p := range protos {
+		w.writeProto(p, parentSource)
+	}
+}
+
+// 行号表、局部变量表和upvalue名列表，strip时只写入长度0
+func (w *writer) writeDebug(proto *Prototype) {
+	if w.strip {
+		w.writeUint32(0)
+		w.writeUint32(0)
+		w.writeUint32(0)
+		return
+	}
+	w.writeUint32(uint32(len(proto.LineInfo)))
+	for _, line := range proto.LineInfo {
+		w.writeUint32(line)
+	}
+	w.writeUint32(uint32(len(proto.LocVars)))
+	for _, locVar := range proto.LocVars {
+		w.writeString(locVar.VarName)
+		w.writeUint32(locVar.StartPC)
+		w.writeUint32(locVar.EndPC)
+	}
+	w.writeUint32(uint32(len(proto.UpvalueNames)))
+	for _, name := range proto.UpvalueNames {
+		w.writeString(name)
+	}
+}
@@ -0,0 +1,95 @@
+package binchunk_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "luago/binchunk"
+	"luago/compiler"
+)
+
+// luac -o - - <<< "return 1"（Lua 5.3，x86_64）的输出
+var luacReturn1 = []byte("\x1bLua\x53\x00\x19\x93\r\n\x1a\n\x04\x08\x04\x08\x08" +
+	"\x78\x56\x00\x00\x00\x00\x00\x00" + // LUAC_INT
+	"\x00\x00\x00\x00\x00\x28\x77\x40" + // LUAC_NUM
+	"\x01" + // sizeupvalues
+	"\x07=stdin" + // source
+	"\x00\x00\x00\x00\x00\x00\x00\x00" + // linedefined, lastlinedefined
+	"\x00\x01\x02" + // numparams, is_vararg, maxstacksize
+	"\x03\x00\x00\x00" + // code
+	"\x01\x00\x00\x00" + // LOADK 0 -1
+	"\x26\x00\x00\x01" + // RETURN 0 2
+	"\x26\x00\x80\x00" + // RETURN 0 1
+	"\x01\x00\x00\x00" + "\x13\x01\x00\x00\x00\x00\x00\x00\x00" + // constants
+	"\x01\x00\x00\x00" + "\x01\x00" + // upvalues
+	"\x00\x00\x00\x00" + // protos
+	"\x03\x00\x00\x00" + "\x01\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00" + // lineinfo
+	"\x00\x00\x00\x00" + // locvars
+	"\x01\x00\x00\x00" + "\x05_ENV") // upvalue names
+
+func TestDumpLuac(t *testing.T) {
+	proto := Undump(luacReturn1)
+	if proto.Source != "=stdin" || len(proto.Code) != 3 || proto.Constants[0] != int64(1) {
+		t.Fatalf("bad prototype: %+v", proto)
+	}
+	if data := Dump(proto, false); !bytes.Equal(data, luacReturn1) {
+		t.Errorf("Dump() = %q, want %q", data, luacReturn1)
+	}
+
+	stripped := Dump(proto, true)
+	p2 := Undump(stripped)
+	if p2.Source != "" || len(p2.LineInfo) != 0 || len(p2.UpvalueNames) != 0 {
+		t.Errorf("debug info not stripped: %+v", p2)
+	}
+	if !reflect.DeepEqual(p2.Code, proto.Code) || !reflect.DeepEqual(p2.Constants, proto.Constants) {
+		t.Errorf("stripped chunk lost code: %+v", p2)
+	}
+	if !bytes.Equal(Dump(p2, true), stripped) {
+		t.Error("stripped chunk does not round-trip")
+	}
+}
+
+const roundTripSrc = `
+local t = {1, 2.5, "str", "", true, false, nil, x = "a long string constant, definitely more than forty bytes long"}
+local function outer(a, ...)
+  local n = select("#", ...)
+  return function(b)
+    for i = 1, n do a = a + b * i end
+    return a, t
+  end
+end
+print(outer(1, 2, 3)(4))
+`
+
+func TestDumpRoundTrip(t *testing.T) {
+	proto, err := compiler.Compile(roundTripSrc, "@roundtrip.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, strip := range []bool{false, true} {
+		data := Dump(proto, strip)
+		p2 := Undump(data)
+		if data2 := Dump(p2, strip); !bytes.Equal(data, data2) {
+			t.Errorf("strip=%v: Undump -> Dump is not byte-for-byte", strip)
+		}
+		if !strip && !reflect.DeepEqual(proto, p2) {
+			t.Errorf("prototype changed after round trip:\n%+v\n%+v", proto, p2)
+		}
+	}
+}
+
+// 空字符串常量的长度是1（长度0表示NULL字符串，参考实现加载时会出错）
+func TestDumpEmptyString(t *testing.T) {
+	proto, err := compiler.Compile(`return ""`, "=stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := Dump(proto, false)
+	if constants := "\x01\x00\x00\x00" + "\x04\x01"; !bytes.Contains(data, []byte(constants)) {
+		t.Errorf("Dump() = %q, want constants %q", data, constants)
+	}
+	if p2 := Undump(data); p2.Constants[0] != "" {
+		t.Errorf("constant = %q", p2.Constants[0])
+	}
+}
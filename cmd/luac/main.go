@@ -0,0 +1,191 @@
+// luac 是Lua编译器，用法和参数与官方的luac保持一致（lua-5.3.4/src/luac.c）
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"luago/binchunk"
+	"luago/compiler"
+	"luago/vm"
+	"os"
+)
+
+const (
+	progName = "luac"
+	output   = progName + ".out" // 默认输出文件
+)
+
+var (
+	listing   = 0      // list bytecodes?
+	dumping   = true   // dump bytecodes?
+	stripping = false  // strip debug information?
+	outFile   = output // actual output file name
+)
+
+func fatal(msg string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progName, msg)
+	os.Exit(1)
+}
+
+func usage(msg string) {
+	if msg != "" {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", progName, msg)
+	}
+	fmt.Fprintf(os.Stderr, `usage: %s [options] [filenames]
+Available options are:
+  -l       list (use -l -l for full listing)
+  -o name  output to file 'name' (default is "%s")
+  -p       parse only
+  -s       strip debug information
+  -v       show version information
+  --       stop handling options
+  -        stop handling options and process stdin
+`, progName, output)
+	os.Exit(1)
+}
+
+// 解析命令行参数，返回输入文件列表
+// lua-5.3.4/src/luac.c#doargs()
+func doArgs(args []string) []string {
+	version := false
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "" || arg[0] != '-' { /* end of options; keep it */
+			break
+		} else if arg == "--" { /* end of options; skip it */
+			i++
+			break
+		} else if arg == "-" { /* end of options; use stdin */
+			break
+		} else if arg == "-l" { /* list */
+			listing++
+		} else if arg == "-o" { /* output file */
+			i++
+			if i >= len(args) || args[i] == "" || args[i][0] == '-' && len(args[i]) > 1 {
+				usage("'-o' needs argument")
+			}
+			outFile = args[i]
+			if outFile == "-" {
+				outFile = "" /* use stdout */
+			}
+		} else if arg == "-p" { /* parse only */
+			dumping = false
+		} else if arg == "-s" { /* strip debug information */
+			stripping = true
+		} else if arg == "-v" { /* show version */
+			version = true
+		} else { /* unknown option */
+			usage(fmt.Sprintf("unrecognized option '%s'", arg))
+		}
+	}
+	files := args[i:]
+	if len(files) == 0 && (listing > 0 || !dumping) { /* list or parse luac.out */
+		dumping = false
+		files = []string{output}
+	}
+	if version {
+		fmt.Println("Lua 5.3 (luago)")
+		if len(files) == 0 {
+			os.Exit(0)
+		}
+	}
+	return files
+}
+
+// 编译一个源文件或者读取预编译的二进制chunk，"-"表示标准输入
+func load(filename string) (proto *binchunk.Prototype) {
+	var data []byte
+	var err error
+	chunkName := "@" + filename
+	if filename == "-" {
+		chunkName = "=stdin"
+		data, err = ioutil.ReadAll(bufio.NewReader(os.Stdin))
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		fatal(fmt.Sprintf("cannot open %s", filename))
+	}
+
+	defer func() {
+		if r := recover(); r != nil { // 二进制chunk损坏
+			fatal(fmt.Sprint(r))
+		}
+	}()
+	if binchunk.IsBinaryChunk(data) {
+		return binchunk.Undump(data)
+	}
+	if proto, err = compiler.Compile(string(data), chunkName); err != nil {
+		fatal(err.Error())
+	}
+	return proto
+}
+
+// 有多个输入文件时，生成一个依次调用各个chunk的主函数
+// lua-5.3.4/src/luac.c#combine()
+func combine(protos []*binchunk.Prototype) *binchunk.Prototype {
+	if len(protos) == 1 {
+		return protos[0]
+	}
+	n := len(protos)
+	f := &binchunk.Prototype{
+		Source:       "=(" + progName + ")",
+		IsVararg:     1,
+		MaxStackSize: 1,
+		Upvalues:     []binchunk.Upvalue{{Instack: 1, Idx: 0}},
+		Protos:       protos,
+		UpvalueNames: []string{"_ENV"},
+	}
+	for i, p := range protos {
+		f.Code = append(f.Code,
+			createABx(vm.OP_CLOSURE, 0, i),
+			createABC(vm.OP_CALL, 0, 1, 1))
+		if len(p.Upvalues) > 0 { // 子函数的_ENV引用主函数的_ENV
+			p.Upvalues[0].Instack = 0
+		}
+	}
+	f.Code = append(f.Code, createABC(vm.OP_RETURN, 0, 1, 0))
+	f.LineInfo = make([]uint32, 2*n+1)
+	return f
+}
+
+func createABC(op, a, b, c int) uint32 {
+	return uint32(op | a<<6 | b<<23 | c<<14)
+}
+
+func createABx(op, a, bx int) uint32 {
+	return uint32(op | a<<6 | bx<<14)
+}
+
+func main() {
+	files := doArgs(os.Args[1:])
+	if len(files) == 0 {
+		usage("no input files given")
+	}
+
+	protos := make([]*binchunk.Prototype, len(files))
+	for i, filename := range files {
+		protos[i] = load(filename)
+	}
+	f := combine(protos)
+	if listing > 0 {
+		binchunk.Fprint(os.Stdout, f, listing > 1)
+	}
+	if dumping {
+		var w io.Writer = os.Stdout
+		if outFile != "" {
+			file, err := os.Create(outFile)
+			if err != nil {
+				fatal(fmt.Sprintf("cannot open %s", outFile))
+			}
+			defer file.Close()
+			w = file
+		}
+		if _, err := w.Write(binchunk.Dump(f, stripping)); err != nil {
+			fatal(fmt.Sprintf("cannot write %s", outFile))
+		}
+	}
+}
@@ -20,12 +20,22 @@ stat ::=  ‘;’ |
 */
 type Stat interface{}
 
-type EmptyStat struct{}              // ‘;’
-type BreakStat struct{ Line int }    // break
-type LabelStat struct{ Name string } // ‘::’ Name ‘::’
-type GotoStat struct{ Name string }  // goto Name
-type DoStat struct{ Block *Block }   // do block end
-type FuncCallStat = FuncCallExp      // functioncall
+type EmptyStat struct{}            // ‘;’
+type BreakStat struct{ Line int }  // break
+type DoStat struct{ Block *Block } // do block end
+type FuncCallStat = FuncCallExp    // functioncall
+
+// ‘::’ Name ‘::’
+type LabelStat struct {
+	Line int
+	Name string
+}
+
+// goto Name
+type GotoStat struct {
+	Line int
+	Name string
+}
 
 // if exp then block {elseif exp then block} [else block] end
 type IfStat struct {
@@ -75,13 +85,15 @@ type AssignStat struct {
 	ExpList  []Exp
 }
 
-// local namelist [‘=’ explist]
-// namelist ::= Name {‘,’ Name}
+// local attnamelist [‘=’ explist]
+// attnamelist ::=  Name attrib {‘,’ Name attrib}
+// attrib ::= [‘<’ Name ‘>’]
 // explist ::= exp {‘,’ exp}
 type LocalVarDeclStat struct {
-	LastLine int
-	NameList []string
-	ExpList  []Exp
+	LastLine   int
+	NameList   []string
+	AttribList []string // 和NameList一一对应，"const"、"close"或者空串；没有属性时为nil
+	ExpList    []Exp
 }
 
 // local function Name funcbody
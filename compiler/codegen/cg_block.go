@@ -3,15 +3,36 @@ package codegen
 import . "luago/compiler/ast"
 
 func cgBlock(fi *funcInfo, node *Block) {
-	for _, stat := range node.Stats {
-		cgStat(fi, stat)
-	}
+	cgStats(fi, node.Stats, node.RetExps == nil)
 
 	if node.RetExps != nil {
 		cgRetStat(fi, node.RetExps, node.LastLine)
 	}
 }
 
+// blockEnd表示这些语句之后就是块的结尾，末尾的标签在块内局部变量的作用域之外
+func cgStats(fi *funcInfo, stats []Stat, blockEnd bool) {
+	lastStat := len(stats) - 1
+	if blockEnd {
+		for lastStat >= 0 && isLabelStat(stats[lastStat]) {
+			lastStat--
+		}
+	}
+
+	for i, stat := range stats {
+		if label, ok := stat.(*LabelStat); ok {
+			fi.addLabel(label.Name, label.Line, i > lastStat)
+		} else {
+			cgStat(fi, stat)
+		}
+	}
+}
+
+func isLabelStat(stat Stat) bool {
+	_, ok := stat.(*LabelStat)
+	return ok
+}
+
 func cgRetStat(fi *funcInfo, exps []Exp, lastLine int) {
 	nExps := len(exps)
 	if nExps == 0 {
@@ -26,7 +47,7 @@ func cgRetStat(fi *funcInfo, exps []Exp, lastLine int) {
 				return
 			}
 		}
-		if fcExp, ok := exps[0].(*FuncCallExp); ok {
+		if fcExp, ok := exps[0].(*FuncCallExp); ok && !fi.insideTBC() { // 返回之前还要关闭变量，不能尾调用
 			r := fi.allocReg()
 			cgTailCallExp(fi, fcExp, r)
 			fi.freeReg()
@@ -57,7 +57,7 @@ func cgExp(fi *funcInfo, node Exp, a, n int) {
 
 func cgVarargExp(fi *funcInfo, node *VarargExp, a, n int) {
 	if !fi.isVararg {
-		panic("cannot use '...' outside a vararg function")
+		semError(node.Line, "...", "cannot use '...' outside a vararg function")
 	}
 	fi.emitVararg(node.Line, a, n)
 }
@@ -185,7 +185,9 @@ func cgConcatExp(fi *funcInfo, node *ConcatExp, a int) {
 
 // r[a] := name
 func cgNameExp(fi *funcInfo, node *NameExp, a int) {
-	if r := fi.slotOfLocVar(node.Name); r >= 0 {
+	if k := fi.constOfVar(node.Name, node.Line); k != nil {
+		cgExp(fi, k, a, 1)
+	} else if r := fi.slotOfLocVar(node.Name); r >= 0 {
 		fi.emitMove(node.Line, a, r)
 	} else if idx := fi.indexOfUpval(node.Name); idx >= 0 {
 		fi.emitGetUpval(node.Line, a, idx)
@@ -261,6 +263,11 @@ func prepFuncCall(fi *funcInfo, node *FuncCallExp, a int) int {
 }
 
 func expToOpArg(fi *funcInfo, node Exp, argKinds int) (arg, argKind int) {
+	if nameExp, ok := node.(*NameExp); ok {
+		if k := fi.constOfVar(nameExp.Name, nameExp.Line); k != nil {
+			node = k
+		}
+	}
 	if argKinds&ARG_CONST > 0 {
 		idx := -1
 		switch x := node.(type) {
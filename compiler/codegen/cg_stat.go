@@ -26,8 +26,8 @@ func cgStat(fi *funcInfo, node Stat) {
 		cgLocalVarDeclStat(fi, stat)
 	case *LocalFuncDefStat:
 		cgLocalFuncDefStat(fi, stat)
-	case *LabelStat, *GotoStat:
-		panic("label and goto statements are not supported!")
+	case *GotoStat:
+		cgGotoStat(fi, stat)
 	}
 }
 
@@ -44,7 +44,12 @@ func cgFuncCallStat(fi *funcInfo, node *FuncCallStat) {
 
 func cgBreakStat(fi *funcInfo, node *BreakStat) {
 	pc := fi.emitJmp(node.Line, 0, 0)
-	fi.addBreakJmp(pc)
+	fi.addBreakJmp(node.Line, pc)
+}
+
+func cgGotoStat(fi *funcInfo, node *GotoStat) {
+	pc := fi.emitJmp(node.Line, 0, 0)
+	fi.addGoto(node.Name, node.Line, pc)
 }
 
 func cgDoStat(fi *funcInfo, node *DoStat) {
@@ -96,7 +101,11 @@ func cgRepeatStat(fi *funcInfo, node *RepeatStat) {
 	fi.enterScope(true)
 
 	pcBeforeBlock := fi.pc()
-	cgBlock(fi, node.Block)
+	// until后面的表达式还能看到循环体里的局部变量，所以循环体末尾的标签不算在块的结尾
+	cgStats(fi, node.Block.Stats, false)
+	if node.Block.RetExps != nil {
+		cgRetStat(fi, node.Block.RetExps, node.Block.LastLine)
+	}
 
 	oldRegs := fi.usedRegs
 	a, _ := expToOpArg(fi, node.Exp, ARG_REG)
@@ -216,6 +225,23 @@ func cgForInStat(fi *funcInfo, node *ForInStat) {
 }
 
 func cgLocalVarDeclStat(fi *funcInfo, node *LocalVarDeclStat) {
+	// 最后一个变量是<const>，而且它的初始值是常量时，这个变量成为编译期常量
+	if n := len(node.NameList); node.AttribList != nil && node.AttribList[n-1] == "const" &&
+		len(node.ExpList) == n {
+		if val, ok := fi.constOfExp(node.ExpList[n-1]); ok {
+			if n > 1 {
+				cgLocalVarDeclStat(fi, &LocalVarDeclStat{
+					LastLine:   node.LastLine,
+					NameList:   node.NameList[:n-1],
+					AttribList: node.AttribList[:n-1],
+					ExpList:    node.ExpList[:n-1],
+				})
+			}
+			fi.addConstVar(node.NameList[n-1], val)
+			return
+		}
+	}
+
 	exps := removeTailNils(node.ExpList)
 	nExps := len(exps)
 	nNames := len(node.NameList)
@@ -260,6 +286,15 @@ func cgLocalVarDeclStat(fi *funcInfo, node *LocalVarDeclStat) {
 	for _, name := range node.NameList {
 		fi.addLocVar(name, startPC)
 	}
+	locVars := fi.locVars[len(fi.locVars)-nNames:]
+	for i, attrib := range node.AttribList {
+		switch attrib {
+		case "const":
+			fi.setVarKind(locVars[i], varConst, node.LastLine)
+		case "close":
+			fi.setVarKind(locVars[i], varClose, node.LastLine)
+		}
+	}
 }
 
 func cgAssignStat(fi *funcInfo, node *AssignStat) {
@@ -280,6 +315,7 @@ func cgAssignStat(fi *funcInfo, node *AssignStat) {
 			cgExp(fi, taExp.KeyExp, kRegs[i], 1)
 		} else {
 			name := exp.(*NameExp).Name
+			fi.checkReadOnly(name, node.LastLine)
 			if fi.slotOfLocVar(name) < 0 && fi.indexOfUpval(name) < 0 {
 				// global var
 				kRegs[i] = -1
@@ -10,6 +10,48 @@ func isVarargOrFuncCall(exp Exp) bool {
 	return false
 }
 
+// 表达式是常量（或者编译期常量）时返回它的值
+// lua-5.4.6/src/lcode.c#luaK_exp2const()
+func (f *funcInfo) constOfExp(exp Exp) (interface{}, bool) {
+	switch x := exp.(type) {
+	case *NilExp:
+		return nil, true
+	case *TrueExp:
+		return true, true
+	case *FalseExp:
+		return false, true
+	case *IntegerExp:
+		return x.Val, true
+	case *FloatExp:
+		return x.Val, true
+	case *StringExp:
+		return x.Str, true
+	case *NameExp:
+		if locVar := f.findVar(x.Name); locVar != nil && locVar.kind == varCTC {
+			return locVar.constVal, true
+		}
+	}
+	return nil, false
+}
+
+func constToExp(val interface{}, line int) Exp {
+	switch x := val.(type) {
+	case nil:
+		return &NilExp{Line: line}
+	case bool:
+		if x {
+			return &TrueExp{Line: line}
+		}
+		return &FalseExp{Line: line}
+	case int64:
+		return &IntegerExp{Line: line, Val: x}
+	case float64:
+		return &FloatExp{Line: line, Val: x}
+	default:
+		return &StringExp{Line: line, Str: x.(string)}
+	}
+}
+
 func removeTailNils(exps []Exp) []Exp {
 	for n := len(exps) - 1; n >= 0; n-- {
 		if _, ok := exps[n].(*NilExp); !ok {
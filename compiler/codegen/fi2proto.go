@@ -62,9 +62,9 @@ func getUpvalues(fi *funcInfo) []Upvalue {
 	upvals := make([]Upvalue, len(fi.upvalues))
 	for _, uv := range fi.upvalues {
 		if uv.locVarSlot >= 0 { // instack
-			upvals[uv.index] = Upvalue{1, byte(uv.locVarSlot)}
+			upvals[uv.index] = Upvalue{Instack: 1, Idx: byte(uv.locVarSlot)}
 		} else {
-			upvals[uv.index] = Upvalue{0, byte(uv.upvalIndex)}
+			upvals[uv.index] = Upvalue{Instack: 0, Idx: byte(uv.upvalIndex)}
 		}
 	}
 	return upvals
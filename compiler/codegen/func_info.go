@@ -1,6 +1,7 @@
 package codegen
 
 import (
+	"fmt"
 	. "luago/compiler/ast"
 	. "luago/compiler/lexer"
 
@@ -28,6 +29,15 @@ type upvalInfo struct {
 	index      int
 }
 
+// 局部变量的种类
+// lua-5.4.6/src/lparser.h#VDKREG
+const (
+	varRegular = iota // 普通变量
+	varConst          // <const>变量，只读
+	varClose          // <close>变量，只读，离开作用域时关闭
+	varCTC            // 编译期常量，不占用寄存器，用到的地方直接换成常量
+)
+
 type locVarInfo struct {
 	prev     *locVarInfo
 	name     string
@@ -36,6 +46,25 @@ type locVarInfo struct {
 	startPC  int
 	endPC    int
 	captured bool
+	kind     int
+	constVal interface{} // 编译期常量的值
+}
+
+// 标签和尚未确定目标的goto（break被看作跳转到"break"标签的goto）
+// lua-5.3.4/src/lparser.h#Labeldesc
+type labelDesc struct {
+	name    string
+	line    int // 所在行号
+	pc      int // 标签的位置，或者goto对应的JMP指令的位置
+	nactvar int // 标签或goto处活动局部变量的数量
+	scopeLv int // 所在的作用域层次
+}
+
+// lua-5.3.4/src/lparser.c#BlockCnt
+type blockInfo struct {
+	nactvar   int  // 进入作用域时活动局部变量的数量
+	breakable bool // 是否是循环
+	insideTBC bool // 这个作用域或者外层作用域里是否有待关闭变量
 }
 
 type funcInfo struct {
@@ -48,7 +77,9 @@ type funcInfo struct {
 	locNames  map[string]*locVarInfo // 记录当前生效的局部变量
 	upvalues  map[string]upvalInfo
 	constants map[interface{}]int // 常量表
-	breaks    [][]int
+	blocks    []blockInfo         // 每一层作用域的信息
+	labels    []*labelDesc        // 当前可见的标签
+	gotos     []*labelDesc        // 等待解析的goto
 	insts     []uint32
 	lineNums  []uint32
 	line      int
@@ -57,6 +88,12 @@ type funcInfo struct {
 	isVararg  bool
 }
 
+// 代码生成阶段发现的错误同样作为语法错误报告，chunk名由compiler.Compile()补上
+// lua-5.3.4/src/lparser.c#semerror()
+func semError(line int, near, f string, a ...interface{}) {
+	panic(&SyntaxError{Line: line, Near: near, Msg: fmt.Sprintf(f, a...)})
+}
+
 func newFuncInfo(parent *funcInfo, fd *FuncDefExp) *funcInfo {
 	return &funcInfo{
 		parent:    parent,
@@ -65,7 +102,7 @@ func newFuncInfo(parent *funcInfo, fd *FuncDefExp) *funcInfo {
 		locNames:  map[string]*locVarInfo{},
 		upvalues:  map[string]upvalInfo{},
 		constants: map[interface{}]int{},
-		breaks:    make([][]int, 1),
+		blocks:    make([]blockInfo, 1),
 		insts:     make([]uint32, 0, 8),
 		lineNums:  make([]uint32, 0, 8),
 		line:      fd.Line,
@@ -92,7 +129,7 @@ func (f *funcInfo) indexOfConstant(k interface{}) int {
 func (f *funcInfo) allocReg() int {
 	f.usedRegs++
 	if f.usedRegs >= 255 {
-		panic("function or expression needs too many registers")
+		semError(f.line, "", "function or expression needs too many registers")
 	}
 	if f.usedRegs > f.maxRegs {
 		f.maxRegs = f.usedRegs
@@ -130,22 +167,28 @@ func (f *funcInfo) freeRegs(n int) {
 
 func (f *funcInfo) enterScope(breakable bool) {
 	f.scopeLv++
-	if breakable {
-		f.breaks = append(f.breaks, []int{})
-	} else {
-		f.breaks = append(f.breaks, nil)
-	}
+	insideTBC := f.blocks[len(f.blocks)-1].insideTBC
+	f.blocks = append(f.blocks, blockInfo{f.usedRegs, breakable, insideTBC})
 }
 
+// lua-5.3.4/src/lparser.c#leaveblock()
 func (f *funcInfo) exitScope(endPC int) {
-	pendingBreakJmps := f.breaks[len(f.breaks)-1]
-	f.breaks = f.breaks[:len(f.breaks)-1]
-
-	a := f.getJmpArgA()
-	for _, pc := range pendingBreakJmps {
-		sBx := f.pc() - pc
-		i := (sBx+MAXARG_sBx)<<14 | a<<6 | OP_JMP
-		f.insts[pc] = uint32(i)
+	bl := f.blocks[len(f.blocks)-1]
+	f.blocks = f.blocks[:len(f.blocks)-1]
+
+	// 跳出作用域的goto需要关闭本作用域里被捕获的局部变量
+	hasUpval := f.getJmpArgA() > 0
+	for _, gt := range f.gotos {
+		if gt.scopeLv == f.scopeLv && gt.nactvar > bl.nactvar {
+			if hasUpval {
+				f.patchClose(gt.pc, bl.nactvar)
+			}
+			gt.nactvar = bl.nactvar
+		}
+	}
+	if bl.breakable { // break跳转到循环之后的第一条指令
+		f.resolveGotos(&labelDesc{name: "break", pc: f.pc() + 1,
+			nactvar: bl.nactvar, scopeLv: f.scopeLv})
 	}
 
 	f.scopeLv--
@@ -155,10 +198,41 @@ func (f *funcInfo) exitScope(endPC int) {
 			f.removeLocVar(locVar)
 		}
 	}
+
+	labels := f.labels[:0]
+	for _, lb := range f.labels {
+		if lb.scopeLv <= f.scopeLv {
+			labels = append(labels, lb)
+		}
+	}
+	f.labels = labels
+
+	if f.scopeLv < 0 { // 函数体结束，还有goto找不到标签
+		if len(f.gotos) > 0 {
+			gt := f.gotos[0]
+			semError(f.lastLine, "", "no visible label '%s' for <goto> at line %d", gt.name, gt.line)
+		}
+		return
+	}
+
+	// 剩下的goto移到外层作用域，再到外层查找已经定义过的标签
+	gotos := f.gotos[:0]
+	for _, gt := range f.gotos {
+		if gt.scopeLv > f.scopeLv {
+			gt.scopeLv = f.scopeLv
+			if f.findLabel(gt) {
+				continue
+			}
+		}
+		gotos = append(gotos, gt)
+	}
+	f.gotos = gotos
 }
 
 func (f *funcInfo) removeLocVar(locVar *locVarInfo) {
-	f.freeReg()
+	if locVar.kind != varCTC {
+		f.freeReg()
+	}
 	if locVar.prev == nil {
 		delete(f.locNames, locVar.name)
 	} else if locVar.prev.scopeLv == locVar.scopeLv {
@@ -184,6 +258,60 @@ func (f *funcInfo) addLocVar(name string, startPC int) int {
 	return newVar.slot
 }
 
+// 编译期常量不占用寄存器，也不出现在调试信息里
+// lua-5.4.6/src/lparser.c#localstat()
+func (f *funcInfo) addConstVar(name string, val interface{}) {
+	f.locNames[name] = &locVarInfo{
+		name:     name,
+		prev:     f.locNames[name],
+		scopeLv:  f.scopeLv,
+		slot:     -1,
+		kind:     varCTC,
+		constVal: val,
+	}
+}
+
+// 设置局部变量的种类；待关闭变量需要TBC指令标记，
+// 而且作用域里有待关闭变量时不能进行尾调用
+// lua-5.4.6/src/lparser.c#checktoclose()
+func (f *funcInfo) setVarKind(locVar *locVarInfo, kind int, line int) {
+	locVar.kind = kind
+	if kind == varClose {
+		f.blocks[len(f.blocks)-1].insideTBC = true
+		f.emitTBC(line, locVar.slot)
+	}
+}
+
+func (f *funcInfo) insideTBC() bool {
+	return f.blocks[len(f.blocks)-1].insideTBC
+}
+
+// 按照作用域规则查找变量，包括外层函数的局部变量；全局变量返回nil
+func (f *funcInfo) findVar(name string) *locVarInfo {
+	for fi := f; fi != nil; fi = fi.parent {
+		if locVar, found := fi.locNames[name]; found {
+			return locVar
+		}
+	}
+	return nil
+}
+
+// 变量是编译期常量时，返回在line行使用它的常量表达式，否则返回nil
+func (f *funcInfo) constOfVar(name string, line int) Exp {
+	if locVar := f.findVar(name); locVar != nil && locVar.kind == varCTC {
+		return constToExp(locVar.constVal, line)
+	}
+	return nil
+}
+
+// 给<const>或者<close>变量赋值是编译错误
+// lua-5.4.6/src/lparser.c#check_readonly()
+func (f *funcInfo) checkReadOnly(name string, line int) {
+	if locVar := f.findVar(name); locVar != nil && locVar.kind != varRegular {
+		semError(line, "", "attempt to assign to const variable '%s'", name)
+	}
+}
+
 func (f *funcInfo) slotOfLocVar(name string) int {
 	if locVar, found := f.locNames[name]; found {
 		return locVar.slot
@@ -191,15 +319,93 @@ func (f *funcInfo) slotOfLocVar(name string) int {
 	return -1
 }
 
-func (f *funcInfo) addBreakJmp(pc int) {
+func (f *funcInfo) addBreakJmp(line, pc int) {
 	for i := f.scopeLv; i >= 0; i-- {
-		if f.breaks[i] != nil { // breakable
-			f.breaks[i] = append(f.breaks[i], pc)
+		if f.blocks[i].breakable {
+			f.addGoto("break", line, pc)
 			return
 		}
 	}
 
-	panic("<break> at line ? not inside a loop!")
+	semError(line, "", "<break> at line %d not inside a loop", line)
+}
+
+/* labels and gotos */
+
+// atBlockEnd表示标签后面只剩下标签，这时把它看作在块内所有局部变量的作用域之外
+// lua-5.3.4/src/lparser.c#labelstat()
+func (f *funcInfo) addLabel(name string, line int, atBlockEnd bool) {
+	for _, lb := range f.labels {
+		if lb.scopeLv == f.scopeLv && lb.name == name {
+			semError(line, "", "label '%s' already defined on line %d", name, lb.line)
+		}
+	}
+
+	nactvar := f.usedRegs
+	if atBlockEnd {
+		nactvar = f.blocks[f.scopeLv].nactvar
+	}
+	lb := &labelDesc{name, line, f.pc() + 1, nactvar, f.scopeLv}
+	f.labels = append(f.labels, lb)
+	f.resolveGotos(lb)
+}
+
+// pc是goto对应的JMP指令，标签已经定义（向后跳转）时立即确定目标
+// lua-5.3.4/src/lparser.c#gotostat()
+func (f *funcInfo) addGoto(name string, line, pc int) {
+	gt := &labelDesc{name, line, pc, f.usedRegs, f.scopeLv}
+	if !f.findLabel(gt) {
+		f.gotos = append(f.gotos, gt)
+	}
+}
+
+// 在当前作用域里查找goto的目标标签
+// lua-5.3.4/src/lparser.c#findlabel()
+func (f *funcInfo) findLabel(gt *labelDesc) bool {
+	for _, lb := range f.labels {
+		if lb.scopeLv == f.scopeLv && lb.name == gt.name {
+			if gt.nactvar > lb.nactvar {
+				f.patchClose(gt.pc, lb.nactvar)
+			}
+			f.closeGoto(gt, lb)
+			return true
+		}
+	}
+	return false
+}
+
+// 新定义的标签解决当前作用域里所有等待它的goto
+// lua-5.3.4/src/lparser.c#findgotos()
+func (f *funcInfo) resolveGotos(lb *labelDesc) {
+	gotos := f.gotos[:0]
+	for _, gt := range f.gotos {
+		if gt.scopeLv == f.scopeLv && gt.name == lb.name {
+			f.closeGoto(gt, lb)
+		} else {
+			gotos = append(gotos, gt)
+		}
+	}
+	f.gotos = gotos
+}
+
+// lua-5.3.4/src/lparser.c#closegoto()
+func (f *funcInfo) closeGoto(gt, lb *labelDesc) {
+	if gt.nactvar < lb.nactvar {
+		semError(lb.line, "", "<goto %s> at line %d jumps into the scope of local '%s'",
+			gt.name, gt.line, f.nameOfActiveLocVar(gt.nactvar))
+	}
+	f.fixSbx(gt.pc, lb.pc-gt.pc-1)
+}
+
+func (f *funcInfo) nameOfActiveLocVar(slot int) string {
+	for _, locVar := range f.locNames {
+		for v := locVar; v != nil; v = v.prev {
+			if v.slot == slot {
+				return v.name
+			}
+		}
+	}
+	return "?"
 }
 
 /* upvalues */
@@ -237,7 +443,10 @@ func (f *funcInfo) getJmpArgA() int {
 	for _, locVar := range f.locNames {
 		if locVar.scopeLv == f.scopeLv {
 			for v := locVar; v != nil && v.scopeLv == f.scopeLv; v = v.prev {
-				if v.captured {
+				if v.kind == varCTC {
+					continue
+				}
+				if v.captured || v.kind == varClose { // 待关闭变量和upvalue一起关闭
 					hasCapturedLocVars = true
 				}
 				if v.slot < minSlotOfLocVars && v.name[0] != '(' {
@@ -266,6 +475,14 @@ func (f *funcInfo) fixSbx(pc, sBx int) {
 	f.insts[pc] = i
 }
 
+// 让JMP指令同时关闭level及以上寄存器对应的upvalue
+// lua-5.3.4/src/lcode.c#luaK_patchclose()
+func (f *funcInfo) patchClose(pc, level int) {
+	i := f.insts[pc]
+	i = i&^(0xFF<<6) | uint32(level+1)<<6 // reset A
+	f.insts[pc] = i
+}
+
 // todo: rename?
 func (f *funcInfo) fixEndPC(name string, delta int) {
 	for i := len(f.locVars) - 1; i >= 0; i-- {
@@ -414,6 +631,11 @@ func (f *funcInfo) emitTestSet(line, a, b, c int) {
 	f.emitABC(line, OP_TESTSET, a, b, c)
 }
 
+// mark variable A "to be closed"
+func (f *funcInfo) emitTBC(line, a int) {
+	f.emitABC(line, OP_TBC, a, 0, 0)
+}
+
 func (f *funcInfo) emitForPrep(line, a, sBx int) int {
 	f.emitAsBx(line, OP_FORPREP, a, sBx)
 	return len(f.insts) - 1
@@ -3,14 +3,27 @@ package compiler
 import (
 	"luago/binchunk"
 	"luago/compiler/codegen"
+	"luago/compiler/lexer"
 	"luago/compiler/parser"
 )
 
-func Compile(chunk, chunkName string) *binchunk.Prototype {
+// Compile 编译Lua源码，返回主函数原型。源码有错误时返回*lexer.SyntaxError
+func Compile(chunk, chunkName string) (proto *binchunk.Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*lexer.SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			e.Chunk = chunkName // 代码生成阶段的错误不知道chunk名
+			proto, err = nil, e
+		}
+	}()
+
 	ast := parser.Parse(chunk, chunkName)
-	proto := codegen.GenProto(ast)
+	proto = codegen.GenProto(ast)
 	setSource(proto, chunkName)
-	return proto
+	return proto, nil
 }
 
 func setSource(proto *binchunk.Prototype, chunkName string) {
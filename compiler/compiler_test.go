@@ -0,0 +1,62 @@
+package compiler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"luago/compiler/lexer"
+)
+
+var syntaxErrorTests = []struct {
+	chunk, chunkName, msg string
+}{
+	{"x = ", "=stdin", "stdin:1: syntax error near <eof>"},
+	{"x = = 1", "@test.lua", "test.lua:1: syntax error near '='"},
+	{"\n\nx = \"abc\ny = 1", "=stdin", "stdin:3: unfinished string near '\"abc'"},
+	{"x = 'abc", "=stdin", "stdin:1: unfinished string near <eof>"},
+	{"x = [[\n\n", "=stdin", "stdin:3: unfinished long string (starting at line 1) near <eof>"},
+	{"--[==[\n", "=stdin", "stdin:2: unfinished long comment (starting at line 1) near <eof>"},
+	{"x = '\\q'", "=stdin", "stdin:1: invalid escape sequence near '\\q'"},
+	{"x = '\\300'", "=stdin", "stdin:1: decimal escape too large near '\\300'"},
+	{"x = @", "=stdin", "stdin:1: unexpected symbol near '@'"},
+	{"x = 1e", "=stdin", "stdin:1: malformed number near '1e'"},
+	{"x = 1..2", "=stdin", "stdin:1: malformed number near '1..2'"},
+	{"if x then\n  break\nend", "=stdin", "stdin:2: <break> at line 2 not inside a loop"},
+	{"function f()\n return ...\nend", "=stdin", "stdin:2: cannot use '...' outside a vararg function near '...'"},
+	{"goto l\nlocal x\n::l:: print(x)", "=stdin", "stdin:3: <goto l> at line 1 jumps into the scope of local 'x'"},
+	{"repeat\n local x\n goto c\n local y\n ::c::\nuntil x", "=stdin", "stdin:5: <goto c> at line 3 jumps into the scope of local 'y'"},
+	{"::a::\n::a::", "=stdin", "stdin:2: label 'a' already defined on line 1"},
+	{"do goto l end\ndo ::l:: end", "=stdin", "stdin:2: no visible label 'l' for <goto> at line 1"},
+	{"local x <const> = 1\nx = 2", "=stdin", "stdin:2: attempt to assign to const variable 'x'"},
+	{"local x <close> = nil\nfunction f() x = 1 end", "=stdin", "stdin:2: attempt to assign to const variable 'x'"},
+	{"local x <constant> = 1", "=stdin", "stdin:1: unknown attribute 'constant'"},
+	{"local a <close>, b <close> = f()", "=stdin", "stdin:1: multiple to-be-closed variables in local list"},
+	{"x = ", "x = ", "[string \"x = \"]:1: syntax error near <eof>"},
+	{"x = \n=", "x = \n=", "[string \"x = ...\"]:2: syntax error near '='"},
+	{"x = ", "@" + strings.Repeat("d/", 40) + "f.lua", ".../" + strings.Repeat("d/", 25) + "f.lua:1: syntax error near <eof>"},
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	for _, tt := range syntaxErrorTests {
+		proto, err := Compile(tt.chunk, tt.chunkName)
+		if err == nil {
+			t.Errorf("Compile(%q) = %v, want error", tt.chunk, proto)
+			continue
+		}
+		if err.Error() != tt.msg {
+			t.Errorf("Compile(%q) error = %q, want %q", tt.chunk, err, tt.msg)
+		}
+	}
+}
+
+func TestSyntaxErrorFields(t *testing.T) {
+	_, err := Compile("local a = 1\nlocal b = = 2", "@a.lua")
+	var se *lexer.SyntaxError
+	if !errors.As(err, &se) {
+		t.Fatalf("got %T, want *lexer.SyntaxError", err)
+	}
+	if se.Chunk != "@a.lua" || se.Line != 2 || se.Near != "=" || se.Msg != "syntax error" {
+		t.Errorf("got %+v", se)
+	}
+}
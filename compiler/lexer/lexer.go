@@ -6,13 +6,14 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"luago/number"
 )
 
 var reNewLine = regexp.MustCompile("\r\n|\n\r|\n|\r")
-var reIdentifier = regexp.MustCompile(`^[_\d\w]+`)                                                                                // 正则表达式 ^[_\d\w]+ 匹配以以下字符开头的字符串
-var reNumber = regexp.MustCompile(`^0[xX][0-9a-fA-F]*(\.[0-9a-fA-F]*)?([pP][+\-]?[0-9]+)?|^[0-9]*(\.[0-9]*)?([eE][+\-]?[0-9]+)?`) // 十六进制浮点数（以 0x 或 0X 开头）;十进制浮点数（以数字开头，后面可能跟一个小数点和小数部分）; 十进制指数（以数字开头，后面可能跟一个 e 或 E 和一个指数部分）
-var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`)                // 匹配以 ' 或者 " 开头结尾的字符串
-var reOpeningLongBracket = regexp.MustCompile(`^\[=*\[`)                                                                          // 创建一个正则表达式，该正则表达式匹配以一个或多个 [ 字符开头的字符串，后面跟着零个或多个 = 字符，再跟着一个 [ 字符。
+var reIdentifier = regexp.MustCompile(`^[_\d\w]+`)                                                                 // 正则表达式 ^[_\d\w]+ 匹配以以下字符开头的字符串
+var reShortStr = regexp.MustCompile(`(?s)(^'(\\\\|\\'|\\\n|\\z\s*|[^'\n])*')|(^"(\\\\|\\"|\\\n|\\z\s*|[^"\n])*")`) // 匹配以 ' 或者 " 开头结尾的字符串
+var reOpeningLongBracket = regexp.MustCompile(`^\[=*\[`)                                                           // 创建一个正则表达式，该正则表达式匹配以一个或多个 [ 字符开头的字符串，后面跟着零个或多个 = 字符，再跟着一个 [ 字符。
 
 var reDecEscapeSeq = regexp.MustCompile(`^\\[0-9]{1,3}`)            // 匹配以一个反斜杠 \ 开头，后面跟着一个或多个数字（0到9），数字的数量可以是1到3位。
 var reHexEscapeSeq = regexp.MustCompile(`^\\x[0-9a-fA-F]{2}`)       // 匹配以 \x 开头，后面跟着两个十六进制数字的字符串。
@@ -55,7 +56,7 @@ func (l *Lexer) NextIdentifier() (line int, token string) {
 func (l *Lexer) NextTokenOfKind(kind int) (line int, token string) {
 	line, _kind, token := l.NextToken()
 	if kind != _kind {
-		l.error("syntax error near '%s'", token)
+		l.ErrorNear(token, "syntax error")
 	}
 	return line, token
 }
@@ -72,7 +73,7 @@ func (l *Lexer) NextToken() (line, kind int, token string) {
 
 	l.skipWhiteSpaces()
 	if len(l.chunk) == 0 {
-		return l.line, TOKEN_EOF, "EOF"
+		return l.line, TOKEN_EOF, TOKEN_EOF_NAME
 	}
 
 	switch l.chunk[0] {
@@ -188,7 +189,7 @@ func (l *Lexer) NextToken() (line, kind int, token string) {
 		}
 	case '[':
 		if l.test("[[") || l.test("[=") {
-			return l.line, TOKEN_STRING, l.scanLongString()
+			return l.line, TOKEN_STRING, l.scanLongString("string")
 		} else {
 			l.next(1)
 			return l.line, TOKEN_SEP_LBRACK, "["
@@ -211,7 +212,7 @@ func (l *Lexer) NextToken() (line, kind int, token string) {
 		}
 	}
 
-	l.error("unexpected symbol near %q", c)
+	l.ErrorNear(string(c), "unexpected symbol")
 	return
 }
 
@@ -223,10 +224,14 @@ func (l *Lexer) test(s string) bool {
 	return strings.HasPrefix(l.chunk, s)
 }
 
-func (l *Lexer) error(f string, a ...interface{}) {
-	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d: %s", l.chunkName, l.line, err)
-	panic(err)
+// ErrorNear 在当前行报告语法错误，near是出错位置附近的记号
+func (l *Lexer) ErrorNear(near, f string, a ...interface{}) {
+	panic(&SyntaxError{
+		Chunk: l.chunkName,
+		Line:  l.line,
+		Near:  near,
+		Msg:   fmt.Sprintf(f, a...),
+	})
 }
 
 func (l *Lexer) skipWhiteSpaces() {
@@ -253,7 +258,7 @@ func (l *Lexer) skipComment() {
 	// long comment ?
 	if l.test("[") {
 		if reOpeningLongBracket.FindString(l.chunk) != "" {
-			l.scanLongString()
+			l.scanLongString("comment")
 			return
 		}
 	}
@@ -268,8 +273,37 @@ func (l *Lexer) scanIdentifier() string {
 	return l.scan(reIdentifier)
 }
 
+// 先读入完整的数字（十六进制数字、小数点，以及指数后面的符号），再检查格式，
+// 这样"1e"、"1..2"之类的记号报告malformed number
+// lua-5.3.4/src/llex.c#read_numeral()
 func (l *Lexer) scanNumber() string {
-	return l.scan(reNumber)
+	expo := "Ee"
+	i := 1
+	if l.chunk[0] == '0' && len(l.chunk) > 1 && (l.chunk[1] == 'x' || l.chunk[1] == 'X') { /* hexadecimal? */
+		expo = "Pp"
+		i = 2
+	}
+	for i < len(l.chunk) {
+		c := l.chunk[i]
+		if strings.IndexByte(expo, c) >= 0 { /* exponent part? */
+			i++
+			if i < len(l.chunk) && (l.chunk[i] == '-' || l.chunk[i] == '+') { /* optional exponent sign */
+				i++
+			}
+		} else if isHexDigit(c) || c == '.' {
+			i++
+		} else {
+			break
+		}
+	}
+	token := l.chunk[:i]
+	if _, ok := number.ParseInteger(token); !ok {
+		if _, ok := number.ParseFloat(token); !ok { /* format error? */
+			l.ErrorNear(token, "malformed number")
+		}
+	}
+	l.next(i)
+	return token
 }
 
 func (l *Lexer) scan(re *regexp.Regexp) string {
@@ -280,16 +314,18 @@ func (l *Lexer) scan(re *regexp.Regexp) string {
 	panic("unreachable!")
 }
 
-func (l *Lexer) scanLongString() string {
+func (l *Lexer) scanLongString(what string) string {
+	line := l.line
 	openingLongBracket := reOpeningLongBracket.FindString(l.chunk)
 	if openingLongBracket == "" {
-		l.error("invalid long string delimiter near '%s'", l.chunk[0:2])
+		l.ErrorNear(l.chunk[0:2], "invalid long string delimiter")
 	}
 
 	closingLongBracket := strings.Replace(openingLongBracket, "[", "]", -1)
 	closingLongBracketIdx := strings.Index(l.chunk, closingLongBracket)
 	if closingLongBracketIdx < 0 {
-		l.error("unfinished long string or comment")
+		l.line += len(reNewLine.FindAllString(l.chunk, -1))
+		l.ErrorNear(TOKEN_EOF_NAME, "unfinished long %s (starting at line %d)", what, line)
 	}
 
 	str := l.chunk[len(openingLongBracket):closingLongBracketIdx]
@@ -314,10 +350,23 @@ func (l *Lexer) scanShortString() string {
 		}
 		return str
 	}
-	l.error("unfinished string")
+	l.ErrorNear(l.unfinishedString(), "unfinished string")
 	return ""
 }
 
+// 返回未结束的短字符串（到换行符为止），到达文件结尾时返回<eof>
+func (l *Lexer) unfinishedString() string {
+	for i := 1; i < len(l.chunk); i++ {
+		switch l.chunk[i] {
+		case '\\':
+			i++ // skip escaped char (including newline)
+		case '\n', '\r':
+			return l.chunk[:i]
+		}
+	}
+	return TOKEN_EOF_NAME
+}
+
 func (l *Lexer) escape(str string) string { // escape 转义序列
 	var buf bytes.Buffer
 
@@ -329,7 +378,7 @@ func (l *Lexer) escape(str string) string { // escape 转义序列
 		}
 
 		if len(str) == 1 {
-			l.error("unfinished string")
+			l.ErrorNear(TOKEN_EOF_NAME, "unfinished string")
 		}
 
 		switch str[1] {
@@ -381,7 +430,7 @@ func (l *Lexer) escape(str string) string { // escape 转义序列
 					str = str[len(found):]
 					continue
 				}
-				l.error("decimal escape too large near '%s'", found)
+				l.ErrorNear(found, "decimal escape too large")
 			}
 		case 'x': // \xXX
 			if found := reHexEscapeSeq.FindString(str); found != "" {
@@ -398,7 +447,7 @@ func (l *Lexer) escape(str string) string { // escape 转义序列
 					str = str[len(found):]
 					continue
 				}
-				l.error("UTF-8 value too large near '%s'", found)
+				l.ErrorNear(found, "UTF-8 value too large")
 			}
 		case 'z':
 			str = str[2:]
@@ -407,7 +456,7 @@ func (l *Lexer) escape(str string) string { // escape 转义序列
 			}
 			continue
 		}
-		l.error("invalid escape sequence near '\\%c'", str[1])
+		l.ErrorNear(str[:2], "invalid escape sequence")
 	}
 
 	return buf.String()
@@ -429,6 +478,10 @@ func isDigit(c byte) bool {
 	return c >= '0' && c <= '9'
 }
 
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
 func isLetter(c byte) bool {
 	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
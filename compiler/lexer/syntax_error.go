@@ -0,0 +1,30 @@
+package lexer
+
+import (
+	"fmt"
+	"luago/binchunk"
+)
+
+const TOKEN_EOF_NAME = "<eof>" // 文件结束记号在错误信息里的写法
+
+// SyntaxError 是编译期间（词法分析、语法分析和代码生成）发现的错误
+type SyntaxError struct {
+	Chunk string // chunk名
+	Line  int    // 出错的行号
+	Near  string // 出错位置附近的记号，可以为空
+	Msg   string
+}
+
+// Error 按照官方实现的格式返回错误信息，例如：test.lua:3: unfinished string near '"abc'
+// lua-5.3.4/src/llex.c#lexerror()
+func (e *SyntaxError) Error() string {
+	msg := fmt.Sprintf("%s:%d: %s", binchunk.ChunkID(e.Chunk), e.Line, e.Msg)
+	switch e.Near {
+	case "":
+		return msg
+	case TOKEN_EOF_NAME:
+		return msg + " near " + e.Near
+	default:
+		return fmt.Sprintf("%s near '%s'", msg, e.Near)
+	}
+}
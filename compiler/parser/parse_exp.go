@@ -93,7 +93,6 @@ func parseExp10(lexer *Lexer) Exp {
 			return exp
 		}
 	}
-	return exp
 }
 
 // x | y
@@ -142,7 +141,6 @@ func parseExp6(lexer *Lexer) Exp {
 			return exp
 		}
 	}
-	return exp
 }
 
 // a .. b
@@ -174,7 +172,6 @@ func parseExp4(lexer *Lexer) Exp {
 			return exp
 		}
 	}
-	return exp
 }
 
 // *, %, /, //
@@ -190,7 +187,6 @@ func parseExp3(lexer *Lexer) Exp {
 			return exp
 		}
 	}
-	return exp
 }
 
 // unary
@@ -249,8 +245,9 @@ func parseNumberExp(lexer *Lexer) Exp {
 		return &IntegerExp{Line: line, Val: i}
 	} else if f, ok := number.ParseFloat(token); ok {
 		return &FloatExp{Line: line, Val: f}
-	} else { // todo
-		panic("not a number: " + token)
+	} else {
+		lexer.ErrorNear(token, "malformed number")
+		return nil
 	}
 }
 
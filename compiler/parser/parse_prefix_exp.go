@@ -99,7 +99,6 @@ func _finishPrefixExp(lexer *Lexer, exp Exp) Exp {
 			return exp
 		}
 	}
-	return exp
 }
 
 // functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
@@ -68,17 +68,17 @@ func parseBreakStat(lexer *Lexer) *BreakStat {
 
 // ‘::’ Name ‘::’
 func parseLabelStat(lexer *Lexer) *LabelStat {
-	lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // ::
-	_, name := lexer.NextIdentifier()      // name
-	lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // ::
-	return &LabelStat{Name: name}
+	line, _ := lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // ::
+	_, name := lexer.NextIdentifier()                 // name
+	lexer.NextTokenOfKind(TOKEN_SEP_LABEL)            // ::
+	return &LabelStat{Line: line, Name: name}
 }
 
 // goto Name
 func parseGotoStat(lexer *Lexer) *GotoStat {
-	lexer.NextTokenOfKind(TOKEN_KW_GOTO) // goto
-	_, name := lexer.NextIdentifier()    // name
-	return &GotoStat{Name: name}
+	line, _ := lexer.NextTokenOfKind(TOKEN_KW_GOTO) // goto
+	_, name := lexer.NextIdentifier()               // name
+	return &GotoStat{Line: line, Name: name}
 }
 
 // do block end
@@ -229,17 +229,53 @@ func _finishLocalFuncDefStat(lexer *Lexer) *LocalFuncDefStat {
 	return &LocalFuncDefStat{Name: name, Exp: fdExp}
 }
 
-// local namelist [‘=’ explist]
+// local attnamelist [‘=’ explist]
+// lua-5.4.6/src/lparser.c#localstat()
 func _finishLocalVarDeclStat(lexer *Lexer) *LocalVarDeclStat {
-	_, name0 := lexer.NextIdentifier()        // local Name
-	nameList := _finishNameList(lexer, name0) // { , Name }
+	var nameList, attribList []string
+	hasAttrib, hasClose := false, false
+	for {
+		_, name := lexer.NextIdentifier() // Name
+		attrib := _parseAttrib(lexer)     // attrib
+		if attrib == "close" {            /* to-be-closed? */
+			if hasClose { /* one already present? */
+				lexer.ErrorNear("", "multiple to-be-closed variables in local list")
+			}
+			hasClose = true
+		}
+		hasAttrib = hasAttrib || attrib != ""
+		nameList = append(nameList, name)
+		attribList = append(attribList, attrib)
+		if lexer.LookAhead() != TOKEN_SEP_COMMA {
+			break
+		}
+		lexer.NextToken() // ,
+	}
+	if !hasAttrib {
+		attribList = nil
+	}
 	var expList []Exp = nil
 	if lexer.LookAhead() == TOKEN_OP_ASSIGN {
 		lexer.NextToken()             // ==
 		expList = parseExpList(lexer) // explist
 	}
 	lastLine := lexer.Line()
-	return &LocalVarDeclStat{LastLine: lastLine, NameList: nameList, ExpList: expList}
+	return &LocalVarDeclStat{LastLine: lastLine, NameList: nameList, AttribList: attribList, ExpList: expList}
+}
+
+// attrib ::= [‘<’ Name ‘>’]
+// lua-5.4.6/src/lparser.c#getlocalattribute()
+func _parseAttrib(lexer *Lexer) string {
+	if lexer.LookAhead() != TOKEN_OP_LT {
+		return ""
+	}
+	lexer.NextToken()                   // <
+	_, attrib := lexer.NextIdentifier() // Name
+	lexer.NextTokenOfKind(TOKEN_OP_GT)  // >
+	if attrib != "const" && attrib != "close" {
+		lexer.ErrorNear("", "unknown attribute '%s'", attrib)
+	}
+	return attrib
 }
 
 // varlist ‘=’ explist
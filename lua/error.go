@@ -0,0 +1,22 @@
+package lua
+
+// Error 是运行Lua代码时发生的错误
+type Error struct {
+	Status    int    // Load或者PCall返回的状态码，比如LUA_ERRSYNTAX、LUA_ERRRUN
+	Message   string // 错误对象转换成的字符串
+	Traceback string // 出错时的调用栈回溯，加载代码时的错误没有回溯
+	cause     error  // 因为上下文取消而中断时是ctx.Err()
+}
+
+// Error 返回错误信息，有回溯时附加在后面
+func (e *Error) Error() string {
+	if e.Traceback == "" {
+		return e.Message
+	}
+	return e.Message + "\n" + e.Traceback
+}
+
+// Unwrap 返回中断脚本的上下文错误
+func (e *Error) Unwrap() error {
+	return e.cause
+}
@@ -0,0 +1,229 @@
+package lua_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "luago/api"
+	"luago/lua"
+)
+
+func TestCallGlobal(t *testing.T) {
+	s := lua.NewState()
+	if err := s.DoString(`
+		function add(a, b) return a + b, "sum" end
+		function fail(msg) error({code = 42, msg = msg}) end
+		function fail2() local t = setmetatable({}, {__tostring = function() return "custom" end}); error(t) end
+		config = {name = "app", ports = {80, 443}}
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.CallGlobal("add", 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if len(res) != 2 || res[0].To(&n) != nil || n != 3 || res[1].String() != "sum" {
+		t.Errorf("add: %v", res)
+	}
+	for _, v := range res {
+		v.Release()
+	}
+
+	_, err = s.CallGlobal("add", 1, []int{})
+	var e *lua.Error
+	if !errors.As(err, &e) || e.Status != LUA_ERRRUN ||
+		!strings.Contains(e.Message, "attempt to perform arithmetic") ||
+		!strings.HasPrefix(e.Traceback, "stack traceback:") ||
+		!strings.Contains(e.Traceback, "in function 'add'") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err = s.CallGlobal("fail", "x"); err == nil || err.(*lua.Error).Message != "(error object is a table value)" {
+		t.Errorf("err = %v", err)
+	}
+	if _, err = s.CallGlobal("fail2"); err == nil || err.(*lua.Error).Message != "custom" {
+		t.Errorf("err = %v", err)
+	}
+	if _, err = s.CallGlobal("nofunc"); err == nil || !strings.Contains(err.Error(), "attempt to call a nil value") {
+		t.Errorf("err = %v", err)
+	}
+	if err = s.DoString("x = = 1"); err == nil || err.(*lua.Error).Status != LUA_ERRSYNTAX || err.(*lua.Error).Traceback != "" {
+		t.Errorf("err = %v", err)
+	}
+	if top := s.LuaState().GetTop(); top != 0 {
+		t.Errorf("stack top = %d", top)
+	}
+}
+
+func TestTable(t *testing.T) {
+	s := lua.NewState()
+	if err := s.DoString(`
+		config = {name = "app", ports = {80, 443}}
+		strict = setmetatable({}, {__index = function(t, k) error("no key " .. k) end})
+	`); err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Global("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Release()
+	config, ok := v.Table()
+	if !ok {
+		t.Fatalf("config is %s", v)
+	}
+	name, _ := config.Get("name")
+	if name.String() != "app" {
+		t.Errorf("name = %s", name)
+	}
+	name.Release()
+
+	if err := config.Set("debug", true); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]bool{}
+	if err := config.ForEach(func(k, v lua.Value) error {
+		keys[k.String()] = true
+		return nil
+	}); err != nil || len(keys) != 3 || !keys["debug"] || !keys["ports"] {
+		t.Errorf("keys = %v, err = %v", keys, err)
+	}
+	stop := errors.New("stop")
+	if err := config.ForEach(func(k, v lua.Value) error { return stop }); err != stop {
+		t.Errorf("ForEach err = %v", err)
+	}
+
+	// Value可以作为参数传回Lua
+	tbl := s.NewTable()
+	defer tbl.Release()
+	tbl.Set(1, "a")
+	if err := s.SetGlobal("list", tbl); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DoString(`assert(list[1] == "a")`); err != nil {
+		t.Error(err)
+	}
+
+	strict, _ := s.Global("strict")
+	st, _ := strict.Table()
+	if _, err := st.Get("x"); err == nil || !strings.Contains(err.Error(), "no key x") {
+		t.Errorf("err = %v", err)
+	}
+	if top := s.LuaState().GetTop(); top != 0 {
+		t.Errorf("stack top = %d", top)
+	}
+}
+
+// Value固定的对象不会被回收，Release之后才会被回收
+func TestValueRelease(t *testing.T) {
+	s := lua.NewState()
+	if err := s.DoString(`
+		collected = false
+		function make() return setmetatable({}, {__gc = function() collected = true end}) end
+	`); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.CallGlobal("make")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collected := func() bool {
+		s.LuaState().GC(LUA_GCCOLLECT)
+		v, _ := s.Global("collected")
+		defer v.Release()
+		var b bool
+		v.To(&b)
+		return b
+	}
+	if collected() {
+		t.Error("pinned value was collected")
+	}
+	res[0].Release()
+	res[0].Release()
+	if !collected() {
+		t.Error("released value was not collected")
+	}
+}
+
+func TestDoStringCtx(t *testing.T) {
+	s := lua.NewState()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := s.DoStringCtx(ctx, `while true do pcall(function() while true do end end) end`)
+	if !errors.Is(err, context.DeadlineExceeded) || err.(*lua.Error).Status != LUA_ERRINTERRUPT {
+		t.Fatalf("err = %v", err)
+	}
+	if err := s.DoStringCtx(ctx, `x = 1`); !errors.Is(err, context.DeadlineExceeded) ||
+		err.(*lua.Error).Status != LUA_ERRINTERRUPT {
+		t.Errorf("err = %v", err)
+	}
+	if err := s.DoString(`for i = 1, 10000 do end`); err != nil {
+		t.Errorf("context was not removed: %v", err)
+	}
+}
+
+func TestHostContext(t *testing.T) {
+	s := lua.NewState()
+	ls := s.LuaState()
+	host, cancel := context.WithCancel(context.Background())
+	ls.SetContext(host)
+	ctx, cancel2 := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel2()
+	if err := s.DoStringCtx(ctx, `x = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if ls.Context() != host {
+		t.Fatalf("host context was not restored")
+	}
+	cancel()
+	if err := s.DoString(`while true do end`); err == nil || err.(*lua.Error).Status != LUA_ERRINTERRUPT {
+		t.Errorf("err = %v", err)
+	}
+
+	// 指令数量的宽限期用完之后，DoStringCtx不能让中断重新变得可以捕获
+	s = lua.NewState()
+	ls = s.LuaState()
+	if err := s.DoString(`function f() end`); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := s.Global("f")
+	ls.SetInterruptCatchable(true)
+	ls.SetInstructionLimit(1000)
+	if err := s.DoString(`while true do pcall(function() while true do end end) end`); err == nil {
+		t.Fatal("not interrupted")
+	}
+	if err := s.DoStringCtx(ctx, `x = 1`); err == nil || err.(*lua.Error).Status != LUA_ERRINTERRUPT {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := s.CallGlobal("pcall", f); err == nil || err.(*lua.Error).Status != LUA_ERRINTERRUPT {
+		t.Errorf("interrupt was caught: %v", err)
+	}
+}
+
+func TestForeignValue(t *testing.T) {
+	s1, s2 := lua.NewState(), lua.NewState()
+	v := s2.NewTable()
+	defer v.Release()
+	f, _ := s1.Global("type")
+	if _, err := f.Call(v); err == nil || !strings.Contains(err.Error(), "different State") {
+		t.Errorf("Call: err = %v", err)
+	}
+	g := s1.Globals()
+	if err := g.Set("x", v); err == nil || !strings.Contains(err.Error(), "different State") {
+		t.Errorf("Set: err = %v", err)
+	}
+	if _, err := g.Get(v); err == nil || !strings.Contains(err.Error(), "different State") {
+		t.Errorf("Get: err = %v", err)
+	}
+	if err := s1.SetGlobal("x", lua.Value{}); err != nil {
+		t.Errorf("zero Value: %v", err)
+	}
+	// 同一个LuaState的另一个包装可以共用Value
+	if err := lua.Wrap(s2.LuaState()).SetGlobal("x", v); err != nil {
+		t.Errorf("Wrap: %v", err)
+	}
+}
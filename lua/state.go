@@ -0,0 +1,203 @@
+// Package lua 是建立在LuaState之上的嵌入接口：宿主程序不用再手动维护栈索引，
+// 通过Value句柄持有Lua值，调用Lua函数得到Go的error而不是panic。
+//
+// 所有操作都在保护模式下进行。Value把值固定在注册表里，在调用Release之前不会被回收；
+// 出错时返回*Error，它带有出错时的调用栈回溯。State和它创建的Value都不是并发安全的。
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	. "luago/api"
+	"luago/luar"
+	"luago/state"
+)
+
+// State 包装一个LuaState
+type State struct {
+	ls LuaState
+}
+
+// NewState 创建一个打开了标准库的Lua环境
+func NewState() *State {
+	ls := state.New()
+	ls.OpenLibs()
+	return &State{ls}
+}
+
+// Wrap 包装已有的LuaState
+func Wrap(ls LuaState) *State {
+	return &State{ls}
+}
+
+// LuaState 返回包装的LuaState，可以用来直接操作栈
+func (s *State) LuaState() LuaState {
+	return s.ls
+}
+
+// DoString 加载并运行一段Lua代码
+func (s *State) DoString(code string) error {
+	return s.DoStringCtx(context.Background(), code)
+}
+
+// DoStringCtx 加载并运行一段Lua代码，ctx被取消或者超时的时候中断脚本（运行期间代替LuaState.SetContext的设置，返回时恢复）。
+// 中断产生的错误的状态码是LUA_ERRINTERRUPT，可以用errors.Is和ctx.Err()比较
+func (s *State) DoStringCtx(ctx context.Context, code string) error {
+	if status := s.ls.LoadString(code); status != LUA_OK {
+		return s.newError(status, "")
+	}
+	return s.pcall(ctx, 0, 0)
+}
+
+// CallGlobal 调用全局函数name，返回它的全部返回值
+func (s *State) CallGlobal(name string, args ...interface{}) ([]Value, error) {
+	f, err := s.Global(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Release()
+	return f.Call(args...)
+}
+
+// Global 返回全局变量name的值
+func (s *State) Global(name string) (Value, error) {
+	g := s.Globals()
+	defer g.Release()
+	return g.Get(name)
+}
+
+// SetGlobal 把Go值转换成Lua值，设置为全局变量name
+func (s *State) SetGlobal(name string, v interface{}) error {
+	g := s.Globals()
+	defer g.Release()
+	return g.Set(name, v)
+}
+
+// Globals 返回全局环境表
+func (s *State) Globals() Table {
+	s.ls.PushGlobalTable()
+	defer s.ls.Pop(1)
+	return Table{s.pin(-1)}
+}
+
+// NewTable 创建一个空表
+func (s *State) NewTable() Table {
+	s.ls.NewTable()
+	defer s.ls.Pop(1)
+	return Table{s.pin(-1)}
+}
+
+// 把Go值推入栈顶，Value推入它持有的Lua值，其他值由luar转换。
+// Value必须属于s，调用之前用checkValues检查
+func (s *State) push(x interface{}) {
+	switch x := x.(type) {
+	case Value:
+		s.pushValue(x)
+	case Table:
+		s.pushValue(x.Value)
+	default:
+		luar.Push(s.ls, x)
+	}
+}
+
+// 检查xs里的Value都属于s（或者是零值）。别的State的值在s的注册表里没有引用
+func (s *State) checkValues(xs ...interface{}) error {
+	for _, x := range xs {
+		var v Value
+		switch x := x.(type) {
+		case Value:
+			v = x
+		case Table:
+			v = x.Value
+		default:
+			continue
+		}
+		if v.h != nil && v.h.s.ls != s.ls {
+			return errors.New("lua: Value belongs to a different State")
+		}
+	}
+	return nil
+}
+
+// 在保护模式下调用Go函数f，它在栈顶留下nResults个值
+func (s *State) protect(nResults int, f GoFunction) error {
+	s.ls.PushGoFunction(f)
+	return s.pcall(context.Background(), 0, nResults)
+}
+
+// 在保护模式下调用栈里的函数和nArgs个参数，出错时把栈顶的错误对象转换成*Error。
+// 消息处理函数在调用栈展开之前记录回溯
+func (s *State) pcall(ctx context.Context, nArgs, nResults int) error {
+	ls := s.ls
+	if err := ctx.Err(); err != nil {
+		ls.Pop(nArgs + 1)
+		return &Error{Status: LUA_ERRINTERRUPT, Message: err.Error(), cause: err}
+	}
+	var traceback string
+	base := ls.GetTop() - nArgs /* function index */
+	ls.PushGoFunction(func(ls LuaState) int {
+		ls.Traceback(ls, "", 1)
+		traceback = ls.ToString(-1)
+		ls.Pop(1)
+		return 1 /* keep the original error object */
+	})
+	ls.Insert(base) /* put it under function and args */
+	// 由LuaState检查上下文，取消时中断脚本。返回时恢复宿主原来设置的上下文
+	if ctx.Done() != nil {
+		old := ls.Context()
+		ls.SetContext(ctx)
+		defer ls.SetContext(old)
+	}
+	status := ls.PCall(nArgs, nResults, base)
+	ls.Remove(base) /* remove message handler from the stack */
+	if status == LUA_OK {
+		return nil
+	}
+	e := s.newError(status, traceback)
+	if err := ctx.Err(); err != nil {
+		e.cause = err
+	}
+	return e
+}
+
+// 调用栈里的函数，把全部返回值固定成Value
+func (s *State) call(nArgs int) ([]Value, error) {
+	ls := s.ls
+	base := ls.GetTop() - nArgs - 1
+	if err := s.pcall(context.Background(), nArgs, LUA_MULTRET); err != nil {
+		return nil, err
+	}
+	results := make([]Value, ls.GetTop()-base)
+	for i := range results {
+		results[i] = s.pin(base + 1 + i)
+	}
+	ls.SetTop(base)
+	return results, nil
+}
+
+// 栈顶的错误对象转换成*Error，然后把它弹出。错误对象不是字符串时尝试使用它的__tostring元方法
+func (s *State) newError(status int, traceback string) *Error {
+	ls := s.ls
+	e := &Error{Status: status, Traceback: traceback}
+	if msg, ok := ls.ToStringX(-1); ok {
+		e.Message = msg
+	} else {
+		e.Message = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(-1))
+		if ls.GetMetafield(-1, "__tostring") != LUA_TNIL {
+			ls.Pop(1)
+			ls.PushGoFunction(func(ls LuaState) int {
+				ls.ToString2(1)
+				return 1
+			})
+			ls.PushValue(-2)
+			if ls.PCall(1, 1, 0) == LUA_OK {
+				e.Message = ls.ToString(-1)
+			}
+			ls.Pop(1)
+		}
+	}
+	ls.Pop(1)
+	return e
+}
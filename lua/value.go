@@ -0,0 +1,198 @@
+package lua
+
+import (
+	"errors"
+
+	. "luago/api"
+	"luago/luar"
+)
+
+// Value 是固定在注册表里的Lua值的句柄，持有期间值不会被回收，不再使用时调用Release。
+// 零值表示nil，它没有所属的State，不能调用Call和To
+type Value struct {
+	h *handle
+}
+
+// 值保存在注册表里，ref是它的引用，Release之后是LUA_NOREF
+type handle struct {
+	s   *State
+	ref int
+}
+
+// 把idx处的值固定在注册表里
+func (s *State) pin(idx int) Value {
+	s.ls.PushValue(idx)
+	return Value{&handle{s: s, ref: s.ls.Ref(LUA_REGISTRYINDEX)}}
+}
+
+// 把v持有的值推入栈顶
+func (s *State) pushValue(v Value) {
+	if v.h == nil {
+		s.ls.PushNil()
+		return
+	}
+	if v.h.ref == LUA_NOREF {
+		panic("lua: use of released Value")
+	}
+	s.ls.RawGetI(LUA_REGISTRYINDEX, int64(v.h.ref))
+}
+
+func (v Value) push() {
+	if v.h == nil {
+		panic("lua: push of zero Value")
+	}
+	v.h.s.pushValue(v)
+}
+
+// Release 解除固定，之后v不能再使用。重复调用没有效果
+func (v Value) Release() {
+	if v.h == nil || v.h.ref == LUA_NOREF {
+		return
+	}
+	v.h.s.ls.Unref(LUA_REGISTRYINDEX, v.h.ref)
+	v.h.ref = LUA_NOREF
+}
+
+// State 返回v所属的State，零值返回nil
+func (v Value) State() *State {
+	if v.h == nil {
+		return nil
+	}
+	return v.h.s
+}
+
+// Type 返回值的类型
+func (v Value) Type() LuaType {
+	if v.h == nil {
+		return LUA_TNIL
+	}
+	ls := v.h.s.ls
+	v.push()
+	defer ls.Pop(1)
+	return ls.Type(-1)
+}
+
+// IsNil 判断值是否是nil
+func (v Value) IsNil() bool {
+	return v.Type() == LUA_TNIL
+}
+
+// String 按照tostring的规则把值转换成字符串，__tostring元方法出错时返回错误信息
+func (v Value) String() string {
+	if v.h == nil {
+		return "nil"
+	}
+	s := v.h.s
+	var str string
+	if err := s.protect(0, func(ls LuaState) int {
+		s.pushValue(v)
+		str = ls.ToString2(-1)
+		return 0
+	}); err != nil {
+		return err.(*Error).Message
+	}
+	return str
+}
+
+// To 把值转换成ptr指向的变量的类型，保存到这个变量里，转换规则和luar.To相同
+func (v Value) To(ptr interface{}) error {
+	if v.h == nil {
+		return errors.New("lua: To on zero Value")
+	}
+	ls := v.h.s.ls
+	v.push()
+	defer ls.Pop(1)
+	return luar.To(ls, -1, ptr)
+}
+
+// Call 调用v持有的函数（或者带有__call元方法的值），参数由luar转换，Value参数传递它持有的值。
+// 返回全部返回值
+func (v Value) Call(args ...interface{}) ([]Value, error) {
+	if v.h == nil {
+		return nil, &Error{Status: LUA_ERRRUN, Message: "attempt to call a nil value"}
+	}
+	s := v.h.s
+	if err := s.checkValues(args...); err != nil {
+		return nil, err
+	}
+	if !s.ls.CheckStack(len(args) + 1) {
+		return nil, &Error{Status: LUA_ERRRUN, Message: "too many arguments"}
+	}
+	s.pushValue(v)
+	for _, arg := range args {
+		s.push(arg)
+	}
+	return s.call(len(args))
+}
+
+// Table 值是表时返回它的Table
+func (v Value) Table() (Table, bool) {
+	if v.Type() != LUA_TTABLE {
+		return Table{}, false
+	}
+	return Table{v}, true
+}
+
+// Table 是持有表的Value
+type Table struct {
+	Value
+}
+
+// Get 返回t[key]，可能触发__index元方法
+func (t Table) Get(key interface{}) (Value, error) {
+	s := t.h.s
+	if err := s.checkValues(key); err != nil {
+		return Value{}, err
+	}
+	if err := s.protect(1, func(ls LuaState) int {
+		s.pushValue(t.Value)
+		s.push(key)
+		ls.GetTable(-2)
+		return 1
+	}); err != nil {
+		return Value{}, err
+	}
+	defer s.ls.Pop(1)
+	return s.pin(-1), nil
+}
+
+// Set 执行t[key] = val，可能触发__newindex元方法
+func (t Table) Set(key, val interface{}) error {
+	s := t.h.s
+	if err := s.checkValues(key, val); err != nil {
+		return err
+	}
+	return s.protect(0, func(ls LuaState) int {
+		s.pushValue(t.Value)
+		s.push(key)
+		s.push(val)
+		ls.SetTable(-3)
+		return 0
+	})
+}
+
+// ForEach 用next遍历表（不使用__pairs元方法），对每个键值对调用fn，
+// fn返回错误时停止遍历，ForEach返回这个错误。key和val在fn返回之后被释放
+func (t Table) ForEach(fn func(key, val Value) error) error {
+	s := t.h.s
+	var ferr error
+	err := s.protect(0, func(ls LuaState) int {
+		s.pushValue(t.Value)
+		ls.PushNil()
+		for ls.Next(-2) {
+			k, v := s.pin(-2), s.pin(-1)
+			ferr = fn(k, v)
+			k.Release()
+			v.Release()
+			ls.Pop(1)
+			if ferr != nil {
+				break
+			}
+		}
+		return 0
+	})
+	if ferr != nil {
+		return ferr
+	}
+	return err
+}
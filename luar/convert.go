@@ -0,0 +1,376 @@
+package luar
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	. "luago/api"
+)
+
+// 把idx（绝对索引）处的Lua值转换成t类型的Go值
+func toValue(ls LuaState, idx int, t reflect.Type) (reflect.Value, error) {
+	c := &converter{ls, map[interface{}]bool{}}
+	return c.toValue(idx, t)
+}
+
+// 转换表的时候记录正在转换的表（用ToPointer标识），表直接或者间接引用了自己时报告错误，而不是无限递归
+type converter struct {
+	ls       LuaState
+	visiting map[interface{}]bool
+}
+
+// 开始转换idx处的表，转换完成后调用leave。每一层都要检查栈空间：
+// 遍历时的键和值，以及转换它们时临时复制的一个值
+func (c *converter) enter(idx int) error {
+	if !c.ls.CheckStack(3) {
+		return errors.New("stack overflow")
+	}
+	p := c.ls.ToPointer(idx)
+	if c.visiting[p] {
+		return errors.New("cyclic table")
+	}
+	c.visiting[p] = true
+	return nil
+}
+
+func (c *converter) leave(idx int) {
+	delete(c.visiting, c.ls.ToPointer(idx))
+}
+
+func (c *converter) toValue(idx int, t reflect.Type) (reflect.Value, error) {
+	ls := c.ls
+	if p, ok := toProxy(ls, idx); ok { // 代理直接取出包装的Go值
+		switch {
+		case p.v.Type().AssignableTo(t):
+			return convertTo(p.v, t), nil
+		case p.v.Kind() == reflect.Ptr && p.v.Type().Elem().AssignableTo(t):
+			return convertTo(p.v.Elem(), t), nil
+		}
+		return reflect.Value{}, typeError(ls, idx, t)
+	}
+
+	tp := ls.Type(idx)
+	if tp == LUA_TNIL || tp == LUA_TNONE {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan, reflect.Bool:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, typeError(ls, idx, t)
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			x, err := c.toInterface(idx)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			if x == nil {
+				return reflect.Zero(t), nil
+			}
+			return convertTo(reflect.ValueOf(x), t), nil
+		}
+	case reflect.Bool:
+		if tp == LUA_TBOOLEAN {
+			return reflect.ValueOf(ls.ToBoolean(idx)).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := toInteger(ls, idx); ok {
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(n) {
+				return reflect.Value{}, fmt.Errorf("%d out of range for %s", n, t)
+			}
+			v.SetInt(n)
+			return v, nil
+		} else if tp == LUA_TNUMBER {
+			return reflect.Value{}, fmt.Errorf("number has no integer representation")
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := toInteger(ls, idx); ok {
+			v := reflect.New(t).Elem()
+			if n < 0 || v.OverflowUint(uint64(n)) {
+				return reflect.Value{}, fmt.Errorf("%d out of range for %s", n, t)
+			}
+			v.SetUint(uint64(n))
+			return v, nil
+		} else if tp == LUA_TNUMBER {
+			return reflect.Value{}, fmt.Errorf("number has no integer representation")
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := ls.ToNumberX(idx); ok {
+			return reflect.ValueOf(f).Convert(t), nil
+		}
+	case reflect.String:
+		if ls.IsString(idx) {
+			return reflect.ValueOf(toString(ls, idx)).Convert(t), nil
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && tp == LUA_TSTRING { // 字符串可以转换成[]byte
+			return reflect.ValueOf([]byte(toString(ls, idx))).Convert(t), nil
+		}
+		if tp == LUA_TTABLE {
+			return c.tableToSlice(idx, t)
+		}
+	case reflect.Array:
+		if tp == LUA_TTABLE {
+			return c.tableToArray(idx, t)
+		}
+	case reflect.Map:
+		if tp == LUA_TTABLE {
+			return c.tableToMap(idx, t)
+		}
+	case reflect.Struct:
+		if tp == LUA_TTABLE {
+			return c.tableToStruct(idx, t)
+		}
+	case reflect.Ptr:
+		if tp == LUA_TTABLE { // 表转换成新分配的值，返回它的指针
+			v, err := c.toValue(idx, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			p := reflect.New(t.Elem())
+			p.Elem().Set(v)
+			return p, nil
+		}
+	case reflect.Func:
+		if t == goFunctionType && ls.IsGoFunction(idx) {
+			return reflect.ValueOf(ls.ToGoFunction(idx)), nil
+		}
+	}
+	return reflect.Value{}, typeError(ls, idx, t)
+}
+
+func typeError(ls LuaState, idx int, t reflect.Type) error {
+	return fmt.Errorf("%s expected, got %s", t, ls.TypeName2(idx))
+}
+
+// 赋值给接口类型时需要转换，否则保持原来的类型
+func convertTo(v reflect.Value, t reflect.Type) reflect.Value {
+	if v.Type() != t && t.Kind() == reflect.Interface {
+		return v.Convert(t)
+	}
+	return v
+}
+
+// 和CheckInteger一样，可以转换成整数的字符串也接受
+func toInteger(ls LuaState, idx int) (int64, bool) {
+	if ls.Type(idx) == LUA_TSTRING {
+		ls.PushValue(idx)
+		defer ls.Pop(1)
+		return ls.ToIntegerX(-1)
+	}
+	return ls.ToIntegerX(idx)
+}
+
+// ToString会把栈里的数字替换成字符串，遍历表的时候不能修改键，所以先复制一份
+func toString(ls LuaState, idx int) string {
+	ls.PushValue(idx)
+	s := ls.ToString(-1)
+	ls.Pop(1)
+	return s
+}
+
+// 转换成interface{}：数值是int64或float64，序列是[]interface{}，其他表是映射，
+// 键都是字符串时是map[string]interface{}，否则是map[interface{}]interface{}
+func (c *converter) toInterface(idx int) (interface{}, error) {
+	ls := c.ls
+	switch ls.Type(idx) {
+	case LUA_TNIL, LUA_TNONE:
+		return nil, nil
+	case LUA_TBOOLEAN:
+		return ls.ToBoolean(idx), nil
+	case LUA_TNUMBER:
+		if ls.IsInteger(idx) {
+			return ls.ToInteger(idx), nil
+		}
+		return ls.ToNumber(idx), nil
+	case LUA_TSTRING:
+		return toString(ls, idx), nil
+	case LUA_TTABLE:
+		return c.tableToInterface(idx)
+	case LUA_TUSERDATA, LUA_TLIGHTUSERDATA:
+		if p, ok := toProxy(ls, idx); ok {
+			return p.v.Interface(), nil
+		}
+		return ls.ToUserData(idx), nil
+	case LUA_TTHREAD:
+		return ls.ToThread(idx), nil
+	case LUA_TFUNCTION:
+		if ls.IsGoFunction(idx) {
+			return ls.ToGoFunction(idx), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert a %s value", ls.TypeName2(idx))
+}
+
+func (c *converter) tableToInterface(idx int) (interface{}, error) {
+	ls := c.ls
+	if !ls.CheckStack(2) {
+		return nil, errors.New("stack overflow")
+	}
+	n, count, allStrings := int(ls.RawLen(idx)), 0, true
+	ls.PushNil()
+	for ls.Next(idx) {
+		count++
+		allStrings = allStrings && ls.Type(-2) == LUA_TSTRING
+		ls.Pop(1)
+	}
+	var v reflect.Value
+	var err error
+	switch {
+	case n > 0 && n == count: // 序列
+		v, err = c.tableToSlice(idx, reflect.TypeOf([]interface{}{}))
+	case allStrings:
+		v, err = c.tableToMap(idx, reflect.TypeOf(map[string]interface{}{}))
+	default:
+		v, err = c.tableToMap(idx, reflect.TypeOf(map[interface{}]interface{}{}))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// 表的1..#t部分转换成切片
+func (c *converter) tableToSlice(idx int, t reflect.Type) (reflect.Value, error) {
+	ls := c.ls
+	if err := c.enter(idx); err != nil {
+		return reflect.Value{}, err
+	}
+	defer c.leave(idx)
+	n := int(ls.RawLen(idx))
+	s := reflect.MakeSlice(t, n, n)
+	for i := 0; i < n; i++ {
+		ls.RawGetI(idx, int64(i+1))
+		v, err := c.toValue(ls.GetTop(), t.Elem())
+		ls.Pop(1)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("[%d]: %v", i+1, err)
+		}
+		s.Index(i).Set(v)
+	}
+	return convertTo(s, t), nil
+}
+
+func (c *converter) tableToArray(idx int, t reflect.Type) (reflect.Value, error) {
+	ls := c.ls
+	if err := c.enter(idx); err != nil {
+		return reflect.Value{}, err
+	}
+	defer c.leave(idx)
+	a := reflect.New(t).Elem()
+	n := int(ls.RawLen(idx))
+	for i := 0; i < n && i < t.Len(); i++ {
+		ls.RawGetI(idx, int64(i+1))
+		v, err := c.toValue(ls.GetTop(), t.Elem())
+		ls.Pop(1)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("[%d]: %v", i+1, err)
+		}
+		a.Index(i).Set(v)
+	}
+	return a, nil
+}
+
+func (c *converter) tableToMap(idx int, t reflect.Type) (reflect.Value, error) {
+	ls := c.ls
+	if err := c.enter(idx); err != nil {
+		return reflect.Value{}, err
+	}
+	defer c.leave(idx)
+	m := reflect.MakeMap(t)
+	ls.PushNil()
+	for ls.Next(idx) {
+		top := ls.GetTop()
+		k, err := c.toValue(top-1, t.Key())
+		if err != nil {
+			ls.Pop(2)
+			return reflect.Value{}, fmt.Errorf("key: %v", err)
+		}
+		v, err := c.toValue(top, t.Elem())
+		if err != nil {
+			ls.Pop(2)
+			return reflect.Value{}, fmt.Errorf("[%v]: %v", k, err)
+		}
+		m.SetMapIndex(k, v)
+		ls.Pop(1)
+	}
+	return convertTo(m, t), nil
+}
+
+// 结构体的每个导出字段取表里同名（或者lua标签指定的名字）的值，表里没有的字段保持零值
+func (c *converter) tableToStruct(idx int, t reflect.Type) (reflect.Value, error) {
+	ls := c.ls
+	if err := c.enter(idx); err != nil {
+		return reflect.Value{}, err
+	}
+	defer c.leave(idx)
+	s := reflect.New(t).Elem()
+	for _, f := range reflect.VisibleFields(t) {
+		name := luaFieldName(f)
+		if name == "" {
+			continue
+		}
+		ls.GetField(idx, name)
+		if ls.IsNil(-1) {
+			ls.Pop(1)
+			continue
+		}
+		v, err := c.toValue(ls.GetTop(), f.Type)
+		ls.Pop(1)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("field '%s': %v", name, err)
+		}
+		fv, err := fieldForWrite(s, f.Index)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("field '%s': %v", name, err)
+		}
+		fv.Set(v)
+	}
+	return s, nil
+}
+
+// 和FieldByIndex相同，但是路径上为nil的嵌入指针会先分配一个新的结构体；
+// 嵌入的是未导出的结构体类型时没法分配，返回错误
+func fieldForWrite(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot assign through nil embedded pointer %s", v.Type())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// 字段在Lua里的名字：lua标签指定的名字，或者字段名；未导出的字段、嵌入的字段本身和标签是"-"的字段返回空串
+func luaFieldName(f reflect.StructField) string {
+	if !f.IsExported() || f.Anonymous {
+		return ""
+	}
+	switch tag := f.Tag.Get("lua"); tag {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	default:
+		return tag
+	}
+}
+
+// 根据Lua里的名字查找结构体字段
+func fieldByLuaName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for _, f := range reflect.VisibleFields(t) {
+		if luaFieldName(f) == name {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
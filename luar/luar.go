@@ -0,0 +1,166 @@
+// Package luar 通过反射在Go值和Lua值之间转换，导出给脚本的Go函数不用再直接操作栈。
+//
+// Push把Go值推入栈顶：布尔值、数值和字符串转换成对应的Lua值；结构体、映射、切片、数组、指针和通道
+// 包装成代理（完全用户数据），脚本通过__index、__newindex、__len、__pairs等元方法访问它们；
+// 函数包装成Go函数，调用时自动检查和转换参数，多个返回值依次推入栈顶。
+// To把Lua值转换成指定类型的Go值，表可以转换成结构体（字段名由lua标签指定）、映射和切片。
+package luar
+
+import (
+	"errors"
+	"reflect"
+
+	. "luago/api"
+)
+
+const proxyMeta = "luar.proxy" // 代理的元表在注册表里的名字
+
+// 代理是完全用户数据包装的Go值
+type proxy struct {
+	v reflect.Value
+}
+
+var (
+	goFunctionType = reflect.TypeOf(GoFunction(nil))
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Push 把任意Go值推入栈顶
+func Push(ls LuaState, v interface{}) {
+	pushValue(ls, reflect.ValueOf(v))
+}
+
+// Register 把Go值转换成Lua值，设置为全局变量name
+func Register(ls LuaState, name string, v interface{}) {
+	Push(ls, v)
+	ls.SetGlobal(name)
+}
+
+// FuncOf 把Go函数包装成GoFunction。参数按照函数的参数类型检查和转换，不能转换时报告bad argument错误；
+// 最后一个返回值是error时，非nil的错误作为Lua错误抛出，否则丢弃，其余返回值依次推入栈顶
+func FuncOf(fn interface{}) GoFunction {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		panic("luar: FuncOf requires a function, got " + v.Kind().String())
+	}
+	if f, ok := fn.(GoFunction); ok {
+		return f
+	}
+	return func(ls LuaState) int {
+		return callFunc(ls, v, 1)
+	}
+}
+
+// To 把idx处的Lua值转换成ptr指向的变量的类型，保存到这个变量里
+func To(ls LuaState, idx int, ptr interface{}) error {
+	p := reflect.ValueOf(ptr)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return errors.New("luar: To requires a non-nil pointer")
+	}
+	v, err := toValue(ls, ls.AbsIndex(idx), p.Type().Elem())
+	if err != nil {
+		return err
+	}
+	p.Elem().Set(v)
+	return nil
+}
+
+func pushValue(ls LuaState, v reflect.Value) {
+	if !v.IsValid() {
+		ls.PushNil()
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		ls.PushBoolean(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ls.PushInteger(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		ls.PushInteger(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		ls.PushNumber(v.Float())
+	case reflect.String:
+		ls.PushString(v.String())
+	case reflect.Interface:
+		pushValue(ls, v.Elem())
+	case reflect.Func:
+		switch {
+		case v.IsNil():
+			ls.PushNil()
+		case v.Type() == goFunctionType:
+			ls.PushGoFunction(v.Interface().(GoFunction))
+		case v.NumMethod() > 0: // 有方法的函数类型包装成代理，既可以调用，也可以访问方法
+			pushProxy(ls, v)
+		default:
+			fn := v
+			ls.PushGoFunction(func(ls LuaState) int {
+				return callFunc(ls, fn, 1)
+			})
+		}
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan:
+		if v.IsNil() {
+			ls.PushNil()
+		} else {
+			pushProxy(ls, v)
+		}
+	default:
+		pushProxy(ls, v)
+	}
+}
+
+// 结构体和数组复制一份可以寻址的副本，这样脚本才能修改它们的字段和元素，调用指针接收者的方法
+func pushProxy(ls LuaState, v reflect.Value) {
+	if k := v.Kind(); (k == reflect.Struct || k == reflect.Array) && !v.CanAddr() {
+		p := reflect.New(v.Type()).Elem()
+		p.Set(v)
+		v = p
+	}
+	ls.PushUserData(&proxy{v})
+	if ls.NewMetatable(proxyMeta) {
+		ls.SetFuncs(proxyMetamethods, 0)
+	}
+	ls.SetMetatable(-2)
+}
+
+// 如果idx处是代理，返回它包装的Go值
+func toProxy(ls LuaState, idx int) (*proxy, bool) {
+	p, ok := ls.ToUserData(idx).(*proxy)
+	return p, ok
+}
+
+// 调用Go函数，参数从栈里first处开始；多出的参数被忽略，缺少的参数按nil转换
+func callFunc(ls LuaState, fn reflect.Value, first int) int {
+	t := fn.Type()
+	nIn := t.NumIn()
+	args := make([]reflect.Value, 0, nIn)
+	for i := 0; i < nIn; i++ {
+		if t.IsVariadic() && i == nIn-1 {
+			for idx := first + i; idx <= ls.GetTop(); idx++ {
+				args = append(args, checkArg(ls, idx, t.In(i).Elem()))
+			}
+			break
+		}
+		args = append(args, checkArg(ls, first+i, t.In(i)))
+	}
+
+	results := fn.Call(args)
+	if n := len(results); n > 0 && t.Out(n-1) == errorType {
+		if err := results[n-1]; !err.IsNil() {
+			return ls.Error2("%s", err.Interface().(error).Error())
+		}
+		results = results[:n-1]
+	}
+	ls.CheckStack2(len(results), "too many results")
+	for _, r := range results {
+		pushValue(ls, r)
+	}
+	return len(results)
+}
+
+func checkArg(ls LuaState, idx int, t reflect.Type) reflect.Value {
+	v, err := toValue(ls, idx, t)
+	if err != nil {
+		ls.ArgError(idx, err.Error())
+	}
+	return v
+}
@@ -0,0 +1,253 @@
+package luar_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "luago/api"
+	"luago/luar"
+	"luago/state"
+)
+
+type address struct {
+	City string `lua:"city"`
+	Zip  string `lua:"-"`
+}
+
+type person struct {
+	Name    string `lua:"name"`
+	Age     int    `lua:"age"`
+	Address address
+	Tags    []string
+	secret  string
+}
+
+func (p *person) Greet(greeting string) string {
+	return greeting + ", " + p.Name
+}
+
+func (p person) Split() (string, int) {
+	return p.Name, p.Age
+}
+
+type point struct{ X, Y int }
+
+type Inner struct{ Y int }
+
+type inner struct{ Z int }
+
+// 嵌入的指针为nil：读字段得到nil，写字段时分配（未导出的类型没法分配，报告错误）
+type outer struct {
+	*Inner
+	*inner
+}
+
+func (p point) String() string { return fmt.Sprintf("(%d, %d)", p.X, p.Y) }
+
+func runLua(t *testing.T, ls LuaState, code string) {
+	t.Helper()
+	if ls.LoadString(code) != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+func TestProxy(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	p := &person{Name: "Ann", Age: 30, Address: address{City: "Oslo"}, Tags: []string{"a", "b"}}
+	luar.Register(ls, "p", p)
+	luar.Register(ls, "m", map[string]int{"one": 1, "two": 2})
+	luar.Register(ls, "arr", [3]int{10, 20, 30})
+	luar.Register(ls, "ch", make(chan int, 2))
+	luar.Register(ls, "pt", point{1, 2})
+	o := &outer{}
+	luar.Register(ls, "o", o)
+
+	runLua(t, ls, `
+		assert(p.name == "Ann" and p.age == 30 and p.secret == nil)
+		assert(p.Address.city == "Oslo" and p.Address.Zip == nil)
+		p.age = p.age + 1
+		p.Address.city = "Bergen"
+		assert(p:Greet("Hello") == "Hello, Ann")
+		local name, age = p:Split()
+		assert(name == "Ann" and age == 31)
+		assert(#p.Tags == 2 and p.Tags[1] == "a" and p.Tags[3] == nil)
+		p.Tags[2] = "z"
+		local ok, err = pcall(function() p.age = "old" end)
+		assert(not ok and err:find("int expected, got string"), err)
+		ok, err = pcall(function() p.nope = 1 end)
+		assert(not ok and err:find("no field 'nope'"), err)
+
+		assert(m.one == 1 and m.three == nil and #m == 2)
+		m.three = 3
+		m.one = nil
+		local sum = 0
+		for k, v in pairs(m) do sum = sum + v end
+		assert(sum == 5)
+
+		assert(#arr == 3 and arr[2] == 20)
+		local n = 0
+		for i, v in pairs(arr) do n = n + i * v end
+		assert(n == 10 + 40 + 90)
+
+		ch:send(7)
+		local v, ok = ch:recv()
+		assert(v == 7 and ok)
+		ch:close()
+		v, ok = ch:recv()
+		assert(v == nil and not ok)
+
+		assert(tostring(pt) == "(1, 2)")
+		assert(tostring(p):find("^%*luar_test.person: "))
+		assert(p == p and p.Address == p.Address)
+
+		assert(o.Y == nil)
+		for k in pairs(o) do error("unexpected field " .. k) end
+		o.Y = 7
+		assert(o.Y == 7)
+		ok, err = pcall(function() o.Z = 1 end)
+		assert(not ok and err:find("nil embedded pointer"), err)
+	`)
+	if o.Inner == nil || o.Y != 7 {
+		t.Errorf("o = %+v", *o)
+	}
+	if p.Age != 31 || p.Address.City != "Bergen" || p.Tags[1] != "z" {
+		t.Errorf("p = %+v", *p)
+	}
+}
+
+func TestFuncOf(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.Register("add", luar.FuncOf(func(a, b int) int { return a + b }))
+	ls.Register("join", luar.FuncOf(func(sep string, parts ...string) string {
+		return strings.Join(parts, sep)
+	}))
+	ls.Register("div", luar.FuncOf(func(a, b float64) (float64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	}))
+	ls.Register("swap", luar.FuncOf(func(a, b interface{}) (interface{}, interface{}) { return b, a }))
+	ls.Register("mkperson", luar.FuncOf(func(p person) *person { return &p }))
+	luar.Register(ls, "upper", strings.ToUpper)
+
+	runLua(t, ls, `
+		assert(add(1, 2) == 3 and add("3", 4) == 7)
+		local ok, err = pcall(add, 1, "x")
+		assert(not ok and err:find("bad argument #2 to 'add' %(int expected, got string%)"), err)
+		ok, err = pcall(add, 1.5, 1)
+		assert(not ok and err:find("bad argument #1 to 'add' %(number has no integer representation%)"), err)
+		assert(join("-", "a", "b", "c") == "a-b-c" and join(",") == "")
+		assert(div(1, 4) == 0.25)
+		ok, err = pcall(div, 1, 0)
+		assert(not ok and err:find("division by zero"), err)
+		local x, y = swap(1, "s")
+		assert(x == "s" and y == 1)
+		local p = mkperson{name = "Bob", age = 5, Address = {city = "Rome"}, Tags = {"x"}}
+		assert(p.name == "Bob" and p.Address.city == "Rome" and p.Tags[1] == "x")
+		ok, err = pcall(mkperson, {age = "old"})
+		assert(not ok and err:find("field 'age': int expected, got string"), err)
+		assert(upper("abc") == "ABC")
+	`)
+}
+
+func TestTo(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	runLua(t, ls, `
+		p = {name = "Eve", age = 40, Address = {city = "Paris", Zip = "75000"}, Tags = {"q", "r"}}
+		m = {a = 1, b = 2.5}
+		list = {1, "two", {3}}
+		bytes = "xyz"
+		big = 300
+		o = {Y = 6}
+		o2 = {Z = 1}
+	`)
+
+	var p person
+	ls.GetGlobal("p")
+	if err := luar.To(ls, -1, &p); err != nil {
+		t.Fatal(err)
+	}
+	want := person{Name: "Eve", Age: 40, Address: address{City: "Paris"}, Tags: []string{"q", "r"}}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("p = %+v", p)
+	}
+
+	var m map[string]float64
+	ls.GetGlobal("m")
+	if err := luar.To(ls, -1, &m); err != nil || m["a"] != 1 || m["b"] != 2.5 {
+		t.Errorf("m = %v, %v", m, err)
+	}
+
+	var list interface{}
+	ls.GetGlobal("list")
+	if err := luar.To(ls, -1, &list); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(1), "two", []interface{}{int64(3)}}; !reflect.DeepEqual(list, want) {
+		t.Errorf("list = %#v", list)
+	}
+
+	var b []byte
+	ls.GetGlobal("bytes")
+	if err := luar.To(ls, -1, &b); err != nil || string(b) != "xyz" {
+		t.Errorf("bytes = %q, %v", b, err)
+	}
+
+	var u8 uint8
+	ls.GetGlobal("big")
+	if err := luar.To(ls, -1, &u8); err == nil || err.Error() != "300 out of range for uint8" {
+		t.Errorf("err = %v", err)
+	}
+
+	var o outer
+	ls.GetGlobal("o")
+	if err := luar.To(ls, -1, &o); err != nil || o.Inner == nil || o.Y != 6 {
+		t.Errorf("o = %+v, %v", o, err)
+	}
+	ls.GetGlobal("o2")
+	if err := luar.To(ls, -1, &o); err == nil || !strings.Contains(err.Error(), "field 'Z'") {
+		t.Errorf("err = %v", err)
+	}
+
+	luar.Push(ls, &p)
+	var q *person
+	if err := luar.To(ls, -1, &q); err != nil || q != &p {
+		t.Errorf("q = %p, %v", q, err)
+	}
+	ls.SetTop(0)
+
+	// 嵌套很深的表，以及引用了自己的表
+	runLua(t, ls, `
+		deep = {}
+		local t = deep
+		for i = 1, 100 do t.next = {i}; t = t.next end
+		cyclic = {name = "c"}
+		cyclic.self = cyclic
+		shared = {}
+		dag = {shared, shared}
+	`)
+	var deep interface{}
+	ls.GetGlobal("deep")
+	if err := luar.To(ls, -1, &deep); err != nil {
+		t.Errorf("deep: %v", err)
+	}
+	for _, x := range []interface{}{new(interface{}), new(map[string]interface{})} {
+		ls.GetGlobal("cyclic")
+		if err := luar.To(ls, -1, x); err == nil || err.Error() != "[self]: cyclic table" {
+			t.Errorf("%T: err = %v", x, err)
+		}
+	}
+	var dag [][]int
+	ls.GetGlobal("dag")
+	if err := luar.To(ls, -1, &dag); err != nil || len(dag) != 2 {
+		t.Errorf("dag = %v, %v", dag, err)
+	}
+	ls.SetTop(0)
+}
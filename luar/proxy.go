@@ -0,0 +1,294 @@
+package luar
+
+import (
+	"fmt"
+	"reflect"
+
+	. "luago/api"
+)
+
+// 代理的元方法。元方法会间接引用proxyMetamethods，所以在init里初始化
+var proxyMetamethods FuncReg
+
+func init() {
+	proxyMetamethods = FuncReg{
+		"__index":    proxyIndex,
+		"__newindex": proxyNewIndex,
+		"__call":     proxyCall,
+		"__len":      proxyLen,
+		"__pairs":    proxyPairs,
+		"__eq":       proxyEq,
+		"__tostring": proxyToString,
+	}
+}
+
+func checkProxy(ls LuaState, idx int) reflect.Value {
+	p, ok := toProxy(ls, idx)
+	if !ok {
+		ls.ArgError(idx, "Go value expected")
+	}
+	return p.v
+}
+
+// 指向结构体或数组的指针按照它们指向的值访问
+func indirect(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		if k := v.Elem().Kind(); k == reflect.Struct || k == reflect.Array {
+			return v.Elem()
+		}
+	}
+	return v
+}
+
+// p[k]：结构体字段、映射的元素、切片和数组的元素（索引从1开始），以及值的方法
+func proxyIndex(ls LuaState) int {
+	v := checkProxy(ls, 1)
+	e := indirect(v)
+	switch e.Kind() {
+	case reflect.Struct:
+		if name, ok := ls.ToStringX(2); ok {
+			if f, ok := fieldByLuaName(e.Type(), name); ok {
+				if fv, err := e.FieldByIndexErr(f.Index); err == nil {
+					pushField(ls, fv)
+				} else { /* 经过了为nil的嵌入指针 */
+					ls.PushNil()
+				}
+				return 1
+			}
+		}
+	case reflect.Map:
+		if k, err := toValue(ls, 2, e.Type().Key()); err == nil {
+			if x := e.MapIndex(k); x.IsValid() {
+				pushValue(ls, x)
+				return 1
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if i, ok := ls.ToIntegerX(2); ok {
+			if i >= 1 && i <= int64(e.Len()) {
+				pushField(ls, e.Index(int(i-1)))
+			} else {
+				ls.PushNil()
+			}
+			return 1
+		}
+	case reflect.Chan:
+		if name, ok := ls.ToStringX(2); ok {
+			if f := chanMethod(name); f != nil {
+				ls.PushGoFunction(f)
+				return 1
+			}
+		}
+	}
+	if name, ok := ls.ToStringX(2); ok {
+		if m := methodByName(v, name); m.IsValid() {
+			ls.PushGoFunction(func(ls LuaState) int {
+				return callFunc(ls, m, 2) // 第一个参数是代理自己（冒号调用）
+			})
+			return 1
+		}
+	}
+	ls.PushNil()
+	return 1
+}
+
+// 可以寻址的结构体和数组推入它们自己的代理，这样p.a.b = x能够修改到原来的值
+func pushField(ls LuaState, f reflect.Value) {
+	if k := f.Kind(); (k == reflect.Struct || k == reflect.Array) && f.CanAddr() {
+		pushProxy(ls, f.Addr())
+	} else {
+		pushValue(ls, f)
+	}
+}
+
+// 优先查找指针接收者的方法
+func methodByName(v reflect.Value, name string) reflect.Value {
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		if m := v.Addr().MethodByName(name); m.IsValid() {
+			return m
+		}
+	}
+	return v.MethodByName(name)
+}
+
+// p[k] = x：修改结构体字段、映射的元素（x是nil时删除元素）和切片、数组的元素
+func proxyNewIndex(ls LuaState) int {
+	v := indirect(checkProxy(ls, 1))
+	switch v.Kind() {
+	case reflect.Struct:
+		name := ls.CheckString(2)
+		f, ok := fieldByLuaName(v.Type(), name)
+		if !ok {
+			return ls.Error2("no field '%s' in %s", name, v.Type())
+		}
+		fv, err := fieldForWrite(v, f.Index)
+		if err != nil {
+			return ls.Error2("field '%s' of %s: %s", name, v.Type(), err.Error())
+		}
+		if !fv.CanSet() {
+			return ls.Error2("cannot assign to field '%s' of %s", name, v.Type())
+		}
+		fv.Set(checkValue(ls, 3, fv.Type()))
+	case reflect.Map:
+		k := checkValue(ls, 2, v.Type().Key())
+		if ls.IsNil(3) {
+			v.SetMapIndex(k, reflect.Value{})
+		} else {
+			v.SetMapIndex(k, checkValue(ls, 3, v.Type().Elem()))
+		}
+	case reflect.Slice, reflect.Array:
+		i := ls.CheckInteger(2)
+		if i < 1 || i > int64(v.Len()) {
+			return ls.Error2("index %d out of range [1, %d]", i, v.Len())
+		}
+		ev := v.Index(int(i - 1))
+		if !ev.CanSet() {
+			return ls.Error2("cannot assign to element of %s", v.Type())
+		}
+		ev.Set(checkValue(ls, 3, ev.Type()))
+	default:
+		return ls.Error2("cannot assign to a field of %s", v.Type())
+	}
+	return 0
+}
+
+// 和checkArg不同，出错时的信息不带参数位置
+func checkValue(ls LuaState, idx int, t reflect.Type) reflect.Value {
+	v, err := toValue(ls, idx, t)
+	if err != nil {
+		ls.Error2("%s", err.Error())
+	}
+	return v
+}
+
+// p(...)：调用包装的函数
+func proxyCall(ls LuaState) int {
+	v := checkProxy(ls, 1)
+	if v.Kind() != reflect.Func {
+		return ls.Error2("attempt to call a %s value", v.Type())
+	}
+	return callFunc(ls, v, 2)
+}
+
+// #p：映射、切片、数组、通道和字符串的长度
+func proxyLen(ls LuaState) int {
+	v := indirect(checkProxy(ls, 1))
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Chan, reflect.String:
+		ls.PushInteger(int64(v.Len()))
+		return 1
+	}
+	return ls.Error2("attempt to get length of a %s value", v.Type())
+}
+
+// pairs(p)：遍历映射的元素、切片和数组的元素（索引从1开始）或者结构体的字段
+func proxyPairs(ls LuaState) int {
+	v := indirect(checkProxy(ls, 1))
+	var next GoFunction
+	switch v.Kind() {
+	case reflect.Map:
+		iter := v.MapRange()
+		next = func(ls LuaState) int {
+			if !iter.Next() {
+				return 0
+			}
+			pushValue(ls, iter.Key())
+			pushValue(ls, iter.Value())
+			return 2
+		}
+	case reflect.Slice, reflect.Array:
+		i := 0
+		next = func(ls LuaState) int {
+			if i >= v.Len() {
+				return 0
+			}
+			i++
+			ls.PushInteger(int64(i))
+			pushField(ls, v.Index(i-1))
+			return 2
+		}
+	case reflect.Struct:
+		fields := reflect.VisibleFields(v.Type())
+		i := 0
+		next = func(ls LuaState) int {
+			for ; i < len(fields); i++ {
+				if name := luaFieldName(fields[i]); name != "" {
+					fv, err := v.FieldByIndexErr(fields[i].Index)
+					if err != nil { /* 经过了为nil的嵌入指针，当作nil跳过 */
+						continue
+					}
+					ls.PushString(name)
+					pushField(ls, fv)
+					i++
+					return 2
+				}
+			}
+			return 0
+		}
+	default:
+		return ls.Error2("cannot iterate over a %s value", v.Type())
+	}
+	ls.PushGoFunction(next)
+	ls.PushValue(1)
+	ls.PushNil()
+	return 3
+}
+
+// 同一类型的可比较的值按照Go的==比较
+func proxyEq(ls LuaState) int {
+	p1, ok1 := toProxy(ls, 1)
+	p2, ok2 := toProxy(ls, 2)
+	ls.PushBoolean(ok1 && ok2 && p1.v.Type() == p2.v.Type() &&
+		p1.v.Type().Comparable() && p1.v.CanInterface() && p2.v.CanInterface() &&
+		p1.v.Interface() == p2.v.Interface())
+	return 1
+}
+
+// 实现了fmt.Stringer的值调用String方法，其他值显示类型和地址
+func proxyToString(ls LuaState) int {
+	v := checkProxy(ls, 1)
+	if v.CanInterface() {
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			ls.PushString(s.String())
+			return 1
+		}
+	}
+	ls.PushFString("%s: %p", v.Type().String(), ls.ToPointer(1))
+	return 1
+}
+
+// 通道的方法：ch:send(x)、ch:recv()返回值和是否成功、ch:close()
+func chanMethod(name string) GoFunction {
+	switch name {
+	case "send":
+		return chanSend
+	case "recv":
+		return chanRecv
+	case "close":
+		return chanClose
+	}
+	return nil
+}
+
+func chanSend(ls LuaState) int {
+	v := checkProxy(ls, 1)
+	v.Send(checkArg(ls, 2, v.Type().Elem()))
+	return 0
+}
+
+func chanRecv(ls LuaState) int {
+	v := checkProxy(ls, 1)
+	x, ok := v.Recv()
+	if ok {
+		pushValue(ls, x)
+	} else {
+		ls.PushNil()
+	}
+	ls.PushBoolean(ok)
+	return 2
+}
+
+func chanClose(ls LuaState) int {
+	checkProxy(ls, 1).Close()
+	return 0
+}
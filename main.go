@@ -1,23 +1,506 @@
+// lua 是独立的Lua解释器，用法和参数与官方的lua保持一致（lua-5.3.4/src/lua.c）
 package main
 
 import (
-	"io/ioutil"
+	"fmt"
 	. "luago/api"
 	"luago/state"
+	"luago/stdlib"
 	"os"
+	"strings"
 )
 
+const (
+	progName   = "lua"
+	prompt     = "> "
+	prompt2    = ">> "
+	copyright  = "Lua 5.3 (luago)"
+	initVar    = "LUA_INIT"
+	initVarVer = "LUA_INIT_5_3"
+	eofMark    = "<eof>" // 语法错误信息以它结尾时，说明输入还不完整
+)
+
+/* bits of various argument indicators in 'args' */
+const (
+	has_error = 1  /* bad option */
+	has_i     = 2  /* -i */
+	has_v     = 4  /* -v */
+	has_e     = 8  /* -e */
+	has_E     = 16 /* -E */
+)
+
+// 和io库共用标准输入的读缓冲，交互模式下脚本调用io.read()能读到后面的输入
+var stdin = stdlib.Stdin
+
+func printUsage(badOption string) {
+	if badOption[1] == 'e' || badOption[1] == 'l' {
+		fmt.Fprintf(os.Stderr, "%s: '%s' needs argument\n", progName, badOption)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: unrecognized option '%s'\n", progName, badOption)
+	}
+	fmt.Fprintf(os.Stderr, `usage: %s [options] [script [args]]
+Available options are:
+  -e stat  execute string 'stat'
+  -i       enter interactive mode after executing 'script'
+  -l name  require library 'name'
+  -v       show version information
+  -E       ignore environment variables
+  --       stop handling options
+  -        stop handling options and execute stdin
+`, progName)
+}
+
+// 在标准错误上打印错误信息
+func message(msg string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progName, msg)
+}
+
+// 检查status，如果不是LUA_OK就打印栈顶的错误信息并将其弹出
+// lua-5.3.4/src/lua.c#report()
+func report(ls LuaState, status int) int {
+	if status != LUA_OK {
+		message(errorMessage(ls))
+		ls.Pop(1) /* remove message */
+	}
+	return status
+}
+
+// 错误对象不是字符串时，尝试使用它的__tostring元方法
+// lua-5.3.4/src/lua.c#msghandler()
+func errorMessage(ls LuaState) string {
+	if msg, ok := ls.ToStringX(-1); ok {
+		return msg
+	}
+	if ls.CallMeta(-1, "__tostring") && ls.Type(-1) == LUA_TSTRING {
+		msg := ls.ToString(-1)
+		ls.Pop(1)
+		return msg
+	}
+	return fmt.Sprintf("(error object is a %s value)", ls.TypeName2(-1))
+}
+
+// 消息处理函数，给错误信息加上调用栈回溯
+// lua-5.3.4/src/lua.c#msghandler()
+func msgHandler(ls LuaState) int {
+	msg, ok := ls.ToStringX(1)
+	if !ok { /* is error object not a string? */
+		if ls.CallMeta(1, "__tostring") && /* does it have a metamethod */
+			ls.Type(-1) == LUA_TSTRING { /* that produces a string? */
+			return 1 /* that is the message */
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(1))
+	}
+	ls.Traceback(ls, msg, 1) /* append a standard traceback */
+	return 1                 /* return the traceback */
+}
+
+// lua-5.3.4/src/lua.c#docall()
+func doCall(ls LuaState, nArg, nRes int) int {
+	base := ls.GetTop() - nArg    /* function index */
+	ls.PushGoFunction(msgHandler) /* push message handler */
+	ls.Insert(base)               /* put it under function and args */
+	status := ls.PCall(nArg, nRes, base)
+	ls.Remove(base) /* remove message handler from the stack */
+	return status
+}
+
+func printVersion() {
+	fmt.Println(copyright)
+}
+
+/*
+** Create the 'arg' table, which stores all arguments from the
+** command line ('argv'). It should be aligned so that, at index 0,
+** it has 'argv[script]', which is the script name. The arguments
+** to the script (everything after 'script') go to positive indices;
+** other arguments (before the script name) go to negative indices.
+** If there is no script name, assume interpreter's name as base.
+ */
+// lua-5.3.4/src/lua.c#createargtable()
+func createArgTable(ls LuaState, argv []string, script int) {
+	if script == len(argv) { /* no script name? */
+		script = 0 /* make it interpreter's name */
+	}
+	narg := len(argv) - (script + 1) /* number of positive indices */
+	ls.CreateTable(narg, script+1)
+	for i, arg := range argv {
+		ls.PushString(arg)
+		ls.RawSetI(-2, int64(i-script))
+	}
+	ls.SetGlobal("arg")
+}
+
+func doChunk(ls LuaState, status int) int {
+	if status == LUA_OK {
+		status = doCall(ls, 0, 0)
+	}
+	return report(ls, status)
+}
+
+func doFile(ls LuaState, name string) int {
+	return doChunk(ls, ls.LoadFile(name))
+}
+
+func doString(ls LuaState, s, name string) int {
+	return doChunk(ls, ls.Load([]byte(s), name, "bt"))
+}
+
+/*
+** Calls 'require(name)' and stores the result in a global variable
+** with the given name.
+ */
+// lua-5.3.4/src/lua.c#dolibrary()
+func doLibrary(ls LuaState, name string) int {
+	ls.GetGlobal("require")
+	ls.PushString(name)
+	status := doCall(ls, 1, 1) /*  call 'require(name)' */
+	if status == LUA_OK {
+		ls.SetGlobal(name) /* global[name] = require return */
+	}
+	return report(ls, status)
+}
+
+/*
+** Push on the stack the contents of table 'arg' from 1 to #arg
+ */
+// lua-5.3.4/src/lua.c#pushargs()
+func pushArgs(ls LuaState) int {
+	if ls.GetGlobal("arg") != LUA_TTABLE {
+		ls.Error2("'arg' is not a table")
+	}
+	n := int(ls.Len2(-1))
+	ls.CheckStack2(n+3, "too many arguments to script")
+	for i := 1; i <= n; i++ {
+		ls.RawGetI(-i, int64(i))
+	}
+	ls.Remove(-n - 1) /* remove table from the stack */
+	return n
+}
+
+// argv[script]是脚本名，argv[script-1]是它前面的参数
+func handleScript(ls LuaState, argv []string, script int) int {
+	fname := argv[script]
+	if fname == "-" && argv[script-1] != "--" { /* standard input? */
+		fname = "" /* stdin */
+	}
+	status := ls.LoadFile(fname)
+	if status == LUA_OK {
+		n := pushArgs(ls) /* push arguments to script */
+		status = doCall(ls, n, LUA_MULTRET)
+	}
+	return report(ls, status)
+}
+
+/*
+** Traverses all arguments from 'argv', returning a mask with those
+** needed before running any Lua code (or an error code if it finds
+** any invalid argument). 'first' returns the first not-handled argument
+** (either the script name or a bad argument in case of error).
+ */
+// lua-5.3.4/src/lua.c#collectargs()
+func collectArgs(argv []string) (args, first int) {
+	for i := 1; i < len(argv); i++ {
+		first = i
+		arg := argv[i]
+		if arg == "" || arg[0] != '-' { /* not an option? */
+			return /* stop handling options */
+		}
+		switch arg {
+		case "-": /* '-' */
+			return /* script "name" is '-' */
+		case "--": /* '--' */
+			first = i + 1
+			return
+		case "-E":
+			args |= has_E
+		case "-i":
+			args |= has_i | has_v /* (-i implies -v) */
+		case "-v":
+			args |= has_v
+		case "-e", "-l":
+			if arg == "-e" {
+				args |= has_e
+			}
+			i++
+			if i >= len(argv) || strings.HasPrefix(argv[i], "-") { /* no next argument or it is another option */
+				return has_error, first
+			}
+		default:
+			if len(arg) > 2 && (arg[1] == 'e' || arg[1] == 'l') { /* '-estat' or '-lname' */
+				if arg[1] == 'e' {
+					args |= has_e
+				}
+				continue
+			}
+			return has_error, first /* invalid option */
+		}
+	}
+	first = len(argv) /* no script name */
+	return
+}
+
+/*
+** Processes options 'e' and 'l', which involve running Lua code.
+** Returns 0 if some code raises an error.
+ */
+// lua-5.3.4/src/lua.c#runargs()
+func runArgs(ls LuaState, argv []string, n int) bool {
+	for i := 1; i < n; i++ {
+		arg := argv[i]
+		if len(arg) < 2 || arg[1] != 'e' && arg[1] != 'l' {
+			continue
+		}
+		extra := arg[2:] /* both options need an argument */
+		if extra == "" {
+			i++
+			extra = argv[i]
+		}
+		var status int
+		if arg[1] == 'e' {
+			status = doString(ls, extra, "=(command line)")
+		} else {
+			status = doLibrary(ls, extra)
+		}
+		if status != LUA_OK {
+			return false
+		}
+	}
+	return true
+}
+
+// lua-5.3.4/src/lua.c#handle_luainit()
+func handleLuaInit(ls LuaState) int {
+	name := "=" + initVarVer
+	init, ok := os.LookupEnv(initVarVer)
+	if !ok {
+		name = "=" + initVar
+		init, ok = os.LookupEnv(initVar)
+	}
+	if !ok {
+		return LUA_OK
+	}
+	if len(init) > 0 && init[0] == '@' {
+		return doFile(ls, init[1:])
+	}
+	return doString(ls, init, name)
+}
+
+func stdinIsTTY() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 func main() {
-	if len(os.Args) > 1 {
-		data, err := ioutil.ReadFile(os.Args[1])
-		if err != nil {
-			panic(err)
+	os.Exit(run(os.Args))
+}
+
+// 执行lua命令，返回进程的退出码。主体在保护模式下运行，
+// 这样库函数之外抛出的错误（包括Go运行时错误）也能被报告
+// lua-5.3.4/src/lua.c#main()
+func run(argv []string) int {
+	ls := state.New()
+	ls.PushGoFunction(func(ls LuaState) int { /* to call 'pmain' in protected mode */
+		ls.PushBoolean(pmain(ls, argv)) /* report result */
+		return 1
+	})
+	status := ls.PCall(0, 1, 0) /* do the call */
+	result := ls.ToBoolean(-1)  /* get result */
+	report(ls, status)
+	if result && status == LUA_OK {
+		return 0
+	}
+	return 1
+}
+
+// 处理命令行参数并执行Lua代码，全部成功时返回true
+// lua-5.3.4/src/lua.c#pmain()
+func pmain(ls LuaState, argv []string) bool {
+	args, script := collectArgs(argv)
+	if args == has_error { /* bad arg? */
+		printUsage(argv[script]) /* 'script' has index of bad arg. */
+		return false
+	}
+	if args&has_v != 0 { /* option '-v'? */
+		printVersion()
+	}
+	if args&has_E != 0 { /* option '-E'? */
+		ls.PushBoolean(true) /* signal for libraries to ignore env. vars. */
+		ls.SetField(LUA_REGISTRYINDEX, "LUA_NOENV")
+	}
+	ls.OpenLibs()                    /* open standard libraries */
+	createArgTable(ls, argv, script) /* create table 'arg' */
+	if args&has_E == 0 {             /* no option '-E'? */
+		if handleLuaInit(ls) != LUA_OK { /* run LUA_INIT */
+			return false /* error running LUA_INIT */
 		}
+	}
+	if !runArgs(ls, argv, script) { /* execute arguments -e and -l */
+		return false /* something failed */
+	}
+	if script < len(argv) && /* execute main script (if there is one) */
+		handleScript(ls, argv, script) != LUA_OK {
+		return false
+	}
+	if args&has_i != 0 { /* -i option? */
+		doREPL(ls) /* do read-eval-print loop */
+	} else if script == len(argv) && args&(has_e|has_v) == 0 { /* no arguments? */
+		if stdinIsTTY() { /* running in interactive mode? */
+			printVersion()
+			doREPL(ls) /* do read-eval-print loop */
+		} else if doFile(ls, "") != LUA_OK { /* executes stdin as a file */
+			return false
+		}
+	}
+	return true
+}
 
-		ls := state.New()
-		ls.OpenLibs()
-		// ls.LoadFile(os.Args[1])
-		ls.Load(data, os.Args[1], "b")
-		ls.Call(0, LUA_MULTRET)
+/* REPL */
+
+// 返回交互模式下的提示符，可以通过全局变量_PROMPT和_PROMPT2修改
+// lua-5.3.4/src/lua.c#get_prompt()
+func getPrompt(ls LuaState, firstLine bool) string {
+	name, def := "_PROMPT2", prompt2
+	if firstLine {
+		name, def = "_PROMPT", prompt
+	}
+	ls.GetGlobal(name)
+	p, ok := ls.ToStringX(-1)
+	ls.Pop(1)
+	if !ok {
+		return def
+	}
+	return p
+}
+
+// 读取一行输入，去掉结尾的换行符。输入结束时返回false
+// lua-5.3.4/src/lua.c#pushline()
+func readLine(ls LuaState, firstLine bool) (string, bool) {
+	fmt.Print(getPrompt(ls, firstLine))
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false /* no input */
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if firstLine && strings.HasPrefix(line, "=") { /* for compatibility with 5.2, ... */
+		line = "return " + line[1:] /* change '=' to 'return' */
+	}
+	return line, true
+}
+
+/*
+** Check whether 'status' signals a syntax error and the error
+** message at the top of the stack ends with the above mark for
+** incomplete statements.
+ */
+// lua-5.3.4/src/lua.c#incomplete()
+func incomplete(ls LuaState, status int) bool {
+	if status == LUA_ERRSYNTAX {
+		if msg := ls.ToString(-1); strings.HasSuffix(msg, eofMark) {
+			ls.Pop(1)
+			return true
+		}
+	}
+	return false /* else... */
+}
+
+/*
+** Try to compile line on the stack as 'return <line>;'; on return, stack
+** has either compiled chunk or original line (if compilation failed).
+ */
+// lua-5.3.4/src/lua.c#addreturn()
+func addReturn(ls LuaState, line string) int {
+	status := ls.Load([]byte("return "+line+";"), "=stdin", "bt")
+	if status != LUA_OK {
+		ls.Pop(1) /* remove result from 'luaL_loadbuffer' */
+	}
+	return status
+}
+
+/*
+** Read multiple lines until a complete Lua statement
+ */
+// lua-5.3.4/src/lua.c#multiline()
+func multiLine(ls LuaState, line string) int {
+	for { /* repeat until gets a complete statement */
+		status := ls.Load([]byte(line), "=stdin", "bt") /* try it */
+		if !incomplete(ls, status) {
+			return status /* cannot or should not try to add continuation line */
+		}
+		next, ok := readLine(ls, false)
+		if !ok { /* no more input? */
+			ls.Load([]byte(line), "=stdin", "bt") /* report the original error */
+			return LUA_ERRSYNTAX
+		}
+		line += "\n" + next /* join them */
+	}
+}
+
+/*
+** Read a line and try to load (compile) it first as an expression (by
+** adding "return " in front of it) and second as a statement. Return
+** the final status of load/call with the resulting function (if any)
+** in the top of the stack.
+ */
+// lua-5.3.4/src/lua.c#loadline()
+func loadLine(ls LuaState) (int, bool) {
+	line, ok := readLine(ls, true)
+	if !ok {
+		return 0, false /* no input */
+	}
+	status := addReturn(ls, line)
+	if status != LUA_OK { /* 'return ...' did not work? */
+		status = multiLine(ls, line) /* try as command, maybe with continuation lines */
+	}
+	return status, true
+}
+
+/*
+** Prints any values on the stack, converting them with ToString2
+ */
+// lua-5.3.4/src/lua.c#l_print()
+func printResults(ls LuaState) {
+	n := ls.GetTop()
+	if n > 0 { /* any result to be printed? */
+		ls.CheckStack2(LUA_MINSTACK, "too many results to print")
+		ls.PushGoFunction(printValues)
+		ls.Insert(1)
+		if ls.PCall(n, 0, 0) != LUA_OK {
+			message(fmt.Sprintf("error calling 'print' (%s)", errorMessage(ls)))
+		}
+	}
+}
+
+func printValues(ls LuaState) int {
+	n := ls.GetTop()
+	results := make([]string, n)
+	for i := 1; i <= n; i++ {
+		results[i-1] = ls.ToString2(i)
+		ls.Pop(1) /* pop result from 'ToString2' */
+	}
+	fmt.Println(strings.Join(results, "\t"))
+	return 0
+}
+
+/*
+** Do the REPL: repeatedly read (load) a line, evaluate (call) it, and
+** print any results.
+ */
+// lua-5.3.4/src/lua.c#doREPL()
+func doREPL(ls LuaState) {
+	for {
+		status, ok := loadLine(ls)
+		if !ok {
+			break
+		}
+		if status == LUA_OK {
+			status = doCall(ls, 0, LUA_MULTRET)
+		}
+		if status == LUA_OK {
+			printResults(ls)
+		} else {
+			report(ls, status)
+		}
+		ls.SetTop(0) /* clear stack */
 	}
+	fmt.Println()
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "luago/api"
+	"luago/state"
+)
+
+func TestCollectArgs(t *testing.T) {
+	tests := []struct {
+		argv  string
+		args  int
+		first int
+	}{
+		{"lua", 0, 1},
+		{"lua script.lua", 0, 1},
+		{"lua script.lua -v", 0, 1},
+		{"lua -v", has_v, 2},
+		{"lua -i", has_i | has_v, 2},
+		{"lua -E -v x.lua a b", has_E | has_v, 3},
+		{"lua -e print(1)", has_e, 3},
+		{"lua -eprint(1) x.lua", has_e, 2},
+		{"lua -l mod x.lua", 0, 3},
+		{"lua -lmod", 0, 2},
+		{"lua - a", 0, 1},
+		{"lua -v - a", has_v, 2},
+		{"lua -- -v", 0, 2},
+		{"lua -v --", has_v, 3},
+		{"lua -e", has_error, 1},
+		{"lua -l", has_error, 1},
+		{"lua -e -v", has_error, 1},
+		{"lua -v -l -i", has_error, 2},
+		{"lua -x", has_error, 1},
+		{"lua -vi", has_error, 1},
+		{"lua -v -ix", has_error, 2},
+	}
+	for _, tt := range tests {
+		args, first := collectArgs(strings.Fields(tt.argv))
+		if args != tt.args || first != tt.first {
+			t.Errorf("collectArgs(%q) = %d, %d; want %d, %d", tt.argv, args, first, tt.args, tt.first)
+		}
+	}
+	// 空字符串不是选项，是脚本名
+	if args, first := collectArgs([]string{"lua", "", "-v"}); args != 0 || first != 1 {
+		t.Errorf("collectArgs with empty script = %d, %d", args, first)
+	}
+}
+
+func TestCreateArgTable(t *testing.T) {
+	tests := []struct {
+		argv   string
+		script int
+		want   string
+	}{
+		{"lua -e x=1 s.lua a b", 3, "-3=lua -2=-e -1=x=1 0=s.lua 1=a 2=b #=2"},
+		{"lua -v", 2, "0=lua 1=-v #=1"}, // 没有脚本时以解释器的名字为arg[0]
+		{"lua", 1, "0=lua #=0"},
+	}
+	for _, tt := range tests {
+		ls := state.New()
+		ls.OpenLibs()
+		createArgTable(ls, strings.Fields(tt.argv), tt.script)
+		ls.DoString(`
+			local t = {}
+			for i = -5, #arg do
+				if arg[i] then t[#t+1] = i .. "=" .. arg[i] end
+			end
+			t[#t+1] = "#=" .. #arg
+			result = table.concat(t, " ")
+		`)
+		ls.GetGlobal("result")
+		if got := ls.ToString(-1); got != tt.want {
+			t.Errorf("createArgTable(%q) = %q; want %q", tt.argv, got, tt.want)
+		}
+	}
+}
+
+func TestHandleScript(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "s.lua")
+	os.WriteFile(name, []byte(`result = table.concat({...}, ",") .. ";" .. arg[0]`), 0644)
+	argv := []string{"lua", "-v", name, "a", "b"}
+	ls := state.New()
+	ls.OpenLibs()
+	createArgTable(ls, argv, 2)
+	if status := handleScript(ls, argv, 2); status != LUA_OK {
+		t.Fatalf("status = %d", status)
+	}
+	ls.GetGlobal("result")
+	if got, want := ls.ToString(-1), "a,b;"+name; got != want {
+		t.Errorf("result = %q; want %q", got, want)
+	}
+	// "--"之后的"-"是文件名，不是标准输入
+	argv = []string{"lua", "--", "-"}
+	createArgTable(ls, argv, 2)
+	if status := handleScript(ls, argv, 2); status != LUA_ERRFILE {
+		t.Errorf("status = %d", status)
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		argv string
+		code int
+	}{
+		{"lua -e x=1", 0},
+		{"lua -e error('x')", 1},
+		{"lua -e x=", 1},
+		{"lua -x", 1},
+		{"lua -lnosuchmodule", 1},
+		{"lua nosuchfile.lua", 1},
+	}
+	for _, tt := range tests {
+		if code := run(strings.Fields(tt.argv)); code != tt.code {
+			t.Errorf("run(%q) = %d; want %d", tt.argv, code, tt.code)
+		}
+	}
+	// 空的LUA_INIT什么也不做
+	t.Setenv(initVarVer, "")
+	if code := run([]string{"lua", "-e", "x=1"}); code != 0 {
+		t.Errorf("run with empty %s = %d", initVarVer, code)
+	}
+	t.Setenv(initVarVer, "error('init')")
+	if code := run([]string{"lua", "-E", "-e", "x=1"}); code != 0 {
+		t.Errorf("-E did not ignore %s", initVarVer)
+	}
+	if code := run([]string{"lua", "-e", "x=1"}); code != 1 {
+		t.Errorf("%s was not run", initVarVer)
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	ls := state.New()
+	tests := []struct {
+		chunk string
+		want  bool
+	}{
+		{"x = 1", false},
+		{"for i = 1, 2 do", true},
+		{"f(", true},
+		{"x = [[abc", true},
+		{"x = 'abc", true},
+		{"x = 'abc\n", false}, // 短字符串不能跨行
+		{"x = = 1", false},
+		{"end", false},
+	}
+	for _, tt := range tests {
+		status := ls.Load([]byte(tt.chunk), "=stdin", "bt")
+		if got := incomplete(ls, status); got != tt.want {
+			t.Errorf("incomplete(%q) = %v; want %v", tt.chunk, got, tt.want)
+		}
+		ls.SetTop(0)
+	}
+}
+
+func TestAddReturn(t *testing.T) {
+	ls := state.New()
+	if status := addReturn(ls, "1 + 2"); status != LUA_OK || ls.GetTop() != 1 {
+		t.Errorf("addReturn(expr) = %d, top = %d", status, ls.GetTop())
+	}
+	ls.SetTop(0)
+	if status := addReturn(ls, "x = 1"); status == LUA_OK || ls.GetTop() != 0 {
+		t.Errorf("addReturn(stat) = %d, top = %d", status, ls.GetTop())
+	}
+}
+
+func TestMultiLine(t *testing.T) {
+	defer func(r *bufio.Reader) { stdin = r }(stdin)
+	ls := state.New()
+	ls.OpenLibs()
+
+	stdin = bufio.NewReader(strings.NewReader("  x = i\nend\nrest\n"))
+	if status := multiLine(ls, "for i = 1, 3 do"); status != LUA_OK {
+		t.Fatalf("status = %d, %s", status, ls.ToString(-1))
+	}
+	ls.Call(0, 0)
+	ls.GetGlobal("x")
+	if x := ls.ToInteger(-1); x != 3 {
+		t.Errorf("x = %d", x)
+	}
+	if line, _ := stdin.ReadString('\n'); line != "rest\n" {
+		t.Errorf("next line = %q", line)
+	}
+	ls.SetTop(0)
+
+	// 输入结束时报告原来那一行的错误
+	stdin = bufio.NewReader(strings.NewReader("  x = 1\n"))
+	if status := multiLine(ls, "if true then"); status != LUA_ERRSYNTAX {
+		t.Fatalf("status = %d", status)
+	}
+	if msg := ls.ToString(-1); !strings.HasSuffix(msg, "near "+eofMark) || strings.Contains(msg, "x = 1") {
+		t.Errorf("msg = %q", msg)
+	}
+}
@@ -0,0 +1,371 @@
+package pattern
+
+import "fmt"
+
+// lua-5.3.4/src/lstrlib.c#MatchState
+type matchState struct {
+	src        string
+	pat        string
+	level      int // 捕获的数量（包括尚未闭合的）
+	matchdepth int // 剩余可用的递归深度
+	capture    [MAXCAPTURES]struct {
+		init int
+		len  int
+	}
+}
+
+func newMatchState(src, pat string) *matchState {
+	return &matchState{src: src, pat: pat, matchdepth: MAXCCALLS}
+}
+
+func (ms *matchState) reprep() {
+	ms.level = 0
+	ms.matchdepth = MAXCCALLS
+}
+
+func (ms *matchState) error(f string, a ...interface{}) {
+	panic(&Error{fmt.Sprintf(f, a...)})
+}
+
+func (ms *matchState) result(s, e int) *Match {
+	m := &Match{Start: s, End: e}
+	if ms.level > 0 {
+		m.Captures = make([]Capture, ms.level)
+		for i := 0; i < ms.level; i++ {
+			c := ms.capture[i]
+			switch c.len {
+			case capUnfinished:
+				ms.error("unfinished capture")
+			case capPosition:
+				m.Captures[i] = Capture{Start: c.init, End: c.init, Position: true}
+			default:
+				m.Captures[i] = Capture{Start: c.init, End: c.init + c.len}
+			}
+		}
+	}
+	return m
+}
+
+func (ms *matchState) checkCapture(l byte) int {
+	idx := int(l) - '1'
+	if idx < 0 || idx >= ms.level || ms.capture[idx].len == capUnfinished {
+		ms.error("invalid capture index %%%d", idx+1)
+	}
+	return idx
+}
+
+func (ms *matchState) captureToClose() int {
+	level := ms.level - 1
+	for ; level >= 0; level-- {
+		if ms.capture[level].len == capUnfinished {
+			return level
+		}
+	}
+	ms.error("invalid pattern capture")
+	return 0
+}
+
+// 返回模式中p处单项之后的位置
+func (ms *matchState) classEnd(p int) int {
+	pat := ms.pat
+	c := pat[p]
+	p++
+	if c == L_ESC {
+		if p >= len(pat) {
+			ms.error("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+	if c == '[' {
+		if p < len(pat) && pat[p] == '^' {
+			p++
+		}
+		for { // look for a ']'
+			if p >= len(pat) {
+				ms.error("malformed pattern (missing ']')")
+			}
+			c := pat[p]
+			p++
+			if c == L_ESC && p < len(pat) {
+				p++ // skip escapes (e.g. '%]')
+			}
+			if p < len(pat) && pat[p] == ']' {
+				return p + 1
+			}
+			if p >= len(pat) {
+				ms.error("malformed pattern (missing ']')")
+			}
+		}
+	}
+	return p
+}
+
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch toLower(cl) {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = isDigit(c)
+	case 'g':
+		res = c > 32 && c < 127
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = c == ' ' || c >= '\t' && c <= '\r'
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || isDigit(c)
+	case 'x':
+		res = isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	case 'z':
+		res = c == 0 /* deprecated option */
+	default:
+		return cl == c
+	}
+	if cl >= 'A' && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+// p指向'['，ec指向结尾的']'
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	pat := ms.pat
+	sig := true
+	if pat[p+1] == '^' {
+		sig = false
+		p++ // skip the '^'
+	}
+	for p++; p < ec; p++ {
+		if pat[p] == L_ESC {
+			p++
+			if matchClass(c, pat[p]) {
+				return sig
+			}
+		} else if p+2 < ec && pat[p+1] == '-' {
+			if pat[p] <= c && c <= pat[p+2] {
+				return sig
+			}
+			p += 2
+		} else if pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true // matches any char
+	case L_ESC:
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.error("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		if ms.src[s] == e {
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+	return -1 // string ends out of balance
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0 // counts maximum expand for item
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- { // try with maximum repetitions
+		if res := ms.doMatch(s+i, ep+1); res >= 0 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.doMatch(s, ep+1); res >= 0 {
+			return res
+		} else if ms.singleMatch(s, p, ep) {
+			s++ // try with one more repetition
+		} else {
+			return -1
+		}
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= MAXCAPTURES {
+		ms.error("too many captures")
+	}
+	ms.capture[ms.level].init = s
+	ms.capture[ms.level].len = what
+	ms.level++
+	res := ms.doMatch(s, p)
+	if res < 0 { // match failed?
+		ms.level-- // undo capture
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init // close capture
+	res := ms.doMatch(s, p)
+	if res < 0 { // match failed?
+		ms.capture[l].len = capUnfinished // undo capture
+	}
+	return res
+}
+
+func (ms *matchState) matchCapture(s int, l byte) int {
+	idx := ms.checkCapture(l)
+	c := ms.capture[idx]
+	if len(ms.src)-s >= c.len &&
+		ms.src[c.init:c.init+c.len] == ms.src[s:s+c.len] {
+		return s + c.len
+	}
+	return -1
+}
+
+// doMatch 从目标串的s处开始匹配模式中p处之后的部分，返回匹配结束的位置，失败时返回-1
+func (ms *matchState) doMatch(s, p int) int {
+	if ms.matchdepth--; ms.matchdepth == 0 {
+		ms.error("pattern too complex")
+	}
+	defer func() { ms.matchdepth++ }()
+
+	pat := ms.pat
+	for p < len(pat) {
+		switch pat[p] {
+		case '(': // start capture
+			if p+1 < len(pat) && pat[p+1] == ')' { // position capture?
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')': // end capture
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(pat) { // is the '$' the last char in pattern?
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case L_ESC: // escaped sequences not in the format class[*+?-]?
+			if p+1 < len(pat) {
+				switch c := pat[p+1]; {
+				case c == 'b': // balanced string?
+					if s = ms.matchBalance(s, p+2); s < 0 {
+						return -1
+					}
+					p += 4
+					continue
+				case c == 'f': // frontier?
+					p += 2
+					if p >= len(pat) || pat[p] != '[' {
+						ms.error("missing '[' after '%%f' in pattern")
+					}
+					ep := ms.classEnd(p) // points to what is next
+					var prev, cur byte
+					if s > 0 {
+						prev = ms.src[s-1]
+					}
+					if s < len(ms.src) {
+						cur = ms.src[s]
+					}
+					if !ms.matchBracketClass(prev, p, ep-1) &&
+						ms.matchBracketClass(cur, p, ep-1) {
+						p = ep
+						continue
+					}
+					return -1 // match failed
+				case isDigit(c): // capture results (%0-%9)?
+					if s = ms.matchCapture(s, c); s < 0 {
+						return -1
+					}
+					p += 2
+					continue
+				}
+			}
+		}
+
+		// pattern class plus optional suffix
+		ep := ms.classEnd(p)           // points to optional suffix
+		if !ms.singleMatch(s, p, ep) { // does not match at least once?
+			if ep < len(pat) && (pat[ep] == '*' || pat[ep] == '?' || pat[ep] == '-') { // accept empty?
+				p = ep + 1
+				continue
+			}
+			return -1 // '+' or no suffix
+		}
+		// matched once
+		if ep < len(pat) {
+			switch pat[ep] {
+			case '?': // optional
+				if res := ms.doMatch(s+1, ep+1); res >= 0 {
+					return res
+				}
+				p = ep + 1
+				continue
+			case '+': // 1 or more repetitions
+				return ms.maxExpand(s+1, p, ep)
+			case '*': // 0 or more repetitions
+				return ms.maxExpand(s, p, ep)
+			case '-': // 0 or more repetitions (minimum)
+				return ms.minExpand(s, p, ep)
+			}
+		}
+		s++
+		p = ep
+	}
+	return s // end of pattern
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isPunct(c byte) bool {
+	return c > 32 && c < 127 && !isAlpha(c) && !isDigit(c)
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,194 @@
+package pattern
+
+import (
+	"strings"
+	"sync"
+)
+
+// Lua 5.3 模式匹配（http://www.lua.org/manual/5.3/manual.html#6.4.1）。
+// 模式先经过Compile()做语法检查并记录锚点等信息，编译后的Pattern是只读的，可以被缓存并在多次匹配之间复用。
+// 匹配算法移植自 lua-5.3.4/src/lstrlib.c，采用回溯实现。
+
+const (
+	L_ESC         = '%'
+	SPECIALS      = "^$*+?.([%-"
+	MAXCAPTURES   = 32  // LUA_MAXCAPTURES
+	MAXCCALLS     = 200 // 匹配时最大递归深度
+	CACHE_SIZE    = 64  // 缓存的已编译模式数量
+	capUnfinished = -1
+	capPosition   = -2
+)
+
+type Pattern struct {
+	src       string // 原始模式串
+	pat       string // 去掉开头'^'之后的模式串
+	anchor    bool   // 是否以'^'开头
+	noSpecial bool   // 不含任何特殊字符，可以按纯文本查找
+}
+
+// 一次成功匹配的结果，Start和End是整个匹配在目标串中的字节区间[Start, End)
+type Match struct {
+	Start    int
+	End      int
+	Captures []Capture
+}
+
+// 位置捕获（"()"）只记录位置，Start即为捕获位置（从0开始）
+type Capture struct {
+	Start    int
+	End      int
+	Position bool
+}
+
+type Error struct {
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+var (
+	cacheLock sync.Mutex
+	cache     = make(map[string]*Pattern, CACHE_SIZE)
+)
+
+// Compile 检查模式的语法，返回可复用的Pattern
+func Compile(p string) (*Pattern, error) {
+	pat := &Pattern{src: p, pat: p}
+	if len(p) > 0 && p[0] == '^' {
+		pat.anchor = true
+		pat.pat = p[1:]
+	}
+	pat.noSpecial = !strings.ContainsAny(p, SPECIALS)
+	if err := pat.check(); err != nil {
+		return nil, err
+	}
+	return pat, nil
+}
+
+// Cached 与Compile相同，但会复用最近编译过的模式
+func Cached(p string) (*Pattern, error) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	if pat, ok := cache[p]; ok {
+		return pat, nil
+	}
+	pat, err := Compile(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(cache) >= CACHE_SIZE {
+		for k := range cache { // 随机淘汰一个
+			delete(cache, k)
+			break
+		}
+	}
+	cache[p] = pat
+	return pat, nil
+}
+
+func (p *Pattern) String() string {
+	return p.src
+}
+
+func (p *Pattern) Anchored() bool {
+	return p.anchor
+}
+
+// NoSpecials 报告模式是否不含特殊字符（string.find可以直接做纯文本查找）
+func (p *Pattern) NoSpecials() bool {
+	return p.noSpecial
+}
+
+// check 遍历模式里的每一个单项，找出格式错误
+func (p *Pattern) check() (err error) {
+	defer catch(&err)
+
+	ms := &matchState{pat: p.pat}
+	for i := 0; i < len(ms.pat); {
+		switch ms.pat[i] {
+		case '(', ')':
+			i++
+		case L_ESC:
+			if i+1 >= len(ms.pat) {
+				ms.error("malformed pattern (ends with '%%')")
+			}
+			switch c := ms.pat[i+1]; {
+			case c == 'b':
+				if i+3 >= len(ms.pat) {
+					ms.error("malformed pattern (missing arguments to '%%b')")
+				}
+				i += 4
+			case c == 'f':
+				i += 2
+				if i >= len(ms.pat) || ms.pat[i] != '[' {
+					ms.error("missing '[' after '%%f' in pattern")
+				}
+				i = ms.classEnd(i)
+			case isDigit(c):
+				i += 2
+			default:
+				i = ms.classEnd(i)
+			}
+		default:
+			i = ms.classEnd(i)
+		}
+	}
+	return nil
+}
+
+// Find 从init（从0开始）处向后查找第一个匹配，没有找到时返回nil
+func (p *Pattern) Find(s string, init int) (m *Match, err error) {
+	defer catch(&err)
+
+	ms := newMatchState(s, p.pat)
+	for sIdx := init; ; sIdx++ {
+		ms.reprep()
+		if e := ms.doMatch(sIdx, 0); e >= 0 {
+			return ms.result(sIdx, e), nil
+		}
+		if p.anchor || sIdx >= len(s) {
+			return nil, nil
+		}
+	}
+}
+
+// MatchAt 只尝试在pos处匹配，供gmatch和gsub逐个位置推进使用
+func (p *Pattern) MatchAt(s string, pos int) (m *Match, err error) {
+	defer catch(&err)
+
+	ms := newMatchState(s, p.pat)
+	if e := ms.doMatch(pos, 0); e >= 0 {
+		return ms.result(pos, e), nil
+	}
+	return nil, nil
+}
+
+func catch(err *error) {
+	if r := recover(); r != nil {
+		if e, ok := r.(*Error); ok {
+			*err = e
+		} else {
+			panic(r)
+		}
+	}
+}
+
+func (m *Match) NumCaptures() int {
+	return len(m.Captures)
+}
+
+// Capture 按照string.find/string.match的约定取得第i个捕获（从0开始）的值：
+// 没有任何捕获时，第0个捕获就是整个匹配；位置捕获返回从1开始的位置。调用者负责检查i的范围
+func (m *Match) Capture(s string, i int) (str string, pos int, isPos bool) {
+	if i >= len(m.Captures) {
+		return s[m.Start:m.End], 0, false
+	}
+	c := m.Captures[i]
+	if c.Position {
+		return "", c.Start + 1, true
+	}
+	return s[c.Start:c.End], 0, false
+}
@@ -0,0 +1,154 @@
+package pattern
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// 用例取自参考手册 §6.4.1 以及 lua-5.3.4-tests/pm.lua
+// want 是 string.match 的返回值，用空格连接；位置捕获写成数字；nil 表示没有匹配
+var matchTests = []struct {
+	s, p string
+	init int
+	want string
+}{
+	{"hello Lua user", "Lua", 0, "Lua"},
+	{"hello Lua user", "banana", 0, "nil"},
+	{"hello Lua user", "l+", 0, "ll"},
+	{"aaab", "a-b", 0, "aaab"},
+	{"aaa", "^.-$", 0, "aaa"},
+	{"aaa", "a-", 0, ""},
+	{"aaab", "a*", 0, "aaa"},
+	{"aaab", "a?", 0, "a"},
+	{"aloALO", "%l*", 0, "alo"},
+	{"aLo_ALO", "%a*", 0, "aLo"},
+	{"  \n\r*&\n\r   xuxu  \n\n", "%g%g%g+", 0, "xuxu"},
+	{"(álo)", "%(á", 0, "(á"},
+	{"alo xyzK", "(%w+)K", 0, "xyz"},
+	{"254 K", "(%d*)K", 0, ""},
+	{"alo ", "(%w*)$", 0, ""},
+	{"alo ", "(%w+)$", 0, "nil"},
+	{"testing (1,2) thing", "%b()", 0, "(1,2)"},
+	{"if (a(b)c) then", "%b()", 0, "(a(b)c)"},
+	{"THE (quick) fox", "%f[%a]%a+", 0, "THE"},
+	{"THE (quick) fox", "%f[%l]%a+", 0, "quick"},
+	{"hello world", "%f[%w]%w+$", 0, "world"},
+	{"hello world from Lua", "(%w+)%s*(%w+)", 0, "hello world"},
+	{"key = value", "(%w+)%s*=%s*(%w+)", 0, "key value"},
+	{"flaaap", "()aa()", 0, "3 5"},
+	{"x = \"a \\\" b\" y", "([\"'])(.-)%1", 0, "\" a \\"},
+	{"[[]] [==[]]==]", "%[(=*)%[(.-)%]%1%]", 0, " "},
+	{"==========", "^([=]*)=%1$", 0, "nil"},
+	{"=========", "^([=]*)=%1$", 0, "===="},
+	{"0123456789", "[^%d]", 0, "nil"},
+	{"0123456789", "[%d]+", 0, "0123456789"},
+	{"abc]def", "[]]", 0, "]"},
+	{"a-b", "[a%-]+", 0, "a-"},
+	{"alo alo", "^alo", 1, "nil"},
+	{"alo alo", "^alo", 4, "alo"},
+	{"alo alo", "alo", 4, "alo"},
+	{"", "^$", 0, ""},
+	{"a", "", 1, ""},
+	{"a\x00b", "%z", 0, "\x00"},
+	{"\x00\x00ab", "%Z+", 0, "ab"},
+	{"a\x00b", "[%z]", 0, "\x00"},
+	{"a\x00b", "[\x00]", 0, "\x00"},
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		p, err := Compile(tt.p)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.p, err)
+			continue
+		}
+		m, err := p.Find(tt.s, tt.init)
+		if err != nil {
+			t.Errorf("Find(%q, %q): %v", tt.s, tt.p, err)
+			continue
+		}
+		got := "nil"
+		if m != nil {
+			n := m.NumCaptures()
+			if n == 0 {
+				n = 1
+			}
+			caps := make([]string, n)
+			for i := range caps {
+				str, pos, isPos := m.Capture(tt.s, i)
+				if isPos {
+					caps[i] = fmt.Sprint(pos)
+				} else {
+					caps[i] = str
+				}
+			}
+			got = strings.Join(caps, " ")
+		}
+		if got != tt.want {
+			t.Errorf("match(%q, %q) = %q, want %q", tt.s, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestFindPosition(t *testing.T) {
+	p, _ := Compile("%d+")
+	m, _ := p.Find("abc 123 def", 0)
+	if m == nil || m.Start != 4 || m.End != 7 {
+		t.Fatalf("got %+v", m)
+	}
+}
+
+var errorTests = []struct {
+	p, msg string
+}{
+	{"%", "malformed pattern (ends with '%')"},
+	{"[a", "malformed pattern (missing ']')"},
+	{"[]", "malformed pattern (missing ']')"},
+	{"[^]", "malformed pattern (missing ']')"},
+	{"%b", "malformed pattern (missing arguments to '%b')"},
+	{"%ba", "malformed pattern (missing arguments to '%b')"},
+	{"%f", "missing '[' after '%f' in pattern"},
+	{"%fa", "missing '[' after '%f' in pattern"},
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tt := range errorTests {
+		_, err := Compile(tt.p)
+		if err == nil || err.Error() != tt.msg {
+			t.Errorf("Compile(%q) = %v, want %q", tt.p, err, tt.msg)
+		}
+	}
+}
+
+func TestMatchErrors(t *testing.T) {
+	cases := []struct{ s, p, msg string }{
+		{"alo", "(.", "unfinished capture"},
+		{"alo", ".)", "invalid pattern capture"},
+		{"alo", "(.)%2", "invalid capture index %2"},
+		{"alo", "%1", "invalid capture index %1"},
+		{strings.Repeat("a", 300), strings.Repeat("a?", 300), "pattern too complex"},
+	}
+	for _, tt := range cases {
+		p, err := Compile(tt.p)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.p, err)
+			continue
+		}
+		_, err = p.Find(tt.s, 0)
+		if err == nil || err.Error() != tt.msg {
+			t.Errorf("Find(%q, %q) = %v, want %q", tt.s, tt.p, err, tt.msg)
+		}
+	}
+}
+
+func TestCached(t *testing.T) {
+	p1, _ := Cached("%a+")
+	p2, _ := Cached("%a+")
+	if p1 != p2 {
+		t.Error("pattern not reused")
+	}
+	if _, err := Cached("%"); err == nil {
+		t.Error("expected error")
+	}
+}
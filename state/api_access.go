@@ -61,6 +61,15 @@ func (s *luaState) IsTable(idx int) bool {
 	return s.Type(idx) == LUA_TTABLE
 }
 
+func (s *luaState) IsUserData(idx int) bool {
+	t := s.Type(idx)
+	return t == LUA_TUSERDATA || t == LUA_TLIGHTUSERDATA
+}
+
+func (s *luaState) IsLightUserData(idx int) bool {
+	return s.Type(idx) == LUA_TLIGHTUSERDATA
+}
+
 func (s *luaState) IsThread(idx int) bool {
 	return s.Type(idx) == LUA_TTHREAD
 }
@@ -147,15 +156,38 @@ func (s *luaState) RawLen(idx int) uint {
 		return uint(len(x))
 	case *luaTable:
 		return uint(x.len())
+	case *userdata:
+		if b, ok := x.data.([]byte); ok {
+			return uint(len(b))
+		}
+		return 0
 	default:
 		return 0
 	}
 }
 
+// 完全用户数据返回NewUserData()分配的[]byte或者PushUserData()传入的Go值，
+// 轻量用户数据返回它包装的Go值，其他类型返回nil
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_touserdata
+func (s *luaState) ToUserData(idx int) interface{} {
+	switch x := s.stack.get(idx).(type) {
+	case *userdata:
+		return x.data
+	case lightUserdata:
+		return x.p
+	default:
+		return nil
+	}
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_topointer
 func (self *luaState) ToPointer(idx int) interface{} {
 	// todo
+	if lud, ok := self.stack.get(idx).(lightUserdata); ok {
+		return lud.p
+	}
 	return self.stack.get(idx)
 }
 
@@ -48,7 +48,7 @@ var operators = []operator{
 	{"__bxor", bxor, nil},    // LUA_OPBXOR
 	{"__shl", shl, nil},      // LUA_OPSHL
 	{"__shr", shr, nil},      // LUA_OPSHR
-	{"__umn", iunm, funm},    // LUA_OPUNM
+	{"__unm", iunm, funm},    // LUA_OPUNM
 	{"__bnot", bnot, nil},    // LUA_OPBNOT
 }
 
@@ -61,6 +61,10 @@ func (s *luaState) Arith(op ArithOp) {
 		a = b
 	}
 
+	if op == LUA_OPMOD || op == LUA_OPIDIV {
+		s.checkDivByZero(a, b, op)
+	}
+
 	operator := operators[op]
 	if result := _arith(a, b, operator); result != nil {
 		s.stack.push(result)
@@ -73,7 +77,28 @@ func (s *luaState) Arith(op ArithOp) {
 		return
 	}
 
-	panic("arithmetic error!")
+	if operator.floatFunc == nil { /* bitwise operation? */
+		_, ok1 := convertToFloat(a)
+		_, ok2 := convertToFloat(b)
+		if ok1 && ok2 {
+			s.toIntError(a, b)
+		}
+		s.opIntError(a, b, "perform bitwise operation on")
+	}
+	s.opIntError(a, b, "perform arithmetic on")
+}
+
+// 整数除以0（或者对0取模）没有意义
+// lua-5.3.4/src/lvm.c#luaV_div()
+func (s *luaState) checkDivByZero(a, b luaValue, op ArithOp) {
+	if _, ok := a.(int64); ok {
+		if y, ok := b.(int64); ok && y == 0 {
+			if op == LUA_OPMOD {
+				s.runError("attempt to perform 'n%%0'")
+			}
+			s.runError("attempt to perform 'n//0'")
+		}
+	}
 }
 
 func _arith(a, b luaValue, op operator) luaValue {
@@ -1,33 +1,144 @@
 package state
 
 import (
+	"fmt"
 	. "luago/api"
 	"luago/binchunk"
 	"luago/compiler"
 	"luago/vm"
+	"runtime"
+	"strings"
 )
 
+// ListChunks 为true时，Load每编译一个文本chunk都会把指令列表打印到标准输出，仅供调试使用。
+// 正式查看指令列表请使用 cmd/luac -l
+var ListChunks = false
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_load
 func (s *luaState) Load(chunk []byte, chunkName, mode string) int {
 	var proto *binchunk.Prototype
 	if binchunk.IsBinaryChunk(chunk) {
-		proto = binchunk.Undump(chunk)
+		if !s.checkMode(mode, "binary") {
+			return LUA_ERRSYNTAX
+		}
+		var err error
+		if proto, err = undump(chunk, chunkName); err != nil {
+			s.stack.push(err.Error())
+			return LUA_ERRSYNTAX
+		}
 	} else {
-		proto = compiler.Compile(string(chunk), chunkName)
-		binchunk.List(proto)
+		if !s.checkMode(mode, "text") {
+			return LUA_ERRSYNTAX
+		}
+		var err error
+		if proto, err = compiler.Compile(string(chunk), chunkName); err != nil {
+			s.stack.push(err.Error()) // 语法错误信息
+			return LUA_ERRSYNTAX
+		}
+		if ListChunks {
+			binchunk.List(proto)
+		}
 	}
 
-	// return 0
-
 	c := newLuaClosure(proto)
 	s.stack.push(c)
 	if len(proto.Upvalues) > 0 { // 设置 _ENV
 		env := s.registry.get(LUA_RIDX_GLOBALS)
 		c.upvals[0] = &upvalue{&env}
 	}
-	return 0
+	return LUA_OK
+}
+
+// 读取二进制chunk，被截断或者损坏时返回错误，比如"binary string: truncated precompiled chunk"
+// lua-5.3.4/src/lundump.c#luaU_undump()
+func undump(chunk []byte, chunkName string) (proto *binchunk.Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			name := chunkName
+			if strings.HasPrefix(name, "@") || strings.HasPrefix(name, "=") {
+				name = name[1:]
+			} else if strings.HasPrefix(name, binchunk.LUA_SIGNATURE[:1]) {
+				name = "binary string"
+			}
+			proto, err = nil, fmt.Errorf("%s: %v", name, r)
+		}
+	}()
+	return binchunk.Undump(chunk), nil
+}
+
+// mode可以是"b"（只允许二进制chunk）、"t"（只允许文本chunk）或者"bt"（两者都允许）
+// lua-5.3.4/src/ldo.c#checkmode()
+func (s *luaState) checkMode(mode, x string) bool {
+	if !strings.ContainsRune(mode, rune(x[0])) {
+		s.stack.push(fmt.Sprintf("attempt to load a %s chunk (mode is '%s')", x, mode))
+		return false
+	}
+	return true
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_dump
+func (s *luaState) Dump(strip bool) []byte {
+	if c, ok := s.stack.get(-1).(*closure); ok && c.proto != nil {
+		return binchunk.Dump(c.proto, strip)
+	}
+	return nil
+}
+
+// 由正在执行的Lua函数（也就是虚拟机的CALL和TFORCALL指令，以及调用元方法的指令）发起的调用可以让出，
+// 由Go函数发起的调用不能让出，Go函数需要让出时请使用CallK
+// [-(nargs+1), +nresults, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_call
 func (s *luaState) Call(nArgs, nResults int) {
+	if isLuaFrame(s.stack) {
+		s.call(nArgs, nResults)
+	} else {
+		s.callNoYield(nArgs, nResults)
+	}
+}
+
+// lua-5.3.4/src/ldo.c#luaD_call()
+func (s *luaState) call(nArgs, nResults int) {
+	if s.nCcalls++; s.nCcalls >= s.g.maxCalls {
+		s.checkCalls()
+	}
+	c, nArgs := s.getCallable(nArgs)
+	if c.proto != nil { // 调用lua函数
+		// fmt.Printf("call %s<%d,%d>\n", c.proto.Source, c.proto.LineDefined, c.proto.LastLineDefined)
+		s.callLuaClosure(nArgs, nResults, c)
+	} else { // 调用go函数
+		// funcPtr := runtime.FuncForPC(reflect.ValueOf(c.goFunc).Pointer())
+		// fmt.Printf("call %s<%v>\n", funcPtr.Name(), c.goFunc)
+		// PrintStack(s)
+		s.callGoClosure(nArgs, nResults, c)
+	}
+	// PrintStack(s)
+	s.nCcalls--
+}
+
+// 被调函数（以及它调用的所有函数）都不能让出。消息处理函数和__close元方法也通过它调用
+// lua-5.3.4/src/ldo.c#luaD_callnoyield()
+func (s *luaState) callNoYield(nArgs, nResults int) {
+	s.nny++
+	s.call(nArgs, nResults)
+	s.nny--
+}
+
+// [-(nargs + 1), +nresults, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_callk
+func (s *luaState) CallK(nArgs, nResults int, ctx KContext, k KFunction) {
+	if k != nil && s.nny == 0 { /* need to prepare continuation? */
+		ci := s.stack
+		ci.k, ci.ctx = k, ctx   /* save continuation */
+		s.call(nArgs, nResults) /* do the call */
+	} else { /* no continuation or no yieldable */
+		s.callNoYield(nArgs, nResults) /* just do the call */
+	}
+}
+
+// 获取被调函数，如果被调对象不是函数，就用它的__call元方法代替，原来的对象作为第一个参数
+func (s *luaState) getCallable(nArgs int) (*closure, int) {
 	val := s.stack.get(-(nArgs + 1)) // 获取被调函数
 
 	c, ok := val.(*closure)
@@ -40,23 +151,69 @@ func (s *luaState) Call(nArgs, nResults int) {
 			}
 		}
 	}
-	if ok {
-		if c.proto != nil { // 调用lua函数
-			// fmt.Printf("call %s<%d,%d>\n", c.proto.Source, c.proto.LineDefined, c.proto.LastLineDefined)
-			s.callLuaClosure(nArgs, nResults, c)
-		} else { // 调用go函数
-			// funcPtr := runtime.FuncForPC(reflect.ValueOf(c.goFunc).Pointer())
-			// fmt.Printf("call %s<%v>\n", funcPtr.Name(), c.goFunc)
-			// PrintStack(s)
-			s.callGoClosure(nArgs, nResults, c)
-		}
-		// PrintStack(s)
-	} else {
-		panic("not function!")
+	if !ok {
+		s.opError(val, "call")
+	}
+	return c, nArgs
+}
+
+// TailCall 执行TAILCALL指令：被调的Lua函数直接替换当前调用帧，所以无限深的尾递归也不会让调用栈增长。
+// 被调函数是Go函数时按普通调用执行，返回false，返回值留在栈顶
+// lua-5.3.4/src/lvm.c#OP_TAILCALL
+func (s *luaState) TailCall(nArgs int) bool {
+	c, nArgs := s.getCallable(nArgs)
+	if c.proto == nil {
+		s.callGoClosure(nArgs, -1, c)
+		return false
+	}
+
+	s.CloseUpvalues(1) // 当前帧里的局部变量即将失效
+	newStack := s.newLuaFrame(nArgs, c)
+	newStack.tailcall = true
+	newStack.nResults = s.stack.nResults
+	s.popLuaStack()
+	s.pushLuaStack(newStack)
+	if s.hookMask&LUA_MASKCALL != 0 {
+		s.runHook(LUA_HOOKTAILCALL, -1)
 	}
+	return true
 }
 
 func (s *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
+	newStack := s.newLuaFrame(nArgs, c)
+	newStack.nResults = nResults
+
+	s.pushLuaStack(newStack) // 我们把新调用帧推入调用栈顶，让它成为当前帧，然后调用runLuaClosure（）方法执行被调函数的指令。
+	s.callHook()
+	s.runLuaClosure() // 指令执行完毕之后，新调用帧的使命就结束了，把它从调用栈顶弹出，这样主调帧就又成了当前帧。被调函数运行完毕之后，返回值会留在被调帧的栈顶（寄存器之上）
+	s.posLuaCall()
+}
+
+// 尾调用可能已经替换了调用帧，返回值在最后执行的那一帧的寄存器之上
+func (s *luaState) posLuaCall() {
+	s.posCall(s.stack.top - s.RegisterCount())
+}
+
+// 弹出当前调用帧，把它栈顶的n个返回值按调用者期望的数量移动到主调帧
+// lua-5.3.4/src/ldo.c#luaD_poscall()
+func (s *luaState) posCall(n int) {
+	ci := s.stack
+	if len(ci.tbc) > 0 { /* 返回值已经在栈顶，关闭剩下的待关闭变量 */
+		s.closeTBC(0)
+	}
+	s.retHook()
+	s.popLuaStack()
+	s.oldPC = s.stack.pc - 1 /* 'oldpc' for caller function */
+
+	if ci.nResults != 0 {
+		results := ci.popN(n)
+		s.stack.check(len(results))
+		s.stack.pushN(results, ci.nResults)
+	}
+}
+
+// 为Lua函数创建调用帧，并把函数和参数从当前帧的栈顶移过去
+func (s *luaState) newLuaFrame(nArgs int, c *closure) *luaStack {
 	nRegs := int(c.proto.MaxStackSize) // 函数执行需要寄存器数量
 	nParams := int(c.proto.NumParams)  // 函数固定参数数量
 	isVararg := c.proto.IsVararg == 1  // 是否是vararg函数
@@ -70,20 +227,17 @@ func (s *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
 	if nArgs > nParams && isVararg { // 如果被调函数是vararg函数，且传入参数的数量多于固定参数数量，还需要把vararg参数记下来，存在调用帧里，以备后用
 		newStack.varargs = funcAndArgs[nParams+1:]
 	}
-
-	s.pushLuaStack(newStack) // 我们把新调用帧推入调用栈顶，让它成为当前帧，然后调用runLuaClosure（）方法执行被调函数的指令。
-	s.runLuaClosure()        // 指令执行完毕之后，新调用帧的使命就结束了，把它从调用栈顶弹出，这样主调帧就又成了当前帧。被调函数运行完毕之后，返回值会留在被调帧的栈顶（寄存器之上）
-	s.popLuaStack()
-
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		s.stack.check(len(results))
-		s.stack.pushN(results, nResults)
-	}
+	return newStack
 }
 
 func (s *luaState) runLuaClosure() {
 	for {
+		if s.g.limited {
+			s.checkLimits()
+		}
+		if s.hookMask&(LUA_MASKLINE|LUA_MASKCOUNT) != 0 {
+			s.traceExec()
+		}
 		inst := vm.Instruction(s.Fetch())
 		// fmt.Printf("[%02d] %s\n", s.stack.pc, inst.OpName())
 		// PrintStack(s)
@@ -94,39 +248,132 @@ func (s *luaState) runLuaClosure() {
 	}
 }
 
+// lua-5.3.4/src/ldo.c#callhook()
+func (s *luaState) callHook() {
+	if s.hookMask&LUA_MASKCALL != 0 {
+		s.runHook(LUA_HOOKCALL, -1)
+	}
+}
+
+// lua-5.3.4/src/ldo.c#rethook()
+func (s *luaState) retHook() {
+	if s.hookMask&LUA_MASKRET != 0 {
+		s.runHook(LUA_HOOKRET, -1)
+	}
+}
+
 func (s *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	newStack := newLuaStack(nArgs+LUA_MINSTACK, s)
 	newStack.closure = c
+	newStack.nResults = nResults
 
 	args := s.stack.popN(nArgs)
 	newStack.pushN(args, nArgs)
 	s.stack.pop() // pop closure
 
 	s.pushLuaStack(newStack)
+	s.callHook()
 	r := c.goFunc(s) // 调用go函数
-	s.popLuaStack()
+	s.posCall(r)
+}
 
-	if nResults != 0 {
-		results := newStack.popN(r)
-		s.stack.check(len(results))
-		s.stack.pushN(results, nResults)
+// 以保护模式调用函数。msgh不为0时，它是消息处理函数的索引：
+// 出错时先在出错的地方（调用栈还没有展开）调用消息处理函数，把它的返回值作为错误对象。
+// 出错时被调函数和参数都会从栈里移除，错误对象留在栈顶
+// [-(nargs + 1), +(nresults|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pcall
+func (s *luaState) PCall(nArgs, nResults, msgh int) int {
+	return s.PCallK(nArgs, nResults, msgh, 0, nil)
+}
+
+// 在可以让出的协程里，被调函数直接执行（不设置恢复点），出错时由Resume找到这个调用帧，
+// 把错误对象交给延续函数k；否则和普通的保护调用一样
+// [-(nargs + 1), +(nresults|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pcallk
+func (s *luaState) PCallK(nArgs, nResults, msgh int, ctx KContext, k KFunction) int {
+	var handler luaValue
+	if msgh != 0 {
+		handler = s.stack.get(msgh)
+	}
+	if k == nil || s.nny > 0 { /* no continuation or no yieldable? */
+		return s.pcall(nArgs, nResults, handler) /* do a 'conventional' protected call */
 	}
+	/* prepare continuation (call is already protected by 'resume') */
+	ci := s.stack
+	ci.k, ci.ctx = k, ctx                 /* save continuation */
+	ci.oldTop = ci.top - (nArgs + 1)      /* function index */
+	ci.errFunc, ci.ypcall = handler, true /* function can do error recovery */
+	s.call(nArgs, nResults)               /* do the call */
+	ci.errFunc, ci.ypcall = nil, false
+	return LUA_OK
 }
 
-func (s *luaState) PCall(nArgs, nResults, msgh int) (status int) {
+// lua-5.3.4/src/ldo.c#luaD_pcall()
+func (s *luaState) pcall(nArgs, nResults int, handler luaValue) (status int) {
 	caller := s.stack
+	oldTop := caller.top - (nArgs + 1) /* function index */
+	oldNny, oldNCcalls := s.nny, s.nCcalls
 	status = LUA_ERRRUN
 
 	defer func() {
-		if err := recover(); err != nil {
+		if r := recover(); r != nil {
+			err := errorValue(r)
+			status = errorStatus(r)
+			rethrow := s.uncatchable(status) && caller.prev != nil
+			if handler != nil && !rethrow && status != LUA_ERRMEM {
+				err, status = s.callMsgHandler(handler, err, status)
+			}
+			tbc := s.pendingTBC(caller, oldTop)
 			for s.stack != caller {
 				s.popLuaStack()
 			}
-			s.stack.push(err)
+			for caller.top > oldTop { /* 被调函数可能还没有从栈里移走 */
+				caller.pop()
+			}
+			s.nny, s.nCcalls = oldNny, oldNCcalls
+			status, err = s.closeProtected(tbc, status, err)
+			if rethrow { /* 不能被捕获的中断继续抛给外层的保护调用 */
+				panic(r)
+			}
+			caller.check(1)
+			caller.push(err)
 		}
 	}()
 
-	s.Call(nArgs, nResults)
+	s.callNoYield(nArgs, nResults)
 	status = LUA_OK
 	return
 }
+
+// 在出错的地方调用消息处理函数，返回新的错误对象和原来的状态码；消息处理函数本身出错时返回LUA_ERRERR
+// lua-5.3.4/src/ldebug.c#luaG_errormsg()
+func (s *luaState) callMsgHandler(handler, err luaValue, status int) (result luaValue, newStatus int) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, newStatus = "error in error handling", LUA_ERRERR
+		}
+	}()
+	s.stack.check(2)
+	s.stack.push(handler) /* push function */
+	s.stack.push(err)     /* push error object */
+	s.callNoYield(1, 1)   /* call it */
+	return s.stack.pop(), status
+}
+
+// 把recover()得到的值转换成Lua错误对象：Lua错误原样返回，Go运行时错误（比如空指针引用）转换成字符串
+func errorValue(r interface{}) luaValue {
+	switch x := r.(type) {
+	case *runtime.PanicNilError: /* error(nil) */
+		return nil
+	case interruptSignal:
+		return x.msg
+	case memoryError:
+		return memErrMsg
+	case bool, int64, float64, string, *luaTable, *closure, *luaState, *userdata, lightUserdata:
+		return x
+	case error:
+		return x.Error()
+	default:
+		return fmt.Sprint(x)
+	}
+}
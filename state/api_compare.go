@@ -56,6 +56,13 @@ func _eq(a, b luaValue, ls *luaState) bool {
 			}
 		}
 		return a == b
+	case *userdata: // 完全用户数据和表一样可以通过__eq元方法比较
+		if y, ok := b.(*userdata); ok && x != y && ls != nil {
+			if result, ok := callMetamethod(x, y, "__eq", ls); ok {
+				return convertToBoolean(result)
+			}
+		}
+		return a == b
 	default:
 		return a == b
 	}
@@ -84,7 +91,8 @@ func _lt(a, b luaValue, ls *luaState) bool {
 	if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	} else {
-		panic("comparison error!")
+		ls.orderError(a, b)
+		return false
 	}
 }
 
@@ -112,11 +120,15 @@ func _le(a, b luaValue, ls *luaState) bool {
 
 	if result, ok := callMetamethod(a, b, "__le", ls); ok {
 		return convertToBoolean(result)
-	} else if result, ok := callMetamethod(b, a, "__lt", ls); ok {
+	}
+	ls.stack.leq = true /* mark it is doing 'lt' for 'le' */
+	result, ok := callMetamethod(b, a, "__lt", ls)
+	ls.stack.leq = false
+	if ok {
 		return !convertToBoolean(result)
-	} else {
-		panic("comparison error!")
 	}
+	ls.orderError(a, b)
+	return false
 }
 
 func (s *luaState) RawEqual(idx1, idx2 int) bool {
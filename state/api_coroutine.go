@@ -2,55 +2,257 @@ package state
 
 import (
 	. "luago/api"
+	"luago/vm"
 )
 
+// 协程不再占用goroutine：YieldK通过panic回到Resume，沿途的Go调用全部丢弃，
+// 但是调用帧还留在线程的调用栈里。恢复时由unroll从栈顶开始逐个完成这些调用帧：
+// Lua函数从被打断的指令接着执行，Go函数调用它的延续函数
+type yieldSignal struct{}
+
 func (s *luaState) NewThread() LuaState {
-	t := &luaState{registry: s.registry}
+	s.allocate(threadSize + frameSize + LUA_MINSTACK*valueSize)
+	t := &luaState{g: s.g, registry: s.registry, nny: 1}
+	t.SetHook(s.hook, s.hookMask, s.baseHookCount) // 新线程继承创建者的钩子
 	t.pushLuaStack(newLuaStack(LUA_MINSTACK, t))
 	s.stack.push(t)
+	s.checkGC()
 	return t
 }
 
-func (s *luaState) Resume(from LuaState, nArgs int) (status int) {
-	lsFrom := from.(*luaState)
-	if lsFrom.coChan == nil {
-		lsFrom.coChan = make(chan int)
+// [-?, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_resume
+// lua-5.3.4/src/ldo.c#lua_resume()
+func (s *luaState) Resume(from LuaState, nArgs int) int {
+	if s.coStatus == LUA_OK { /* may be starting a coroutine */
+		if s.stack.prev != nil { /* not in base level? */
+			return s.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+		if s.stack.top-nArgs < 1 { /* does not have a function? */
+			return s.resumeError("cannot resume dead coroutine", nArgs)
+		}
+	} else if s.coStatus != LUA_YIELD {
+		return s.resumeError("cannot resume dead coroutine", nArgs)
 	}
 
-	if s.coChan == nil { // start coroutine
-		s.coChan = make(chan int)
-		s.coCaller = lsFrom
-		go func() {
-			s.coStatus = s.PCall(nArgs, -1, 0)
-			lsFrom.coChan <- 1
-		}()
-	} else { // resume coroutine
-		s.coStatus = LUA_OK
-		s.coChan <- 1
+	l, _ := from.(*luaState)
+	if l != nil {
+		s.nCcalls = l.nCcalls + 1
+	} else {
+		s.nCcalls = 1
+	}
+	if s.nCcalls >= LUAI_MAXCCALLS {
+		return s.resumeError("C stack overflow", nArgs)
+	}
+	oldNny := s.nny /* save "number of non-yieldable" calls */
+	s.nny = 0       /* allow yields */
+	status, err := s.runProtected(func() {
+		if s.nCcalls >= s.g.maxCalls { /* 从恢复者继承的层数已经达到上限 */
+			s.checkCalls()
+		}
+		s.resume(nArgs)
+	})
+	for isErrorStatus(status) && !s.uncatchable(status) && s.recoverPCall(err) { /* continue running after recoverable errors */
+		errStatus := status
+		status, err = s.runProtected(func() { /* unroll continuation */
+			s.finishGoCall(errStatus) /* finish 'PCallK' callee */
+			s.unroll()
+		})
 	}
+	if isErrorStatus(status) { /* unrecoverable error? */
+		s.coStatus = status /* mark thread as 'dead' */
+		s.stack.check(2)
+		s.stack.push(err) /* 留一份错误对象给CloseThread */
+		s.stack.push(err) /* push error message */
+	}
+	s.nny = oldNny /* restore 'nny' */
+	// 不能被捕获的中断继续在恢复者里抛出
+	if s.uncatchable(status) && l != nil && l.stack.prev != nil {
+		panic(interruptSignal{err.(string)})
+	}
+	return status
+}
 
-	<-lsFrom.coChan // wait coroutine to finish or yield
-	return s.coStatus
+func isErrorStatus(status int) bool {
+	return status > LUA_YIELD
+}
+
+// 移除传给协程的参数，把错误信息压入栈顶
+// lua-5.3.4/src/ldo.c#resume_error()
+func (s *luaState) resumeError(msg string, nArgs int) int {
+	s.stack.popN(nArgs) /* remove args from the stack */
+	s.stack.push(msg)   /* push error message */
+	return LUA_ERRRUN
+}
+
+// 以保护模式执行f，返回状态码和错误对象。协程让出时f也会提前结束，返回LUA_YIELD
+// lua-5.3.4/src/ldo.c#luaD_rawrunprotected()
+func (s *luaState) runProtected(f func()) (status int, err luaValue) {
+	oldNCcalls := s.nCcalls
+	defer func() {
+		s.nCcalls = oldNCcalls
+		if r := recover(); r != nil {
+			if _, ok := r.(yieldSignal); ok {
+				status = LUA_YIELD
+				return
+			}
+			status, err = errorStatus(r), errorValue(r)
+			if ci := s.findPCall(); ci != nil && ci.errFunc != nil &&
+				!s.uncatchable(status) && status != LUA_ERRMEM { /* 内存错误不调用消息处理函数 */
+				err, status = s.callMsgHandler(ci.errFunc, err, status)
+			}
+		}
+	}()
+	f()
+	return LUA_OK, nil
 }
 
+// lua-5.3.4/src/ldo.c#resume()
+func (s *luaState) resume(nArgs int) {
+	if s.coStatus == LUA_OK { /* starting a coroutine? */
+		s.call(nArgs, LUA_MULTRET) /* just call its body */
+		return
+	}
+	/* resuming from previous yield */
+	s.coStatus = LUA_OK /* mark that it is running (again) */
+	ci := s.stack
+	args := ci.popN(ci.top)
+	ci.pushN(ci.saved, -1) /* 还原让出之前的栈 */
+	ci.pushN(args, -1)
+	ci.saved = nil
+	n := nArgs       /* 没有延续函数时，传给Resume的参数就是让出函数的返回值 */
+	if ci.k != nil { /* does it have a continuation function? */
+		n = ci.k(s, LUA_YIELD, ci.ctx) /* call continuation */
+	}
+	s.posCall(n) /* finish 'callGoClosure' */
+	s.unroll()   /* run continuation */
+}
+
+// 完成调用栈里所有被打断的调用，直到协程的主函数返回
+// lua-5.3.4/src/ldo.c#unroll()
+func (s *luaState) unroll() {
+	for s.stack.prev != nil { /* something in the stack */
+		if !isLuaFrame(s.stack) { /* Go function? */
+			s.finishGoCall(LUA_YIELD) /* complete its execution */
+		} else { /* Lua function */
+			ci := s.stack
+			if ci.leq { /* "<=" using "<" instead? */
+				ci.leq = false
+				ci.push(!convertToBoolean(ci.pop())) /* negate result */
+			}
+			vm.Instruction(ci.closure.proto.Code[ci.pc-1]).Finish(s) /* finish interrupted instruction */
+			s.runLuaClosure()                                        /* execute down to higher Go 'boundary' */
+			s.posLuaCall()
+		}
+	}
+}
+
+// 调用Go函数的延续函数，完成这个Go函数的调用。
+// 只有通过CallK和PCallK调用（或者通过YieldK让出）的Go函数才会被打断，所以它一定有延续函数
+// lua-5.3.4/src/ldo.c#finishCcall()
+func (s *luaState) finishGoCall(status int) {
+	ci := s.stack
+	ci.errFunc, ci.ypcall = nil, false /* continuation is also inside it */
+	n := ci.k(s, status, ci.ctx)       /* call continuation function */
+	s.posCall(n)                       /* finish 'callGoClosure' */
+}
+
+// 寻找正在执行可以让出的PCallK的调用帧
+// lua-5.3.4/src/ldo.c#findpcall()
+func (s *luaState) findPCall() *luaStack {
+	for ci := s.stack; ci != nil; ci = ci.prev { /* search for a pcall */
+		if ci.ypcall {
+			return ci
+		}
+	}
+	return nil /* no pending pcall */
+}
+
+// 协程里出错时，如果调用栈里有可以让出的PCallK，就展开到它所在的调用帧，把错误对象放在被调函数的位置，
+// 然后可以从这里继续执行
+// lua-5.3.4/src/ldo.c#recover()
+func (s *luaState) recoverPCall(err luaValue) bool {
+	ci := s.findPCall()
+	if ci == nil {
+		return false /* no recovery point */
+	}
+	/* "finish" luaD_pcall */
+	tbc := s.pendingTBC(ci, ci.oldTop)
+	for s.stack != ci {
+		s.popLuaStack()
+	}
+	for ci.top > ci.oldTop {
+		ci.pop()
+	}
+	_, err = s.closeProtected(tbc, LUA_ERRRUN, err)
+	ci.check(1)
+	ci.push(err)
+	s.nny = 0   /* should be zero to be yieldable */
+	return true /* continue running the coroutine */
+}
+
+// [-?, +?, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_yield
 func (s *luaState) Yield(nResults int) int {
+	return s.YieldK(nResults, 0, nil)
+}
+
+// 让出协程。这个方法不会返回：协程恢复时，有延续函数就调用延续函数，
+// 否则传给Resume的参数直接作为让出的Go函数的返回值
+// [-?, +?, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_yieldk
+// lua-5.3.4/src/ldo.c#lua_yieldk()
+func (s *luaState) YieldK(nResults int, ctx KContext, k KFunction) int {
+	if s.nny > 0 {
+		if !s.isMainThread() {
+			s.runError("attempt to yield across a C-call boundary")
+		}
+		s.runError("attempt to yield from outside a coroutine")
+	}
 	s.coStatus = LUA_YIELD
-	s.coCaller.coChan <- 1
-	<-s.coChan
-	return s.GetTop()
+	ci := s.stack
+	ci.k, ci.ctx = k, ctx /* save continuation */
+	results := ci.popN(nResults)
+	ci.saved = ci.popN(ci.top) /* 让出期间栈里只留下让出的值 */
+	ci.pushN(results, nResults)
+	panic(yieldSignal{})
 }
 
-func (s *luaState) IsYieldable() bool {
-	if s.isMainThread() {
-		return false
+// 调用帧（包括出错结束的协程留下的调用帧）全部丢弃，栈清空，线程回到没有运行过的状态。
+// 调用帧里的待关闭变量在丢弃之前关闭。
+// 线程是因为出错而结束的（或者__close元方法出错），返回状态码，并把错误对象留在栈顶
+// [-0, +?, –]
+// http://www.lua.org/manual/5.4/manual.html#lua_closethread
+// lua-5.4.6/src/lstate.c#luaE_resetthread()
+func (s *luaState) CloseThread(from LuaState) int {
+	status := s.coStatus
+	if status == LUA_YIELD {
+		status = LUA_OK
+	}
+	var err luaValue
+	if status != LUA_OK {
+		err = s.stack.get(-1) /* error message on current top */
+	}
+	tbc := s.pendingTBC(nil, 0)
+	s.stack = newLuaStack(LUA_MINSTACK, s) /* unwind CallInfo list */
+	s.coStatus = LUA_OK
+	status, err = s.closeProtected(tbc, status, err)
+	if status != LUA_OK { /* errors? */
+		s.stack.push(err)
 	}
-	return s.coStatus != LUA_YIELD
+	return status
 }
 
-func (s *luaState) Status() int {
-	return s.coStatus
+// [-0, +?, –]
+// http://www.lua.org/manual/5.4/manual.html#lua_resetthread
+func (s *luaState) ResetThread() int {
+	return s.CloseThread(nil)
 }
 
-func (s *luaState) GetStack() bool {
-	return s.stack.prev != nil
+func (s *luaState) IsYieldable() bool {
+	return s.nny == 0
+}
+
+func (s *luaState) Status() int {
+	return s.coStatus
 }
@@ -0,0 +1,266 @@
+package state
+
+import (
+	. "luago/api"
+	"luago/binchunk"
+	"strings"
+)
+
+// 返回函数的第n个upvalue（从1开始）和它的名字
+// lua-5.3.4/src/lapi.c#aux_upvalue()
+func auxUpvalue(val luaValue, n int) (*upvalue, string, bool) {
+	c, ok := val.(*closure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return nil, "", false /* 'n' not in [1, len(upvals)] */
+	}
+	if c.proto == nil { /* Go closure */
+		return c.upvals[n-1], "", true
+	}
+	name := "(*no name)"
+	if n <= len(c.proto.UpvalueNames) {
+		name = c.proto.UpvalueNames[n-1]
+	}
+	return c.upvals[n-1], name, true
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setupvalue
+func (s *luaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	fn := s.stack.get(funcIdx)
+	uv, name, ok := auxUpvalue(fn, n)
+	if ok {
+		val := s.stack.pop()
+		if uv == nil { // 还没有初始化的upvalue
+			fn.(*closure).upvals[n-1] = &upvalue{&val}
+		} else {
+			*uv.val = val
+		}
+	}
+	return name, ok
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getupvalue
+func (s *luaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	uv, name, ok := auxUpvalue(s.stack.get(funcIdx), n)
+	if ok {
+		if uv == nil { // 还没有初始化的upvalue
+			s.stack.push(nil)
+		} else {
+			s.stack.push(*uv.val)
+		}
+	}
+	return name, ok
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvalueid
+func (s *luaState) UpvalueId(funcIdx, n int) interface{} {
+	return getUpvalueRef(s.stack.get(funcIdx).(*closure), n)
+}
+
+// 让第一个闭包的第n1个upvalue引用第二个闭包的第n2个upvalue
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvaluejoin
+func (s *luaState) UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int) {
+	c1 := s.stack.get(funcIdx1).(*closure)
+	c2 := s.stack.get(funcIdx2).(*closure)
+	c1.upvals[n1-1] = getUpvalueRef(c2, n2)
+}
+
+// 返回闭包的第n个upvalue，还没有初始化的upvalue先初始化成nil
+// lua-5.3.4/src/lapi.c#getupvalref()
+func getUpvalueRef(c *closure, n int) *upvalue {
+	if c.upvals[n-1] == nil {
+		var val luaValue
+		c.upvals[n-1] = &upvalue{&val}
+	}
+	return c.upvals[n-1]
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getstack
+func (s *luaState) GetStack(level int, ar *DebugInfo) bool {
+	if level < 0 {
+		return false /* invalid (negative) level */
+	}
+	frame := s.stack
+	for ; level > 0 && frame.prev != nil; level-- {
+		frame = frame.prev
+	}
+	if level == 0 && frame.prev != nil { /* level found? */
+		ar.CallInfo = frame
+		return true
+	}
+	return false /* no such level */
+}
+
+// [-(0|1), +(0|1|2), e]
+// http://www.lua.org/manual/5.3/manual.html#lua_getinfo
+func (s *luaState) GetInfo(what string, ar *DebugInfo) bool {
+	var frame *luaStack
+	var fn luaValue
+	if strings.HasPrefix(what, ">") {
+		fn = s.stack.pop()
+		what = what[1:] /* skip the '>' */
+	} else {
+		frame = ar.CallInfo.(*luaStack)
+		fn = frame.closure
+	}
+	c, ok := fn.(*closure)
+	if !ok {
+		panic("function expected!")
+	}
+
+	status := true
+	for _, opt := range what {
+		switch opt {
+		case 'S':
+			funcInfo(ar, c)
+		case 'l':
+			ar.CurrentLine = -1
+			if frame != nil && isLuaFrame(frame) {
+				ar.CurrentLine = currentLine(frame)
+			}
+		case 'u':
+			ar.NUps = len(c.upvals)
+			if c.proto == nil {
+				ar.IsVararg = true
+				ar.NParams = 0
+			} else {
+				ar.IsVararg = c.proto.IsVararg == 1
+				ar.NParams = int(c.proto.NumParams)
+			}
+		case 't':
+			ar.IsTailCall = frame != nil && frame.tailcall
+		case 'n':
+			ar.Name, ar.NameWhat = getFuncName(frame)
+		case 'L', 'f': /* handled below */
+		default: /* invalid option */
+			status = false
+		}
+	}
+	if strings.ContainsRune(what, 'f') {
+		s.stack.push(c)
+	}
+	if strings.ContainsRune(what, 'L') {
+		s.collectValidLines(c)
+	}
+	return status
+}
+
+// lua-5.3.4/src/ldebug.c#funcinfo()
+func funcInfo(ar *DebugInfo, c *closure) {
+	if c.proto == nil {
+		ar.Source = "=[C]"
+		ar.LineDefined = -1
+		ar.LastLineDefined = -1
+		ar.What = "C"
+	} else {
+		proto := c.proto
+		ar.Source = proto.Source
+		if ar.Source == "" {
+			ar.Source = "=?"
+		}
+		ar.LineDefined = int(proto.LineDefined)
+		ar.LastLineDefined = int(proto.LastLineDefined)
+		if ar.LineDefined == 0 {
+			ar.What = "main"
+		} else {
+			ar.What = "Lua"
+		}
+	}
+	ar.ShortSrc = binchunk.ChunkID(ar.Source)
+}
+
+// 把函数里有代码的行号收集到一个表里（行号为键，true为值），推入栈顶；Go函数推入nil
+// lua-5.3.4/src/ldebug.c#collectvalidlines()
+func (s *luaState) collectValidLines(c *closure) {
+	if c.proto == nil {
+		s.stack.push(nil)
+		return
+	}
+	t := newLuaTable(0, len(c.proto.LineInfo))
+	for _, line := range c.proto.LineInfo {
+		t.put(int64(line), true)
+	}
+	s.stack.push(t)
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getlocal
+func (s *luaState) GetLocal(ar *DebugInfo, n int) string {
+	if ar == nil { /* information about non-active function? */
+		if c, ok := s.stack.get(-1).(*closure); ok && c.proto != nil {
+			return localName(c.proto, n, 0) /* parameters */
+		}
+		return "" /* not a Lua function */
+	}
+	name, slot := findLocal(ar.CallInfo.(*luaStack), n)
+	if slot != nil {
+		s.stack.push(*slot)
+	}
+	return name
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setlocal
+func (s *luaState) SetLocal(ar *DebugInfo, n int) string {
+	name, slot := findLocal(ar.CallInfo.(*luaStack), n)
+	if slot != nil {
+		*slot = s.stack.pop()
+	}
+	return name
+}
+
+// 返回调用帧里第n个局部变量的名字和位置；n为负数时访问vararg参数
+// lua-5.3.4/src/ldebug.c#findlocal()
+func findLocal(frame *luaStack, n int) (string, *luaValue) {
+	name := ""
+	if isLuaFrame(frame) {
+		if n < 0 { /* access to vararg values? */
+			if -n <= len(frame.varargs) {
+				return "(*vararg)", &frame.varargs[-n-1]
+			}
+			return "", nil /* no such vararg */
+		}
+		name = localName(frame.closure.proto, n, currentPC(frame))
+	}
+	if name == "" { /* no 'standard' name? */
+		if n <= 0 || n > frame.top { /* is 'n' inside 'ci' stack? */
+			return "", nil /* no name */
+		}
+		name = "(*temporary)" /* generic name for any valid slot */
+	}
+	return name, &frame.slots[n-1]
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_sethook
+func (s *luaState) SetHook(f Hook, mask, count int) {
+	if f == nil || mask == 0 { /* turn off hooks? */
+		f, mask = nil, 0
+	}
+	s.hook = f
+	s.hookMask = mask
+	s.baseHookCount = count
+	s.hookCount = count
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethook
+func (s *luaState) GetHook() Hook {
+	return s.hook
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookmask
+func (s *luaState) GetHookMask() int {
+	return s.hookMask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookcount
+func (s *luaState) GetHookCount() int {
+	return s.baseHookCount
+}
@@ -3,8 +3,10 @@ package state
 import . "luago/api"
 
 func (s *luaState) CreateTable(nArr, nRec int) {
+	s.allocate(tableSize + nArr*valueSize + nRec*nodeSize)
 	t := newLuaTable(nArr, nRec)
 	s.stack.push(t)
+	s.checkGC()
 }
 
 func (s *luaState) NewTable() {
@@ -43,7 +45,8 @@ func (s *luaState) getTable(t, k luaValue, raw bool) LuaType {
 		}
 	}
 
-	panic("index error!")
+	s.opError(t, "index")
+	return LUA_TNIL
 }
 
 func (s *luaState) GetField(idx int, k string) LuaType {
@@ -72,6 +75,28 @@ func (s *luaState) RawGetI(idx int, i int64) LuaType {
 	return s.getTable(t, i, true)
 }
 
+// 分配size字节的内存块作为新的完全用户数据，推入栈顶，并返回这块内存
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newuserdata
+func (s *luaState) NewUserData(size int) []byte {
+	s.allocate(udataSize + size)
+	b := make([]byte, size)
+	s.stack.push(newUserdata(b))
+	s.checkGC()
+	return b
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getuservalue
+func (s *luaState) GetUserValue(idx int) LuaType {
+	ud, ok := s.stack.get(idx).(*userdata)
+	if !ok {
+		panic("full userdata expected!")
+	}
+	s.stack.push(ud.uservalue)
+	return typeOf(ud.uservalue)
+}
+
 func (s *luaState) GetMetatable(idx int) bool {
 	val := s.stack.get(idx)
 	if mt := getMetatable(val, s); mt != nil {
@@ -1,6 +1,9 @@
 package state
 
-import "luago/number"
+import (
+	. "luago/api"
+	"luago/number"
+)
 
 func (s *luaState) Len(idx int) {
 	val := s.stack.get(idx)
@@ -11,7 +14,7 @@ func (s *luaState) Len(idx int) {
 	} else if t, ok := val.(*luaTable); ok {
 		s.stack.push(int64(t.len()))
 	} else {
-		panic("length error!")
+		s.opError(val, "get length of")
 	}
 }
 
@@ -23,6 +26,7 @@ func (s *luaState) Concat(n int) {
 			if s.IsString(-1) && s.IsString(-2) {
 				s2 := s.ToString(-1)
 				s1 := s.ToString(-2)
+				s.allocate(stringSize + len(s1) + len(s2))
 				s.stack.pop()
 				s.stack.pop()
 				s.stack.push(s1 + s2)
@@ -36,10 +40,11 @@ func (s *luaState) Concat(n int) {
 				continue
 			}
 
-			panic("concatenation error!")
+			s.concatError(a, b)
 		}
 	}
 	// n == 1, do nothing
+	s.checkGC()
 }
 
 // Next（）方法根据键获取表的下一个键值对。其中表的索引由参数指定，上一个键从栈顶弹出。
@@ -65,6 +70,64 @@ func (s *luaState) Error() int {
 	panic(err)
 }
 
+// 内存统计来自Go运行时（整个进程的堆内存）；停止回收只影响Lua的标记阶段和终结器，Go的回收器照常运行
+// [-0, +0, m]
+// http://www.lua.org/manual/5.4/manual.html#lua_gc
+// lua-5.4.6/src/lapi.c#lua_gc()
+func (s *luaState) GC(what int, args ...int) int {
+	arg := func(i int) int {
+		if i < len(args) {
+			return args[i]
+		}
+		return 0
+	}
+	g := s.g
+	res := 0
+	switch what {
+	case LUA_GCSTOP:
+		g.gcRunning = false
+	case LUA_GCRESTART:
+		g.gcRunning = true
+		g.gcDebt = 0
+	case LUA_GCCOLLECT:
+		s.fullCollect()
+	case LUA_GCCOUNT:
+		/* GC values are expressed in Kbytes: #bytes/2^10 */
+		res = g.totalBytes >> 10
+	case LUA_GCCOUNTB:
+		res = g.totalBytes & 0x3ff
+	case LUA_GCSTEP:
+		/* 标记阶段没法分步执行，每一步都是一次完整的回收 */
+		s.fullCollect()
+		res = 1 /* signal it */
+	case LUA_GCSETPAUSE:
+		res = g.gcPause
+		g.gcPause = arg(0)
+	case LUA_GCSETSTEPMUL:
+		res = g.gcStepMul
+		g.gcStepMul = arg(0)
+	case LUA_GCISRUNNING:
+		if g.gcRunning {
+			res = 1
+		}
+	case LUA_GCGEN: /* minormul和majormul没有效果 */
+		res = g.gcKind
+		g.gcKind = LUA_GCGEN
+	case LUA_GCINC:
+		res = g.gcKind
+		if pause := arg(0); pause != 0 {
+			g.gcPause = pause
+		}
+		if stepmul := arg(1); stepmul != 0 {
+			g.gcStepMul = stepmul
+		}
+		g.gcKind = LUA_GCINC
+	default:
+		res = -1 /* invalid option */
+	}
+	return res
+}
+
 func (self *luaState) StringToNumber(s string) bool {
 	if n, ok := number.ParseInteger(s); ok {
 		self.PushInteger(n)
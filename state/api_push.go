@@ -22,23 +22,29 @@ func (s *luaState) PushNumber(n float64) {
 }
 
 func (s *luaState) PushString(str string) {
+	s.allocate(stringSize + len(str))
 	s.stack.push(str)
+	s.checkGC()
 }
 
 // [-0, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushfstring
 func (self *luaState) PushFString(fmtStr string, a ...interface{}) {
 	str := fmt.Sprintf(fmtStr, a...)
+	self.allocate(stringSize + len(str))
 	self.stack.push(str)
+	self.checkGC()
 }
 
 func (s *luaState) PushGoFunction(f GoFunction) {
+	s.allocate(closureSize)
 	s.stack.push(newGoClosure(f, 0))
 }
 
 func (s *luaState) PushGoClosure(f GoFunction, n int) {
+	s.allocate(closureSize + n*upvalSize)
 	closure := newGoClosure(f, n)
-	for i := 0; i < n; i++ {
+	for i := n - 1; i >= 0; i-- { // 第一个upvalue最先入栈
 		val := s.stack.pop()
 		closure.upvals[i] = &upvalue{&val}
 	}
@@ -50,6 +56,20 @@ func (s *luaState) PushGlobalTable() {
 	s.stack.push(global)
 }
 
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pushlightuserdata
+func (s *luaState) PushLightUserData(p interface{}) {
+	s.stack.push(lightUserdata{p})
+}
+
+// 把任意Go值包装成一个新的完全用户数据，推入栈顶
+// [-0, +1, m]
+func (s *luaState) PushUserData(data interface{}) {
+	s.allocate(udataSize)
+	s.stack.push(newUserdata(data))
+	s.checkGC()
+}
+
 func (s *luaState) PushThread() bool {
 	s.stack.push(s)
 	return s.isMainThread()
@@ -1,6 +1,9 @@
 package state
 
-import . "luago/api"
+import (
+	. "luago/api"
+	"math"
+)
 
 func (s *luaState) SetTable(idx int) {
 	t := s.stack.get(idx)
@@ -11,7 +14,16 @@ func (s *luaState) SetTable(idx int) {
 
 func (s *luaState) setTable(t, k, v luaValue, raw bool) {
 	if tbl, ok := t.(*luaTable); ok {
-		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+		old := tbl.get(k)
+		if raw || old != nil || !tbl.hasMetafield("__newindex") {
+			if k == nil {
+				s.runError("table index is nil")
+			} else if f, ok := k.(float64); ok && math.IsNaN(f) {
+				s.runError("table index is NaN")
+			}
+			if old == nil && v != nil { /* new key */
+				s.allocate(tbl.entrySize(k))
+			}
 			tbl.put(k, v)
 			return
 		}
@@ -34,7 +46,7 @@ func (s *luaState) setTable(t, k, v luaValue, raw bool) {
 		}
 	}
 
-	panic("not a table!")
+	s.opError(t, "index")
 }
 
 func (s *luaState) SetField(idx int, k string) {
@@ -73,6 +85,16 @@ func (s *luaState) Register(name string, f GoFunction) {
 	s.SetGlobal(name)
 }
 
+// [-1, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setuservalue
+func (s *luaState) SetUserValue(idx int) {
+	ud, ok := s.stack.get(idx).(*userdata)
+	if !ok {
+		panic("full userdata expected!")
+	}
+	ud.uservalue = s.stack.pop()
+}
+
 func (s *luaState) SetMetatable(idx int) {
 	val := s.stack.get(idx)
 	mtVal := s.stack.pop()
@@ -10,12 +10,21 @@ func (s *luaState) AbsIndex(idx int) int {
 	return s.stack.absIndex(idx)
 }
 
+// 栈的剩余空间不够、而且扩展栈会超过内存上限时返回false
+// lua-5.3.4/src/lapi.c#lua_checkstack()
 func (s *luaState) CheckStack(n int) bool {
+	if free := len(s.stack.slots) - s.stack.top; n > free && !s.hasMemory((n-free)*valueSize) {
+		return false
+	}
 	s.stack.check(n)
-	return true // todo
+	return true
 }
 
 func (s *luaState) Pop(n int) {
+	if len(s.stack.tbc) > 0 {
+		s.SetTop(-n - 1)
+		return
+	}
 	for i := 0; i < n; i++ {
 		s.stack.pop()
 	}
@@ -79,7 +88,12 @@ func (s *luaState) SetTop(idx int) {
 	}
 	n := s.stack.top - newTop
 	if n > 0 {
-		s.Pop(n)
+		if len(s.stack.tbc) > 0 { /* 被移除的待关闭变量需要关闭 */
+			s.closeTBC(newTop)
+		}
+		for ; n > 0; n-- {
+			s.stack.pop()
+		}
 	} else if n < 0 {
 		for i := 0; i > n; i-- {
 			s.stack.push(nil)
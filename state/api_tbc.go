@@ -0,0 +1,95 @@
+package state
+
+import . "luago/api"
+
+// 待关闭变量（to-be-closed variable）：每个调用帧在tbc里按顺序记录被标记的栈位置。
+// 离开作用域（块结束、break、goto、return）时依次调用它们的__close元方法，后标记的先关闭；
+// 出错时由捕获错误的PCall负责关闭展开的调用帧里的变量，协程里的变量由CloseThread关闭。
+// __close元方法不能让出
+// lua-5.4.6/src/lfunc.c
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.4/manual.html#lua_toclose
+// lua-5.4.6/src/lfunc.c#luaF_newtbcupval()
+func (s *luaState) ToClose(idx int) {
+	ci := s.stack
+	slot := ci.absIndex(idx) - 1
+	val := ci.slots[slot]
+	if val == nil || val == false { /* false doesn't need to be closed */
+		return
+	}
+	if getMetafield(val, "__close", s) == nil { /* no metamethod? */
+		name, _ := findLocal(ci, slot+1)
+		if name == "" {
+			name = "?"
+		}
+		s.runError("variable '%s' got a non-closable value", name)
+	}
+	ci.tbc = append(ci.tbc, slot)
+}
+
+// [-0, +0, e]
+// http://www.lua.org/manual/5.4/manual.html#lua_closeslot
+func (s *luaState) CloseSlot(idx int) {
+	slot := s.stack.absIndex(idx) - 1
+	s.closeTBC(slot)
+	s.stack.slots[slot] = nil
+}
+
+// 正常离开作用域：关闭当前调用帧里位置不低于level（从0开始）的待关闭变量，错误对象是nil
+// lua-5.4.6/src/lfunc.c#luaF_close()
+func (s *luaState) closeTBC(level int) {
+	ci := s.stack
+	for n := len(ci.tbc); n > 0 && ci.tbc[n-1] >= level; n = len(ci.tbc) {
+		slot := ci.tbc[n-1]
+		ci.tbc = ci.tbc[:n-1] /* remove it from list */
+		s.callCloseMethod(ci.slots[slot], nil)
+	}
+}
+
+// lua-5.4.6/src/lfunc.c#callclosemethod()
+func (s *luaState) callCloseMethod(val, err luaValue) {
+	tm := getMetafield(val, "__close", s)
+	s.stack.check(3)
+	s.stack.push(tm)  /* will call metamethod... */
+	s.stack.push(val) /* with 'self' as the 1st argument */
+	s.stack.push(err) /* and error msg. as 2nd argument */
+	s.callNoYield(2, 0)
+}
+
+// 收集即将展开的调用帧里的待关闭变量：stop之上的调用帧里的全部变量，以及stop里位置不低于level的变量，
+// 按照关闭的顺序返回，并把它们从调用帧的列表里删除
+func (s *luaState) pendingTBC(stop *luaStack, level int) []luaValue {
+	var vals []luaValue
+	for ci := s.stack; ci != nil; ci = ci.prev {
+		for n := len(ci.tbc); n > 0; n-- {
+			slot := ci.tbc[n-1]
+			if ci == stop && slot < level {
+				break
+			}
+			vals = append(vals, ci.slots[slot])
+			ci.tbc = ci.tbc[:n-1]
+		}
+		if ci == stop {
+			break
+		}
+	}
+	return vals
+}
+
+// 出错之后在保护模式下依次调用__close元方法，元方法出错时新的错误代替原来的错误，
+// 剩下的元方法照样调用。返回最终的状态码和错误对象
+// lua-5.4.6/src/ldo.c#luaD_closeprotected()
+func (s *luaState) closeProtected(vals []luaValue, status int, err luaValue) (int, luaValue) {
+	for _, val := range vals {
+		tm := getMetafield(val, "__close", s)
+		s.stack.check(3)
+		s.stack.push(tm)
+		s.stack.push(val)
+		s.stack.push(err)
+		if st := s.pcall(2, 0, nil); st != LUA_OK {
+			status, err = st, s.stack.pop()
+		}
+	}
+	return status, err
+}
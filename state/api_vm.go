@@ -42,6 +42,7 @@ func (s *luaState) LoadVararg(n int) {
 
 func (s *luaState) LoadProto(idx int) {
 	subProto := s.stack.closure.proto.Protos[idx]
+	s.allocate(closureSize + len(subProto.Upvalues)*upvalSize)
 	closure := newLuaClosure(subProto)
 	s.stack.push(closure)
 
@@ -61,6 +62,7 @@ func (s *luaState) LoadProto(idx int) {
 			closure.upvals[i] = s.stack.closure.upvals[uvIdx]
 		}
 	}
+	s.checkGC()
 }
 
 func (s *luaState) CloseUpvalues(a int) {
@@ -71,4 +73,11 @@ func (s *luaState) CloseUpvalues(a int) {
 			delete(s.stack.openuvs, i) // 处于开启状态的Upvalue引用了还在寄存器里的Lua值，我们把这些Lua值从寄存器里复制出来，然后更新Upvalue，这样就将其改为了闭合状态。
 		}
 	}
+	if len(s.stack.tbc) > 0 { // 同时关闭这些寄存器里的待关闭变量
+		s.closeTBC(a - 1)
+	}
+}
+
+func (s *luaState) RunError(fmt string, a ...interface{}) {
+	s.runError(fmt, a...)
 }
@@ -1,8 +1,12 @@
 package state
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 
 	. "luago/api"
 	"luago/stdlib"
@@ -11,15 +15,48 @@ import (
 // [-0, +0, v]
 // http://www.lua.org/manual/5.3/manual.html#luaL_error
 func (l *luaState) Error2(fmt string, a ...interface{}) int {
-	l.PushFString(fmt, a...) // todo
+	l.Where(1)
+	l.PushFString(fmt, a...)
+	l.Concat(2)
 	return l.Error()
 }
 
+// 把第level层函数当前的执行位置（"chunkname:currentline: "）推入栈顶，没有位置信息时推入空串
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_where
+func (l *luaState) Where(level int) {
+	var ar DebugInfo
+	if l.GetStack(level, &ar) { /* check function at level */
+		l.GetInfo("Sl", &ar)
+		if ar.CurrentLine > 0 { /* is there info? */
+			l.PushFString("%s:%d: ", ar.ShortSrc, ar.CurrentLine)
+			return
+		}
+	}
+	l.PushString("") /* else, no information available... */
+}
+
 // [-0, +0, v]
 // http://www.lua.org/manual/5.3/manual.html#luaL_argerror
 func (l *luaState) ArgError(arg int, extraMsg string) int {
-	// bad argument #arg to 'funcname' (extramsg)
-	return l.Error2("bad argument #%d (%s)", arg, extraMsg) // todo
+	var ar DebugInfo
+	if !l.GetStack(0, &ar) { /* no stack frame? */
+		return l.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+	l.GetInfo("n", &ar)
+	if ar.NameWhat == "method" {
+		arg--         /* do not count 'self' */
+		if arg == 0 { /* error is in the self argument itself? */
+			return l.Error2("calling '%s' on bad self (%s)", ar.Name, extraMsg)
+		}
+	}
+	if ar.Name == "" {
+		ar.Name = "?"
+		if name, ok := l.globalFuncName(&ar); ok {
+			ar.Name = name
+		}
+	}
+	return l.Error2("bad argument #%d to '%s' (%s)", arg, ar.Name, extraMsg)
 }
 
 // [-0, +0, v]
@@ -116,6 +153,32 @@ func (l *luaState) OptString(arg int, def string) string {
 	return l.CheckString(arg)
 }
 
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_testudata
+func (l *luaState) TestUData(arg int, tname string) interface{} {
+	if l.IsUserData(arg) {
+		if l.GetMetatable(arg) { /* does it have a metatable? */
+			l.GetMetatable2(tname)   /* get correct metatable */
+			ok := l.RawEqual(-1, -2) /* the same? */
+			l.Pop(2)                 /* remove both metatables */
+			if ok {
+				return l.ToUserData(arg)
+			}
+		}
+	}
+	return nil /* value is not a userdata with the given metatable */
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkudata
+func (l *luaState) CheckUData(arg int, tname string) interface{} {
+	p := l.TestUData(arg, tname)
+	if p == nil {
+		l.typeError(arg, tname)
+	}
+	return p
+}
+
 // [-0, +?, e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_dofile
 func (l *luaState) DoFile(filename string) bool {
@@ -139,9 +202,35 @@ func (l *luaState) LoadFile(filename string) int {
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#luaL_loadfilex
 func (l *luaState) LoadFileX(filename, mode string) int {
-	if data, err := ioutil.ReadFile(filename); err == nil {
-		return l.Load(data, "@"+filename, mode)
+	var data []byte
+	var err error
+	chunkName := "@" + filename
+	if filename == "" { /* stdin? */
+		chunkName = "=stdin"
+		data, err = ioutil.ReadAll(stdlib.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(filename)
 	}
+	if err != nil {
+		return l.fileError("open", chunkName, err)
+	}
+	if len(data) > 0 && data[0] == '#' { /* first line is a comment (Unix exec. file)? */
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i:] /* keep the newline to preserve line numbers */
+		} else {
+			data = nil
+		}
+	}
+	return l.Load(data, chunkName, mode)
+}
+
+// lua-5.3.4/src/lauxlib.c#errfile()
+func (l *luaState) fileError(what, chunkName string, err error) int {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	l.PushString(fmt.Sprintf("cannot %s %s: %s", what, chunkName[1:], err))
 	return LUA_ERRFILE
 }
 
@@ -212,6 +301,36 @@ func (l *luaState) ToString2(idx int) string {
 	return l.CheckString(-1)
 }
 
+// 在注册表里创建名为tname的元表（其__name字段为tname），推入栈顶；
+// 注册表里已经有这个键时，把原来的值推入栈顶并返回false
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_newmetatable
+func (l *luaState) NewMetatable(tname string) bool {
+	if l.GetMetatable2(tname) != LUA_TNIL { /* name already in use? */
+		return false /* leave previous value on top, but return false */
+	}
+	l.Pop(1)
+	l.CreateTable(0, 2) /* create metatable */
+	l.PushString(tname)
+	l.SetField(-2, "__name") /* metatable.__name = tname */
+	l.PushValue(-1)
+	l.SetField(LUA_REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_getmetatable
+func (l *luaState) GetMetatable2(tname string) LuaType {
+	return l.GetField(LUA_REGISTRYINDEX, tname)
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_setmetatable
+func (l *luaState) SetMetatable2(tname string) {
+	l.GetMetatable2(tname)
+	l.SetMetatable(-2)
+}
+
 // [-0, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_getsubtable
 func (l *luaState) GetSubTable(idx int, fname string) bool {
@@ -243,6 +362,46 @@ func (l *luaState) GetMetafield(obj int, event string) LuaType {
 	return tt /* return metafield type */
 }
 
+// 空闲引用组成链表：t[freelist]是第一个空闲的引用，t[ref]是下一个空闲的引用
+const freelist = 0
+
+// 弹出栈顶的值，把它保存在t处的表里，返回一个新的整数键。值是nil时返回LUA_REFNIL
+// [-1, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_ref
+// lua-5.3.4/src/lauxlib.c#luaL_ref()
+func (l *luaState) Ref(t int) int {
+	if l.IsNil(-1) {
+		l.Pop(1)          /* remove it from stack */
+		return LUA_REFNIL /* 'nil' has a unique fixed reference */
+	}
+	t = l.AbsIndex(t)
+	l.RawGetI(t, freelist)      /* get first free element */
+	ref := int(l.ToInteger(-1)) /* ref = t[freelist] */
+	l.Pop(1)                    /* remove it from stack */
+	if ref != 0 {               /* any free element? */
+		l.RawGetI(t, int64(ref)) /* remove it from list */
+		l.RawSetI(t, freelist)   /* (t[freelist] = t[ref]) */
+	} else { /* no free elements */
+		ref = int(l.RawLen(t)) + 1 /* get a new reference */
+	}
+	l.RawSetI(t, int64(ref))
+	return ref
+}
+
+// 释放引用ref，它指向的值可以被回收，ref可以被Ref再次使用。ref是LUA_NOREF或LUA_REFNIL时什么也不做
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_unref
+// lua-5.3.4/src/lauxlib.c#luaL_unref()
+func (l *luaState) Unref(t int, ref int) {
+	if ref >= 0 {
+		t = l.AbsIndex(t)
+		l.RawGetI(t, freelist)
+		l.RawSetI(t, int64(ref)) /* t[ref] = t[freelist] */
+		l.PushInteger(int64(ref))
+		l.RawSetI(t, freelist) /* t[freelist] = ref */
+	}
+}
+
 // [-0, +(0|1), e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_callmeta
 func (l *luaState) CallMeta(obj int, event string) bool {
@@ -256,6 +415,121 @@ func (l *luaState) CallMeta(obj int, event string) bool {
 	return true
 }
 
+const (
+	levels1 = 10 /* size of the first part of the stack */
+	levels2 = 11 /* size of the second part of the stack */
+)
+
+// 为线程l1的调用栈生成回溯信息，推入栈顶；msg不为空时加在回溯信息前面
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_traceback
+func (l *luaState) Traceback(l1 LuaState, msg string, level int) {
+	var ar DebugInfo
+	var buf strings.Builder
+	last := lastLevel(l1)
+	n1 := -1
+	if last-level > levels1+levels2 {
+		n1 = levels1
+	}
+	if msg != "" {
+		buf.WriteString(msg + "\n")
+	}
+	buf.WriteString("stack traceback:")
+	for l1.GetStack(level, &ar) {
+		level++
+		if n1 == 0 { /* too many levels? */
+			buf.WriteString("\n\t...") /* add a '...' */
+			level = last - levels2 + 1 /* and skip to last ones */
+		} else {
+			l1.GetInfo("Slnt", &ar)
+			buf.WriteString("\n\t" + ar.ShortSrc + ":")
+			if ar.CurrentLine > 0 {
+				fmt.Fprintf(&buf, "%d:", ar.CurrentLine)
+			}
+			buf.WriteString(" in " + l.funcName(&ar))
+			if ar.IsTailCall {
+				buf.WriteString("\n\t(...tail calls...)")
+			}
+		}
+		n1--
+	}
+	l.PushString(buf.String())
+}
+
+// 二分查找调用栈的深度
+// lua-5.3.4/src/lauxlib.c#lastlevel()
+func lastLevel(ls LuaState) int {
+	var ar DebugInfo
+	li, le := 1, 1
+	/* find an upper bound */
+	for ls.GetStack(le, &ar) {
+		li = le
+		le *= 2
+	}
+	/* do a binary search */
+	for li < le {
+		m := (li + le) / 2
+		if ls.GetStack(m, &ar) {
+			li = m + 1
+		} else {
+			le = m
+		}
+	}
+	return le - 1
+}
+
+// lua-5.3.4/src/lauxlib.c#pushfuncname()
+func (l *luaState) funcName(ar *DebugInfo) string {
+	if name, ok := l.globalFuncName(ar); ok { /* try first a global name */
+		return "function '" + name + "'"
+	} else if ar.NameWhat != "" { /* is there a name from code? */
+		return ar.NameWhat + " '" + ar.Name + "'" /* use it */
+	} else if ar.What == "main" { /* main? */
+		return "main chunk"
+	} else if ar.What != "C" { /* for Lua functions, use <file:line> */
+		return fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined)
+	}
+	return "?" /* nothing left... */
+}
+
+// 在package.loaded里查找函数，返回它的全局名字
+// lua-5.3.4/src/lauxlib.c#pushglobalfuncname()
+func (l *luaState) globalFuncName(ar *DebugInfo) (string, bool) {
+	top := l.GetTop()
+	defer l.SetTop(top) /* remove function and global table */
+	l.GetInfo("f", ar)  /* push function */
+	l.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	if !l.findField(top+1, 2) {
+		return "", false
+	}
+	return strings.TrimPrefix(l.ToString(-1), "_G."), true
+}
+
+// 在栈顶的表里（递归level层）查找objIdx处的值，找到时把它的名字推入栈顶
+// lua-5.3.4/src/lauxlib.c#findfield()
+func (l *luaState) findField(objIdx, level int) bool {
+	if level == 0 || !l.IsTable(-1) {
+		return false /* not found */
+	}
+	l.PushNil()      /* start 'next' loop */
+	for l.Next(-2) { /* for each pair in table */
+		if l.Type(-2) == LUA_TSTRING { /* ignore non-string keys */
+			if l.RawEqual(objIdx, -1) { /* found object? */
+				l.Pop(1) /* remove value (but keep name) */
+				return true
+			} else if l.findField(objIdx, level-1) { /* try recursively */
+				l.Remove(-2) /* remove table (but keep name) */
+				l.PushString(".")
+				l.Insert(-2) /* place '.' between the two names */
+				l.Concat(3)
+				return true
+			}
+		}
+		l.Pop(1) /* remove value */
+	}
+	return false /* not found */
+}
+
 // [-0, +0, e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_openlibs
 func (l *luaState) OpenLibs() {
@@ -266,8 +540,10 @@ func (l *luaState) OpenLibs() {
 		"string":    stdlib.OpenStringLib,
 		"utf8":      stdlib.OpenUTF8Lib,
 		"os":        stdlib.OpenOSLib,
+		"io":        stdlib.OpenIOLib,
 		"package":   stdlib.OpenPackageLib,
 		"coroutine": stdlib.OpenCoroutineLib,
+		"debug":     stdlib.OpenDebugLib,
 	}
 
 	for name, fun := range libs {
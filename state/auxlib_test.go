@@ -0,0 +1,69 @@
+package state
+
+import (
+	"testing"
+
+	. "luago/api"
+)
+
+// 释放的引用按照后进先出的顺序被再次使用，RawGetI用引用取回值
+func TestRef(t *testing.T) {
+	ls := New()
+	ls.NewTable()
+	refs := make([]int, 3)
+	for i := range refs {
+		ls.PushString(string(rune('a' + i)))
+		refs[i] = ls.Ref(1)
+	}
+	if refs[0] != 1 || refs[1] != 2 || refs[2] != 3 {
+		t.Fatalf("refs = %v", refs)
+	}
+	if ls.RawGetI(1, int64(refs[1])) != LUA_TSTRING || ls.ToString(-1) != "b" {
+		t.Errorf("t[%d] = %s", refs[1], ls.ToString(-1))
+	}
+	ls.Pop(1)
+
+	ls.Unref(1, refs[0])
+	ls.Unref(1, refs[2])
+	ls.PushString("x")
+	if ref := ls.Ref(1); ref != refs[2] {
+		t.Errorf("ref = %d, want %d", ref, refs[2])
+	}
+	ls.PushString("y")
+	if ref := ls.Ref(1); ref != refs[0] {
+		t.Errorf("ref = %d, want %d", ref, refs[0])
+	}
+	ls.PushString("z")
+	if ref := ls.Ref(1); ref != 4 {
+		t.Errorf("ref = %d, want 4", ref)
+	}
+	ls.RawGetI(1, int64(refs[0]))
+	if ls.ToString(-1) != "y" {
+		t.Errorf("t[%d] = %s", refs[0], ls.ToString(-1))
+	}
+	ls.Pop(1)
+
+	ls.PushNil()
+	if ref := ls.Ref(1); ref != LUA_REFNIL || ls.GetTop() != 1 {
+		t.Errorf("ref = %d, top = %d", ref, ls.GetTop())
+	}
+	ls.Unref(1, LUA_REFNIL)
+	ls.Unref(1, LUA_NOREF)
+	ls.RawGetI(1, 0)
+	if ls.ToInteger(-1) != 0 {
+		t.Errorf("free list = %s", ls.ToString(-1))
+	}
+	ls.SetTop(0)
+
+	// 注册表里的引用不会覆盖预定义的键
+	ls.PushString("r")
+	ref := ls.Ref(LUA_REGISTRYINDEX)
+	if ref <= int(LUA_RIDX_GLOBALS) {
+		t.Errorf("registry ref = %d", ref)
+	}
+	ls.RawGetI(LUA_REGISTRYINDEX, int64(ref))
+	if ls.ToString(-1) != "r" {
+		t.Errorf("registry[%d] = %s", ref, ls.ToString(-1))
+	}
+	ls.Unref(LUA_REGISTRYINDEX, ref)
+}
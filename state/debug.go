@@ -0,0 +1,436 @@
+package state
+
+import (
+	"fmt"
+	. "luago/api"
+	"luago/binchunk"
+	"luago/vm"
+	"math"
+	"reflect"
+)
+
+// 根据指令索引查找行号，没有行号信息时返回-1
+// lua-5.3.4/src/ldebug.h#getfuncline()
+func getFuncLine(proto *binchunk.Prototype, pc int) int {
+	if pc >= 0 && pc < len(proto.LineInfo) {
+		return int(proto.LineInfo[pc])
+	}
+	return -1
+}
+
+// 正在执行的指令的索引（pc指向的是下一条指令）
+// lua-5.3.4/src/ldebug.c#currentpc()
+func currentPC(frame *luaStack) int {
+	if frame.pc > 0 {
+		return frame.pc - 1
+	}
+	return 0
+}
+
+// lua-5.3.4/src/ldebug.c#currentline()
+func currentLine(frame *luaStack) int {
+	return getFuncLine(frame.closure.proto, currentPC(frame))
+}
+
+// 返回第n个（从1开始）在pc处活跃的局部变量的名字，找不到时返回空串
+// lua-5.3.4/src/lfunc.c#luaF_getlocalname()
+func localName(proto *binchunk.Prototype, n, pc int) string {
+	for _, locVar := range proto.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { /* is variable active? */
+			n--
+			if n == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return "" /* not found */
+}
+
+// lua-5.3.4/src/ldebug.c#upvalname()
+func upvalName(proto *binchunk.Prototype, uv int) string {
+	if uv < len(proto.UpvalueNames) {
+		return proto.UpvalueNames[uv]
+	}
+	return "?"
+}
+
+func isLuaFrame(frame *luaStack) bool {
+	return frame.closure != nil && frame.closure.proto != nil
+}
+
+// 返回调用帧对应函数的名字，不知道时返回两个空串
+// lua-5.3.4/src/ldebug.c#getfuncname()
+func getFuncName(frame *luaStack) (name, nameWhat string) {
+	if frame != nil && !frame.tailcall && frame.prev != nil && isLuaFrame(frame.prev) {
+		return funcNameFromCode(frame.prev)
+	}
+	return "", ""
+}
+
+// 根据主调函数正在执行的指令推断被调函数的名字
+// lua-5.3.4/src/ldebug.c#funcnamefromcode()
+func funcNameFromCode(caller *luaStack) (name, nameWhat string) {
+	if caller.hooked { /* was it called inside a hook? */
+		return "?", "hook"
+	}
+	proto := caller.closure.proto
+	pc := currentPC(caller)
+	i := vm.Instruction(proto.Code[pc])
+	var tm string
+	switch op := i.Opcode(); op {
+	case vm.OP_CALL, vm.OP_TAILCALL: /* get function name */
+		a, _, _ := i.ABC()
+		return getObjName(proto, pc, a)
+	case vm.OP_TFORCALL: /* for iterator */
+		return "for iterator", "for iterator"
+	/* all other instructions can call only through metamethods */
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE:
+		tm = "index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE:
+		tm = "newindex"
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD,
+		vm.OP_POW, vm.OP_DIV, vm.OP_IDIV, vm.OP_BAND,
+		vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		tm = arithEvents[op-vm.OP_ADD]
+	case vm.OP_UNM:
+		tm = "unm"
+	case vm.OP_BNOT:
+		tm = "bnot"
+	case vm.OP_LEN:
+		tm = "len"
+	case vm.OP_CONCAT:
+		tm = "concat"
+	case vm.OP_EQ:
+		tm = "eq"
+	case vm.OP_LT:
+		tm = "lt"
+	case vm.OP_LE:
+		tm = "le"
+	default:
+		return "", "" /* other instructions cannot call a function */
+	}
+	return tm, "metamethod"
+}
+
+// 和OP_ADD到OP_SHR的顺序一致
+var arithEvents = []string{
+	"add", "sub", "mul", "mod", "pow", "div",
+	"idiv", "band", "bor", "bxor", "shl", "shr",
+}
+
+// 通过符号执行推断寄存器reg在lastPC处保存的是什么变量
+// lua-5.3.4/src/ldebug.c#getobjname()
+func getObjName(proto *binchunk.Prototype, lastPC, reg int) (name, nameWhat string) {
+	if name = localName(proto, reg+1, lastPC); name != "" { /* is a local? */
+		return name, "local"
+	}
+	/* else try symbolic execution */
+	pc := findSetReg(proto, lastPC, reg)
+	if pc == -1 { /* could not find instruction? */
+		return "", ""
+	}
+	i := vm.Instruction(proto.Code[pc])
+	switch op := i.Opcode(); op {
+	case vm.OP_MOVE:
+		a, b, _ := i.ABC()
+		if b < a { /* move from 'b' to 'a' */
+			return getObjName(proto, pc, b) /* get name for 'b' */
+		}
+	case vm.OP_GETTABUP, vm.OP_GETTABLE:
+		_, t, k := i.ABC() /* table index and key index */
+		var vn string      /* name of indexed variable */
+		if op == vm.OP_GETTABLE {
+			vn = localName(proto, t+1, pc)
+		} else {
+			vn = upvalName(proto, t)
+		}
+		name = kname(proto, pc, k)
+		if vn == "_ENV" {
+			return name, "global"
+		}
+		return name, "field"
+	case vm.OP_GETUPVAL:
+		_, b, _ := i.ABC()
+		return upvalName(proto, b), "upvalue"
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		var b int
+		if op == vm.OP_LOADK {
+			_, b = i.ABx()
+		} else {
+			b = vm.Instruction(proto.Code[pc+1]).Ax()
+		}
+		if s, ok := proto.Constants[b].(string); ok {
+			return s, "constant"
+		}
+	case vm.OP_SELF:
+		_, _, k := i.ABC() /* key index */
+		return kname(proto, pc, k), "method"
+	}
+	return "", "" /* could not find reasonable name */
+}
+
+// 返回RK(c)的名字
+// lua-5.3.4/src/ldebug.c#kname()
+func kname(proto *binchunk.Prototype, pc, c int) string {
+	if c > 0xFF { /* is 'c' a constant? */
+		if s, ok := proto.Constants[c&0xFF].(string); ok { /* literal constant? */
+			return s /* it is its own name */
+		}
+	} else { /* 'c' is a register */
+		if name, what := getObjName(proto, pc, c); what == "constant" { /* found a constant name? */
+			return name
+		}
+	}
+	return "?" /* no reasonable name found */
+}
+
+// 找到最后一条修改寄存器reg的指令，找不到（或者修改发生在条件分支里）时返回-1
+// lua-5.3.4/src/ldebug.c#findsetreg()
+func findSetReg(proto *binchunk.Prototype, lastPC, reg int) int {
+	setReg := -1   /* keep last instruction that changed 'reg' */
+	jmpTarget := 0 /* any code before this address is conditional */
+	filterPC := func(pc int) int {
+		if pc < jmpTarget { /* is code conditional (inside a jump)? */
+			return -1 /* cannot know who sets that register */
+		}
+		return pc /* current position sets that register */
+	}
+	for pc := 0; pc < lastPC; pc++ {
+		i := vm.Instruction(proto.Code[pc])
+		a, b, _ := i.ABC()
+		switch i.Opcode() {
+		case vm.OP_LOADNIL:
+			if a <= reg && reg <= a+b { /* set registers from 'a' to 'a+b' */
+				setReg = filterPC(pc)
+			}
+		case vm.OP_TFORCALL:
+			if reg >= a+2 { /* affect all regs above its base */
+				setReg = filterPC(pc)
+			}
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			if reg >= a { /* affect all registers above base */
+				setReg = filterPC(pc)
+			}
+		case vm.OP_JMP:
+			_, sBx := i.AsBx()
+			dest := pc + 1 + sBx
+			/* jump is forward and do not skip 'lastpc'? */
+			if pc < dest && dest <= lastPC && dest > jmpTarget {
+				jmpTarget = dest /* update 'jmptarget' */
+			}
+		default:
+			if i.TestAMode() && reg == a { /* any instruction that set A */
+				setReg = filterPC(pc)
+			}
+		}
+	}
+	return setReg
+}
+
+// 调用钩子函数，钩子函数运行期间不会再触发钩子
+// lua-5.3.4/src/ldo.c#luaD_hook()
+func (s *luaState) runHook(event, line int) {
+	if s.hook == nil || s.inHook {
+		return
+	}
+	frame := s.stack
+	top := frame.top
+	frame.check(LUA_MINSTACK) /* ensure minimum stack size */
+	s.inHook = true           /* cannot call hooks inside a hook */
+	s.nny++                   /* 钩子函数不能让出 */
+	frame.hooked = true
+	defer func() {
+		s.inHook = false
+		s.nny--
+		frame.hooked = false
+	}()
+	s.hook(s, &DebugInfo{Event: event, CurrentLine: line, CallInfo: frame})
+	for frame.top > top { /* restore the stack */
+		frame.pop()
+	}
+}
+
+// 执行Lua函数的每条指令之前调用，按需触发行钩子和计数钩子
+// lua-5.3.4/src/ldebug.c#luaG_traceexec()
+func (s *luaState) traceExec() {
+	frame := s.stack
+	npc := frame.pc
+	countHook := false
+	if s.hookMask&LUA_MASKCOUNT != 0 {
+		if s.hookCount--; s.hookCount == 0 {
+			s.hookCount = s.baseHookCount /* reset count */
+			countHook = true
+		}
+	}
+	frame.pc++ /* reference is always next instruction */
+	if countHook {
+		s.runHook(LUA_HOOKCOUNT, -1) /* call count hook */
+	}
+	if s.hookMask&LUA_MASKLINE != 0 {
+		proto := frame.closure.proto
+		newLine := getFuncLine(proto, npc)
+		if npc == 0 || /* call linehook when enter a new function, */
+			npc <= s.oldPC || /* when jump back (loop), or when */
+			newLine != getFuncLine(proto, s.oldPC) { /* enter a new line */
+			s.runHook(LUA_HOOKLINE, newLine) /* call line hook */
+		}
+	}
+	frame.pc--
+	s.oldPC = npc
+}
+
+// 抛出运行时错误，当前函数是Lua函数时在错误信息前面加上源文件名和行号
+// lua-5.3.4/src/ldebug.c#luaG_runerror()
+func (s *luaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if frame := s.stack; isLuaFrame(frame) { /* if Lua function, add source:line information */
+		msg = addInfo(msg, frame.closure.proto.Source, currentLine(frame))
+	}
+	panic(msg)
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_addinfo()
+func addInfo(msg, src string, line int) string {
+	if src == "" { /* no debug information? */
+		return fmt.Sprintf("?:%d: %s", line, msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", binchunk.ChunkID(src), line, msg)
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_typeerror()
+func (s *luaState) opError(val luaValue, op string) {
+	s.runError("attempt to %s a %s value%s", op, s.objTypeName(val), s.varInfo(val))
+}
+
+// 拼接出错时，两个操作数里不是字符串（或数字）的那个才是罪魁祸首
+// lua-5.3.4/src/ldebug.c#luaG_concaterror()
+func (s *luaState) concatError(a, b luaValue) {
+	switch a.(type) {
+	case string, int64, float64:
+		a = b
+	}
+	s.opError(a, "concatenate")
+}
+
+// 算术运算出错时，两个操作数里不能转换成数字的那个才是罪魁祸首
+// lua-5.3.4/src/ldebug.c#luaG_opinterror()
+func (s *luaState) opIntError(a, b luaValue, msg string) {
+	if _, ok := convertToFloat(a); !ok { /* first operand is wrong? */
+		b = a /* now second is wrong too */
+	}
+	s.opError(b, msg)
+}
+
+// 位运算的操作数是没有整数表示的数字
+// lua-5.3.4/src/ldebug.c#luaG_tointerror()
+func (s *luaState) toIntError(a, b luaValue) {
+	if _, ok := convertToInteger(a); !ok {
+		b = a
+	}
+	s.runError("number%s has no integer representation", s.varInfo(b))
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_ordererror()
+func (s *luaState) orderError(a, b luaValue) {
+	t1, t2 := s.objTypeName(a), s.objTypeName(b)
+	if t1 == t2 {
+		s.runError("attempt to compare two %s values", t1)
+	}
+	s.runError("attempt to compare %s with %s", t1, t2)
+}
+
+// 表和完全用户数据的类型名可以通过元表的__name字段定制
+// lua-5.3.4/src/ltm.c#luaT_objtypename()
+func (s *luaState) objTypeName(val luaValue) string {
+	switch val.(type) {
+	case *luaTable, *userdata:
+		if name, ok := getMetafield(val, "__name", s).(string); ok {
+			return name
+		}
+	}
+	return s.TypeName(typeOf(val))
+}
+
+// 判断出错的值是不是从这个寄存器（或者upvalue）里取出来的，相当于C语言比较TValue的地址。
+// 不能直接用==：NaN和自己不相等，包装着切片或者映射的轻量用户数据比较时会panic
+func sameValue(a, b luaValue) bool {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		return ok && math.Float64bits(x) == math.Float64bits(y)
+	case lightUserdata:
+		y, ok := b.(lightUserdata)
+		return ok && sameGoValue(x.p, y.p)
+	}
+	return a == b
+}
+
+// 不可比较的切片、映射和函数比较它们引用的地址
+func sameGoValue(p, q interface{}) bool {
+	if p == nil || q == nil {
+		return p == q
+	}
+	v, w := reflect.ValueOf(p), reflect.ValueOf(q)
+	if v.Type() != w.Type() {
+		return false
+	}
+	if v.Comparable() && w.Comparable() {
+		return p == q
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		return v.Pointer() == w.Pointer() && v.Len() == w.Len()
+	case reflect.Map, reflect.Func:
+		return v.Pointer() == w.Pointer()
+	}
+	return false
+}
+
+// 如果出错的值就是当前指令的某个操作数，根据这个操作数推断变量名，返回像" (local 'a')"这样的说明
+// lua-5.3.4/src/ldebug.c#varinfo()
+func (s *luaState) varInfo(val luaValue) string {
+	frame := s.stack
+	if !isLuaFrame(frame) {
+		return ""
+	}
+	proto := frame.closure.proto
+	pc := currentPC(frame)
+	i := vm.Instruction(proto.Code[pc])
+	a, b, c := i.ABC()
+	var regs []int /* 可能保存着出错值的寄存器（或者RK常量） */
+	switch op := i.Opcode(); op {
+	case vm.OP_GETTABUP, vm.OP_SETTABUP: /* 被索引的表是upvalue */
+		uv := b
+		if op == vm.OP_SETTABUP {
+			uv = a
+		}
+		if uv < len(frame.closure.upvals) && sameValue(*frame.closure.upvals[uv].val, val) {
+			return fmt.Sprintf(" (upvalue '%s')", upvalName(proto, uv))
+		}
+		return ""
+	case vm.OP_GETTABLE, vm.OP_SELF, vm.OP_UNM, vm.OP_BNOT, vm.OP_LEN:
+		regs = []int{b}
+	case vm.OP_SETTABLE, vm.OP_CALL, vm.OP_TAILCALL:
+		regs = []int{a}
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD,
+		vm.OP_POW, vm.OP_DIV, vm.OP_IDIV, vm.OP_BAND,
+		vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		regs = []int{b, c}
+	case vm.OP_CONCAT:
+		for reg := b; reg <= c; reg++ {
+			regs = append(regs, reg)
+		}
+	}
+	for _, reg := range regs {
+		if reg <= 0xFF && sameValue(frame.slots[reg], val) {
+			if name, kind := getObjName(proto, pc, reg); kind != "" {
+				return fmt.Sprintf(" (%s '%s')", kind, name)
+			}
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,342 @@
+package state
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+
+	. "luago/api"
+	"luago/binchunk"
+)
+
+// 内存由Go的垃圾回收器管理，但是Go看不到Lua的弱表和终结器语义：只要弱表还引用着某个对象，它就不会被回收；
+// Go的终结器在单独的goroutine里执行，而且对象之间有环时根本不会执行。
+// 所以这里实现了Lua垃圾回收器的标记阶段：从注册表和当前线程出发标记所有可达的对象，
+// 把弱表里引用了不可达对象的条目删掉，把不可达的、带终结器的对象放进队列，
+// 然后在解释器自己的线程里调用它们的__gc元方法，剩下的工作交给Go。
+// 注意只保存在Go变量里（不在任何栈、注册表或者可达的对象里）的值对标记阶段是不可见的。
+// 标记的同时计算可达对象的大小，作为内存用量（见memory.go）
+// lua-5.3.4/src/lgc.c
+
+const (
+	gcMinDebt        = 1 << 18 // 两次自动回收之间至少分配的内存（字节）
+	gcDefaultPause   = 200     // lua-5.3.4/src/luaconf.h#LUAI_GCPAUSE
+	gcDefaultStepMul = 200     // lua-5.3.4/src/luaconf.h#LUAI_GCMUL
+)
+
+type gcState struct {
+	marked     map[luaValue]bool
+	gray       []luaValue                   // 已经标记、但是还没有遍历的对象
+	ephemerons []*luaTable                  // 弱键表：键可达时值才可达
+	weakValues []*luaTable                  // 弱值表
+	allWeak    []*luaTable                  // 键和值都是弱引用的表
+	strings    map[*byte]bool               // 已经计算过的长字符串
+	protos     map[*binchunk.Prototype]bool // 已经计算过的函数原型
+	bytes      int                          // 已经标记的对象的大小
+}
+
+// 从注册表、当前线程和等待终结的对象出发标记所有可达的对象
+func (s *luaState) mark() *gcState {
+	g := &gcState{
+		marked:  make(map[luaValue]bool),
+		strings: make(map[*byte]bool),
+		protos:  make(map[*binchunk.Prototype]bool),
+	}
+	/* mark root set */
+	g.markValue(s.registry)
+	g.markValue(s)
+	for _, o := range s.g.tobefnz { /* mark any finalizing object left from previous cycle */
+		g.markValue(o)
+	}
+	g.propagateAll()
+	g.convergeEphemerons()
+	return g
+}
+
+// 执行一次完整的标记和清除，重新计算内存用量。不可达的、带终结器的对象放进g.tobefnz
+// lua-5.3.4/src/lgc.c#atomic()
+func (s *luaState) fullGC() {
+	g := s.mark()
+	/* at this point, all strongly accessible objects are marked. */
+	/* Clear values from weak tables, before checking finalizers */
+	origWeak, origAll := len(g.weakValues), len(g.allWeak)
+	for _, t := range g.weakValues {
+		g.clearByValues(t)
+	}
+	for _, t := range g.allWeak {
+		g.clearByValues(t)
+	}
+	/* separate objects to be finalized and mark them (resurrect) */
+	for _, o := range s.separateToBeFnz(g) {
+		g.markValue(o)
+	}
+	g.propagateAll()
+	g.convergeEphemerons()
+	/* at this point, all resurrected objects are marked. */
+	/* remove dead objects from weak tables */
+	for _, t := range g.ephemerons {
+		g.clearByKeys(t)
+	}
+	for _, t := range g.allWeak {
+		g.clearByKeys(t)
+	}
+	/* clear values from resurrected weak tables */
+	for _, t := range g.weakValues[origWeak:] {
+		g.clearByValues(t)
+	}
+	for _, t := range g.allWeak[origAll:] {
+		g.clearByValues(t)
+	}
+	s.g.totalBytes, s.g.gcEstimate = g.bytes, g.bytes
+}
+
+// 在创建新对象的地方调用：每分配一定数量的内存就自动执行一次标记阶段，
+// 间隔由上一次回收之后的内存用量和gcPause决定
+// lua-5.3.4/src/lgc.h#luaC_checkGC()
+func (s *luaState) checkGC() {
+	g := s.g
+	if g.gcDebt > 0 || !g.gcRunning {
+		return
+	}
+	s.fullGC()
+	s.setPause()
+	s.callAllPendingFinalizers()
+}
+
+// 执行一次完整的回收：先是Lua的标记阶段，然后是Go的垃圾回收，最后调用终结器
+// lua-5.3.4/src/lgc.c#luaC_fullgc()
+func (s *luaState) fullCollect() {
+	s.fullGC()
+	runtime.GC()
+	s.setPause()
+	s.callAllPendingFinalizers()
+}
+
+// 内存用量增长到gcEstimate*gcPause/100时开始下一次回收
+// lua-5.3.4/src/lgc.c#setpause()
+func (s *luaState) setPause() {
+	g := s.g
+	g.gcDebt = g.gcEstimate / 100 * (g.gcPause - 100)
+	if g.gcDebt < gcMinDebt {
+		g.gcDebt = gcMinDebt
+	}
+}
+
+// 元表带有__gc字段时，把对象注册为需要终结的对象。只有表和完全用户数据才有终结器
+// lua-5.3.4/src/lgc.c#luaC_checkfinalizer()
+func (s *luaState) checkFinalizer(val luaValue) {
+	switch val.(type) {
+	case *luaTable, *userdata:
+		if _, ok := s.g.finobj[val]; !ok { /* not already marked */
+			s.g.finobj[val] = s.g.finSeq
+			s.g.finSeq++
+		}
+	}
+}
+
+// 把没有被标记的、需要终结的对象从finobj移到tobefnz，并返回这些对象。
+// 终结器按照注册顺序的逆序调用
+// lua-5.3.4/src/lgc.c#separatetobefnz()
+func (s *luaState) separateToBeFnz(g *gcState) []luaValue {
+	var dead []luaValue
+	for o := range s.g.finobj {
+		if !g.marked[o] {
+			dead = append(dead, o)
+		}
+	}
+	sort.Slice(dead, func(i, j int) bool {
+		return s.g.finobj[dead[i]] > s.g.finobj[dead[j]]
+	})
+	for _, o := range dead {
+		delete(s.g.finobj, o)
+	}
+	s.g.tobefnz = append(s.g.tobefnz, dead...)
+	return dead
+}
+
+// lua-5.3.4/src/lgc.c#callallpendingfinalizers()
+func (s *luaState) callAllPendingFinalizers() {
+	for len(s.g.tobefnz) > 0 && s.g.gcRunning {
+		o := s.g.tobefnz[0]
+		s.g.tobefnz = s.g.tobefnz[1:]
+		s.gcTM(o)
+	}
+}
+
+// 调用对象的__gc元方法。执行期间不触发钩子，也不自动回收（所以终结器不会嵌套执行），
+// 终结器里的错误被忽略（Lua 5.4默认关闭警告时的行为）
+// lua-5.3.4/src/lgc.c#GCTM()
+func (s *luaState) gcTM(o luaValue) {
+	tm := getMetafield(o, "__gc", s)
+	if tm == nil { /* no finalizer? */
+		return
+	}
+	oldInHook, oldRunning := s.inHook, s.g.gcRunning
+	s.inHook = true       /* stop debug hooks during GC metamethod */
+	s.g.gcRunning = false /* avoid GC steps */
+	s.stack.check(2)
+	s.stack.push(tm) /* push finalizer... */
+	s.stack.push(o)  /* ... and its argument */
+	if s.pcall(1, 0, nil) != LUA_OK {
+		s.stack.pop() /* 忽略错误对象 */
+	}
+	s.inHook, s.g.gcRunning = oldInHook, oldRunning /* restore state */
+}
+
+// 只有表、函数、线程和完全用户数据需要标记；字符串和数值不会从弱表里清除
+// lua-5.3.4/src/lgc.c#iscleared()
+func isCollectable(val luaValue) bool {
+	switch val.(type) {
+	case *luaTable, *closure, *luaState, *userdata:
+		return true
+	}
+	return false
+}
+
+func (g *gcState) markValue(val luaValue) {
+	if isCollectable(val) {
+		if !g.marked[val] {
+			g.marked[val] = true
+			g.gray = append(g.gray, val)
+		}
+	} else if str, ok := val.(string); ok {
+		g.markString(str)
+	}
+}
+
+func (g *gcState) markTable(t *luaTable) {
+	if t != nil {
+		g.markValue(t)
+	}
+}
+
+// lua-5.3.4/src/lgc.c#propagateall()
+func (g *gcState) propagateAll() {
+	for len(g.gray) > 0 {
+		val := g.gray[len(g.gray)-1]
+		g.gray = g.gray[:len(g.gray)-1]
+		g.bytes += sizeOf(val)
+		switch x := val.(type) {
+		case *luaTable:
+			g.traverseTable(x)
+		case *closure:
+			if x.proto != nil && !g.protos[x.proto] {
+				g.protos[x.proto] = true
+				g.bytes += protoBytes(x.proto)
+			}
+			for _, uv := range x.upvals {
+				if uv != nil {
+					g.markValue(*uv.val)
+				}
+			}
+		case *luaState:
+			g.traverseThread(x)
+		case *userdata:
+			g.markTable(x.metatable)
+			g.markValue(x.uservalue)
+		}
+	}
+}
+
+// lua-5.3.4/src/lgc.c#traversetable()
+func (g *gcState) traverseTable(t *luaTable) {
+	g.markTable(t.metatable)
+	weakKey, weakValue := t.weakMode()
+	switch {
+	case weakKey && weakValue: /* nothing to traverse now */
+		g.allWeak = append(g.allWeak, t)
+	case weakKey: /* 数组部分的键是整数，值总是可达的 */
+		for _, v := range t.arr {
+			g.markValue(v)
+		}
+		g.ephemerons = append(g.ephemerons, t)
+	case weakValue:
+		for k := range t._map {
+			g.markValue(k)
+		}
+		g.weakValues = append(g.weakValues, t)
+	default: /* strong table */
+		for _, v := range t.arr {
+			g.markValue(v)
+		}
+		for k, v := range t._map {
+			g.markValue(k)
+			g.markValue(v)
+		}
+	}
+}
+
+// lua-5.3.4/src/lgc.c#traversethread()
+func (g *gcState) traverseThread(ls *luaState) {
+	g.markTable(ls.registry)
+	for frame := ls.stack; frame != nil; frame = frame.prev {
+		if frame.closure != nil {
+			g.markValue(frame.closure)
+		}
+		for _, v := range frame.slots {
+			g.markValue(v)
+		}
+		for _, v := range frame.varargs {
+			g.markValue(v)
+		}
+		for _, v := range frame.saved {
+			g.markValue(v)
+		}
+		g.markValue(frame.errFunc)
+	}
+}
+
+// 反复遍历弱键表，键已经被标记的条目，把值也标记上，直到没有新的对象被标记
+// lua-5.3.4/src/lgc.c#convergeephemerons()
+func (g *gcState) convergeEphemerons() {
+	for changed := true; changed; {
+		changed = false
+		for _, t := range g.ephemerons {
+			for k, v := range t._map {
+				if g.isCleared(k) || !isCollectable(v) || g.marked[v] {
+					continue
+				}
+				g.markValue(v)
+				g.propagateAll()
+				changed = true
+			}
+		}
+	}
+}
+
+func (g *gcState) isCleared(val luaValue) bool {
+	return isCollectable(val) && !g.marked[val]
+}
+
+// lua-5.3.4/src/lgc.c#clearkeys()
+func (g *gcState) clearByKeys(t *luaTable) {
+	for k := range t._map {
+		if g.isCleared(k) {
+			t.put(k, nil)
+		}
+	}
+}
+
+// lua-5.3.4/src/lgc.c#clearvalues()
+func (g *gcState) clearByValues(t *luaTable) {
+	for i := len(t.arr); i >= 1; i-- {
+		if g.isCleared(t.arr[i-1]) {
+			t.put(int64(i), nil)
+		}
+	}
+	for k, v := range t._map {
+		if g.isCleared(v) {
+			t.put(k, nil)
+		}
+	}
+}
+
+// 元表的__mode字段包含'k'表示弱键，包含'v'表示弱值
+func (t *luaTable) weakMode() (weakKey, weakValue bool) {
+	if t.metatable != nil {
+		if mode, ok := t.metatable.get("__mode").(string); ok {
+			return strings.ContainsRune(mode, 'k'), strings.ContainsRune(mode, 'v')
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,140 @@
+package state
+
+import (
+	"context"
+
+	. "luago/api"
+)
+
+// 执行限制：宿主程序可以给脚本设置上下文、指令数量和调用层数的上限，触发时中断脚本，
+// 保护调用返回LUA_ERRINTERRUPT。中断默认不能被脚本捕获：除了宿主程序在线程的最外层发起的保护调用，
+// 其他保护调用（pcall、coroutine.resume等）展开调用栈之后把中断继续抛出；
+// 设置为可以捕获时，上下文或者指令数量触发的中断和普通错误一样，之后脚本还有interruptGrace条指令的宽限期
+// 用来做清理，宽限期用完时再次中断，这一次不能被捕获。所有线程共享同一组限制
+
+const (
+	ctxCheckInterval = 1000  // 每执行多少条指令检查一次上下文
+	interruptGrace   = 10000 // 可以捕获的中断触发之后，脚本还可以执行的指令数量
+)
+
+// 触发执行限制时抛出的错误
+type interruptSignal struct {
+	msg string
+}
+
+// 更换上下文时只清除上下文触发的宽限期，指令数量触发的宽限期不受影响，反之亦然
+// [-0, +0, –]
+func (s *luaState) SetContext(ctx context.Context) {
+	g := s.g
+	g.ctx = ctx
+	g.ctxCheck = 1 /* 下一条指令就检查 */
+	g.limited = g.ctx != nil || g.insnLimit
+	if g.graceCtx {
+		g.graceLeft, g.graceOver = 0, false
+	}
+}
+
+// [-0, +0, –]
+func (s *luaState) Context() context.Context {
+	return s.g.ctx
+}
+
+// [-0, +0, –]
+func (s *luaState) SetInstructionLimit(n int64) {
+	g := s.g
+	g.insnLeft, g.insnLimit = n, n > 0
+	g.limited = g.ctx != nil || g.insnLimit
+	if !g.graceCtx {
+		g.graceLeft, g.graceOver = 0, false
+	}
+}
+
+// [-0, +0, –]
+func (s *luaState) SetCallDepthLimit(n int) {
+	g := s.g
+	g.callLimit, g.maxCalls = n, LUAI_MAXCCALLS
+	if n > 0 && n < LUAI_MAXCCALLS {
+		g.maxCalls = n
+	}
+}
+
+// [-0, +0, –]
+func (s *luaState) SetInterruptCatchable(catchable bool) {
+	s.g.catchable = catchable
+}
+
+// 执行每条指令之前调用，检查上下文和剩下的指令数量
+func (s *luaState) checkLimits() {
+	g := s.g
+	if g.graceLeft > 0 { /* 宽限期内只倒数 */
+		if g.graceLeft--; g.graceLeft == 0 {
+			g.graceOver = true
+			s.interrupt(g.graceMsg)
+		}
+		return
+	}
+	if g.insnLimit {
+		if g.insnLeft == 0 {
+			s.trip("instruction limit exceeded", false)
+		}
+		g.insnLeft--
+	}
+	if g.ctx != nil {
+		if g.ctxCheck--; g.ctxCheck > 0 {
+			return
+		}
+		g.ctxCheck = ctxCheckInterval
+		if err := g.ctx.Err(); err != nil {
+			s.trip(err.Error(), true)
+		}
+	}
+}
+
+// 上下文或者指令数量触发中断。可以捕获、而且还没有用过宽限期时，开始宽限期
+func (s *luaState) trip(msg string, byCtx bool) {
+	g := s.g
+	if g.catchable && !g.graceOver {
+		g.graceLeft, g.graceMsg, g.graceCtx = interruptGrace, msg, byCtx
+	}
+	s.interrupt(msg)
+}
+
+// 调用层数达到maxCalls时调用。调用层数达到上限时中断脚本；超过LUAI_MAXCCALLS时报告stack overflow，
+// 处理这个错误（调用消息处理函数和__close元方法）还可以再使用八分之一的层数
+// lua-5.3.4/src/ldo.c#luaD_call()
+func (s *luaState) checkCalls() {
+	if s.g.callLimit > 0 && s.nCcalls >= s.g.callLimit { /* 恢复协程时层数可能跳过上限 */
+		s.interrupt("call depth limit exceeded")
+	}
+	if s.nCcalls == LUAI_MAXCCALLS {
+		s.runError("stack overflow")
+	} else if s.nCcalls >= LUAI_MAXCCALLS+LUAI_MAXCCALLS>>3 {
+		panic("error in error handling") /* error while handing stack error */
+	}
+}
+
+// 抛出中断，和runError一样，当前函数是Lua函数时在错误信息前面加上源文件名和行号
+func (s *luaState) interrupt(msg string) {
+	if frame := s.stack; isLuaFrame(frame) {
+		msg = addInfo(msg, frame.closure.proto.Source, currentLine(frame))
+	}
+	panic(interruptSignal{msg})
+}
+
+// 保护调用捕获的错误的状态码
+func errorStatus(r interface{}) int {
+	switch r.(type) {
+	case interruptSignal:
+		return LUA_ERRINTERRUPT
+	case memoryError:
+		return LUA_ERRMEM
+	}
+	return LUA_ERRRUN
+}
+
+// 不能被捕获的中断：保护调用展开调用栈之后应该把它继续抛给外层的保护调用，
+// 只有宿主程序在线程的最外层（当前调用帧是线程的第一个调用帧）发起的保护调用才返回它。
+// 宽限期用完之后的中断总是不能被捕获
+func (s *luaState) uncatchable(status int) bool {
+	return status == LUA_ERRINTERRUPT && (!s.g.catchable || s.g.graceOver)
+}
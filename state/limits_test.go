@@ -0,0 +1,146 @@
+package state
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "luago/api"
+)
+
+func runLimited(ls *luaState, code string) (int, string) {
+	if status := ls.LoadString(code); status != LUA_OK {
+		return status, ls.ToString(-1)
+	}
+	status := ls.PCall(0, 1, 0)
+	msg := ls.ToString(-1)
+	ls.Pop(1)
+	return status, msg
+}
+
+// 默认情况下中断不能被pcall和coroutine.resume捕获，宿主程序的PCall返回LUA_ERRINTERRUPT
+func TestInstructionLimit(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetInstructionLimit(100000)
+	status, msg := runLimited(ls, `
+		while true do
+			pcall(function() while true do end end)
+			coroutine.resume(coroutine.create(function() while true do end end))
+		end
+	`)
+	if status != LUA_ERRINTERRUPT || !strings.HasSuffix(msg, ": instruction limit exceeded") {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+	if status, _ := runLimited(ls, `return 1`); status != LUA_ERRINTERRUPT {
+		t.Errorf("budget was not exhausted: %d", status)
+	}
+	ls.SetInstructionLimit(0)
+	if status, msg := runLimited(ls, `for i = 1, 200000 do end`); status != LUA_OK {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+
+	// 可以捕获时，中断之后有一段宽限期，宽限期用完再次中断，这一次不能被捕获
+	ls.SetInterruptCatchable(true)
+	ls.SetInstructionLimit(1000)
+	status, msg = runLimited(ls, `
+		local ok, err = pcall(function() while true do end end)
+		for i = 1, 1000 do end
+		return err
+	`)
+	if status != LUA_OK || !strings.HasSuffix(msg, "instruction limit exceeded") {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+	ls.SetInstructionLimit(1000)
+	status, msg = runLimited(ls, `
+		while true do pcall(function() while true do end end) end
+	`)
+	if status != LUA_ERRINTERRUPT || !strings.HasSuffix(msg, "instruction limit exceeded") {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+	if status, _ := runLimited(ls, `return 1`); status != LUA_ERRINTERRUPT {
+		t.Errorf("limit was removed: %d", status)
+	}
+}
+
+func TestContextLimit(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ls.SetContext(ctx)
+	status, msg := runLimited(ls, `
+		local co = coroutine.wrap(function()
+			while true do pcall(string.rep, "x", 10) end
+		end)
+		pcall(co)
+		return "not interrupted"
+	`)
+	if status != LUA_ERRINTERRUPT || !strings.HasSuffix(msg, "context deadline exceeded") {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+
+	ls.SetInterruptCatchable(true)
+	status, msg = runLimited(ls, `
+		while true do pcall(function() while true do end end) end
+	`)
+	if status != LUA_ERRINTERRUPT || !strings.HasSuffix(msg, "context deadline exceeded") {
+		t.Errorf("catchable: status = %d, msg = %q", status, msg)
+	}
+	ls.SetContext(nil)
+	if status, msg := runLimited(ls, `return 1`); status != LUA_OK {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+	if ls.GetTop() != 0 {
+		t.Errorf("top = %d", ls.GetTop())
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	status, msg := runLimited(ls, `
+		local function f() return 1 + f() end
+		local ok, err = pcall(f)
+		return err
+	`)
+	if status != LUA_OK || !strings.HasSuffix(msg, ": stack overflow") {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+
+	ls.SetCallDepthLimit(100)
+	status, msg = runLimited(ls, `
+		local depth = 0
+		local function f() depth = depth + 1; return 1 + f() end
+		pcall(f)
+		return depth
+	`)
+	if status != LUA_ERRINTERRUPT || !strings.HasSuffix(msg, ": call depth limit exceeded") {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+	ls.SetInterruptCatchable(true)
+	status, msg = runLimited(ls, `
+		local depth = 0
+		local function f() depth = depth + 1; return 1 + f() end
+		local ok, err = pcall(f)
+		assert(not ok and err:find("call depth limit exceeded"))
+		return depth
+	`)
+	if status != LUA_OK || msg != "97" {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+
+	// 恢复协程时层数从恢复者的层数加一开始，可能正好落在上限上
+	ls.SetInterruptCatchable(false)
+	for _, limit := range []int{51, 52, 53} {
+		ls.SetCallDepthLimit(limit)
+		status, msg = runLimited(ls, `
+			local function f() coroutine.wrap(f)() end
+			f()
+		`)
+		if status != LUA_ERRINTERRUPT || !strings.HasSuffix(msg, "call depth limit exceeded") {
+			t.Errorf("limit %d: status = %d, msg = %q", limit, status, msg)
+		}
+	}
+}
@@ -7,10 +7,22 @@ type luaStack struct {
 	slots []luaValue
 	top   int // 记录栈顶索引，从1开始
 	// call info
-	state   *luaState
-	closure *closure
-	varargs []luaValue
-	pc      int
+	state    *luaState
+	closure  *closure
+	varargs  []luaValue
+	pc       int
+	tailcall bool // 是否是通过尾调用进入的
+	hooked   bool // 是否正在执行钩子函数
+	nResults int  // 调用者期望的返回值数量，函数返回（或者协程恢复后返回）时按它调整返回值
+	leq      bool // 正在用__lt元方法计算a <= b（也就是not (b < a)），让出之后恢复时要对结果取反
+	// continuation (Go函数)
+	k       KFunction
+	ctx     KContext
+	ypcall  bool       // 是否正在执行可以让出的PCallK
+	oldTop  int        // PCallK被调函数的位置，出错时从这里开始放错误对象
+	errFunc luaValue   // PCallK的消息处理函数
+	saved   []luaValue // 让出时保存Go函数的栈（让出的值除外），恢复时还原
+	tbc     []int      // 待关闭变量的位置（从0开始），按标记的顺序排列
 	// linked list
 	prev    *luaStack
 	openuvs map[int]*upvalue
@@ -24,8 +36,12 @@ func newLuaStack(size int, state *luaState) *luaStack {
 	}
 }
 
+// 栈的增长计入内存用量，但是不检查内存上限（出错处理的过程中也会扩展栈），CheckStack负责检查
 func (s *luaStack) check(n int) {
 	free := len(s.slots) - s.top
+	if n > free {
+		s.state.g.charge((n - free) * valueSize)
+	}
 	for i := free; i < n; i++ {
 		s.slots = append(s.slots, nil)
 	}
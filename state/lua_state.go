@@ -1,17 +1,68 @@
 package state
 
-import . "luago/api"
+import (
+	"context"
+
+	. "luago/api"
+)
 
 type luaState struct {
-	registry *luaTable // 注册表
+	g        *globalState // 所有线程共享的状态
+	registry *luaTable    // 注册表
 	stack    *luaStack
 	coStatus int
-	coCaller *luaState
-	coChan   chan int
+	nny      int // 不可让出的调用数量（number of non-yieldable calls），为0时才能让出
+	nCcalls  int // 嵌套调用的层数，恢复协程时从恢复它的线程的层数开始计算
+	// hook
+	hook          Hook
+	hookMask      int
+	baseHookCount int
+	hookCount     int
+	inHook        bool // 钩子函数运行期间不再触发钩子
+	oldPC         int  // 上一次触发行钩子时的pc，用来判断是否进入了新的一行
+}
+
+// 同一个Lua环境里所有线程共享的状态
+// lua-5.3.4/src/lstate.h#global_State
+type globalState struct {
+	gcRunning  bool             // 是否自动执行回收，执行终结器期间也是false
+	gcKind     int              // LUA_GCINC或者LUA_GCGEN，只是记录下来，两种模式的行为相同
+	gcDebt     int              // 还可以分配多少字节内存才需要执行下一次自动回收
+	gcEstimate int              // 上一次回收之后的内存用量
+	gcPause    int              // 下一次自动回收之前内存用量可以增长的百分比
+	gcStepMul  int              // 只是记录下来，没有效果
+	finobj     map[luaValue]int // 设置了带__gc字段的元表的对象，值是注册的顺序
+	finSeq     int              // 下一个注册的对象的顺序
+	tobefnz    []luaValue       // 已经不可达、等待调用终结器的对象
+	// 执行限制
+	limited   bool            // 是否设置了上下文或者指令数量，为false时执行指令之前不需要检查
+	ctx       context.Context // 取消时中断脚本，nil表示不检查
+	ctxCheck  int             // 再执行多少条指令检查一次上下文
+	insnLimit bool            // 是否限制了指令数量
+	insnLeft  int64           // 还可以执行的指令数量
+	callLimit int             // 调用层数的上限，0表示不限制
+	maxCalls  int             // callLimit和LUAI_MAXCCALLS里比较小的那个，调用层数达到它时才需要检查
+	catchable bool            // 中断能否被脚本捕获
+	graceLeft int             // 可以捕获的中断触发之后，宽限期还剩下的指令数量
+	graceMsg  string          // 宽限期用完时再次中断的信息
+	graceOver bool            // 宽限期已经用完，中断不能再被捕获
+	graceCtx  bool            // 宽限期是上下文触发的（否则是指令数量触发的）
+	// 内存统计
+	totalBytes int // 估算的内存用量（字节）
+	memLimit   int // 内存用量的上限，0表示不限制
 }
 
 func New() *luaState {
-	ls := &luaState{}
+	ls := &luaState{nny: 1} // 主线程不能让出
+	ls.g = &globalState{
+		gcRunning: true,
+		gcKind:    LUA_GCINC,
+		gcDebt:    gcMinDebt,
+		gcPause:   gcDefaultPause,
+		gcStepMul: gcDefaultStepMul,
+		finobj:    make(map[luaValue]int),
+		maxCalls:  LUAI_MAXCCALLS,
+	}
 
 	registry := newLuaTable(8, 0)
 	registry.put(LUA_RIDX_MAINTHREAD, ls)
@@ -19,6 +70,7 @@ func New() *luaState {
 
 	ls.registry = registry
 	ls.pushLuaStack(newLuaStack(LUA_MINSTACK, ls))
+	ls.g.totalBytes = ls.mark().bytes
 
 	return ls
 }
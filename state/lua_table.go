@@ -12,7 +12,7 @@ type luaTable struct {
 	_map      map[luaValue]luaValue
 	keys      map[luaValue]luaValue // used by next()
 	lastKey   luaValue              // used by next()
-	changed   bool                  // used by next()
+	changed   bool                  // used by next()，增加了键，下一次从头遍历时需要重新生成keys
 }
 
 func newLuaTable(nArr, nRec int) *luaTable {
@@ -59,6 +59,9 @@ func (t *luaTable) put(key, val luaValue) {
 	if idx, ok := key.(int64); ok && idx >= 1 {
 		arrLen := int64(len(t.arr))
 		if idx <= arrLen {
+			if t.arr[idx-1] == nil && val != nil {
+				t.changed = true
+			}
 			t.arr[idx-1] = val
 			if idx == arrLen && val == nil {
 				t._shrinkArray()
@@ -66,6 +69,9 @@ func (t *luaTable) put(key, val luaValue) {
 			return
 		}
 		if idx == arrLen+1 {
+			if _, found := t._map[key]; !found && val != nil {
+				t.changed = true
+			}
 			delete(t._map, key)
 			if val != nil {
 				t.arr = append(t.arr, val)
@@ -75,6 +81,9 @@ func (t *luaTable) put(key, val luaValue) {
 		}
 	}
 
+	if _, found := t._map[key]; !found && val != nil {
+		t.changed = true
+	}
 	if val != nil {
 		if t._map == nil {
 			t._map = make(map[luaValue]luaValue, 8)
@@ -138,12 +147,16 @@ func (t *luaTable) nextKey(key luaValue) luaValue {
 		t.changed = false
 	}
 
-	nextKey := t.keys[key]
-	if nextKey == nil && key != nil && key != t.lastKey {
-		panic("invalid key to 'next'")
+	for {
+		nextKey := t.keys[key]
+		if nextKey == nil && key != nil && key != t.lastKey {
+			panic("invalid key to 'next'")
+		}
+		if nextKey == nil || t.get(nextKey) != nil {
+			return nextKey
+		}
+		key = nextKey // 遍历期间被删除（或者被回收）的条目：删除键不会重新生成keys，正在进行的遍历可以从它继续
 	}
-
-	return nextKey
 }
 
 func PrintTable(table *luaTable) {
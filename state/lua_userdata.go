@@ -0,0 +1,19 @@
+package state
+
+// 完全用户数据（full userdata），每个值都可以有自己的元表和一个关联的Lua值（user value）
+// http://www.lua.org/manual/5.3/manual.html#2.1
+type userdata struct {
+	metatable *luaTable
+	uservalue luaValue
+	data      interface{} // NewUserData()分配的[]byte，或者PushUserData()传入的Go值
+}
+
+// 轻量用户数据（light userdata）只是对Go值的引用，没有自己的元表，按值比较，
+// 所以包装的值必须是可比较的（通常是指针）
+type lightUserdata struct {
+	p interface{}
+}
+
+func newUserdata(data interface{}) *userdata {
+	return &userdata{data: data}
+}
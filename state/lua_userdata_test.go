@@ -0,0 +1,99 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	. "luago/api"
+)
+
+type point struct{ x, y int64 }
+
+func checkPoint(ls LuaState) *point {
+	return ls.CheckUData(1, "Point").(*point)
+}
+
+// Go类型通过元表的__index把方法暴露给脚本
+func TestUserData(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.NewMetatable("Point")
+	ls.NewTable()
+	ls.SetFuncs(FuncReg{
+		"x": func(ls LuaState) int { ls.PushInteger(checkPoint(ls).x); return 1 },
+		"move": func(ls LuaState) int {
+			p := checkPoint(ls)
+			p.x += ls.CheckInteger(2)
+			p.y += ls.CheckInteger(3)
+			return 0
+		},
+	}, 0)
+	ls.SetField(-2, "__index")
+	ls.Pop(1)
+	if ls.NewMetatable("Point") {
+		t.Error("NewMetatable should not replace an existing metatable")
+	}
+	ls.Pop(1)
+
+	p := &point{1, 2}
+	ls.PushUserData(p)
+	ls.SetMetatable2("Point")
+	ls.SetGlobal("p")
+	ls.PushLightUserData(p)
+	ls.SetGlobal("lp")
+	ls.PushLightUserData(p)
+	ls.SetGlobal("lp2")
+	ls.Register("newbuf", func(ls LuaState) int {
+		b := ls.NewUserData(int(ls.CheckInteger(1)))
+		b[0] = 42
+		ls.PushString("uv")
+		ls.SetUserValue(-2)
+		return 1
+	})
+
+	code := `
+		assert(type(p) == "userdata" and type(lp) == "userdata")
+		assert(p:x() == 1)
+		p:move(10, 20)
+		assert(p:x() == 11)
+		assert(lp == lp2 and lp ~= p)
+		assert(tostring(p):find("^Point: "))
+		local ok, err = pcall(p.move, {}, 1, 1)
+		assert(not ok and err:find("Point expected, got table"), err)
+		buf = newbuf(8)
+		local t = {[lp] = 1}
+		assert(t[lp2] == 1)
+	`
+	if ls.LoadString(code) != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	if p.x != 11 || p.y != 22 {
+		t.Errorf("p = %+v", *p)
+	}
+
+	ls.GetGlobal("buf")
+	if ls.Type(-1) != LUA_TUSERDATA || ls.RawLen(-1) != 8 {
+		t.Fatalf("buf: %s, len %d", ls.TypeName2(-1), ls.RawLen(-1))
+	}
+	if b := ls.ToUserData(-1).([]byte); b[0] != 42 {
+		t.Errorf("buf[0] = %d", b[0])
+	}
+	if ls.GetUserValue(-1) != LUA_TSTRING || ls.ToString(-1) != "uv" {
+		t.Error("user value lost")
+	}
+	ls.Pop(2)
+
+	// 包装切片的轻量用户数据出错时得到普通的错误信息，而不是Go的运行时错误
+	ls.PushLightUserData([]int{1})
+	ls.SetGlobal("ls")
+	if ls.LoadString(`local s = ls; return s.x`) != LUA_OK || ls.PCall(0, 0, 0) != LUA_ERRRUN ||
+		!strings.HasSuffix(ls.ToString(-1), "attempt to index a userdata value (local 's')") {
+		t.Errorf("index light userdata: %s", ls.ToString(-1))
+	}
+	ls.Pop(1)
+
+	ls.GetGlobal("lp")
+	if !ls.IsLightUserData(-1) || ls.ToUserData(-1) != p || ls.TestUData(-1, "Point") != nil {
+		t.Error("light userdata")
+	}
+}
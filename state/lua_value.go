@@ -26,6 +26,10 @@ func typeOf(val luaValue) LuaType {
 		return LUA_TFUNCTION
 	case *luaState:
 		return LUA_TTHREAD
+	case *userdata:
+		return LUA_TUSERDATA
+	case lightUserdata:
+		return LUA_TLIGHTUSERDATA
 	default:
 		panic("todo!")
 	}
@@ -83,9 +87,17 @@ func _stringToInteger(s string) (int64, bool) {
 // 否则的话，根据变量类型把元表存储在注册表里，这样就达到了按类型共享元表的目的。
 // 虽然注册表也是一个普通的表，不过按照约定，下划线开头后跟大写字母的字段名是保留给Lua实现使用的，所以我们使用了“_MT1”这样的字段名，以免和用户（通过API）放在注册表里的数据产生冲突。
 // 另外，如果传递给函数的元表是nil值，效果就相当于删除元表。
+// 和表一样，每个完全用户数据也有自己的元表。
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
+	if mt != nil && mt.get("__gc") != nil {
+		ls.checkFinalizer(val)
+	}
+	switch x := val.(type) {
+	case *luaTable:
+		x.metatable = mt
+		return
+	case *userdata:
+		x.metatable = mt
 		return
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
@@ -93,8 +105,11 @@ func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
 }
 
 func getMetatable(val luaValue, ls *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
+	switch x := val.(type) {
+	case *luaTable:
+		return x.metatable
+	case *userdata:
+		return x.metatable
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
 	if mt := ls.registry.get(key); mt != nil {
@@ -110,6 +125,8 @@ func getMetafield(val luaValue, fieldName string, ls *luaState) luaValue {
 	return nil
 }
 
+// Lua函数的指令调用的元方法可以让出，协程恢复时由Instruction.Finish完成被打断的指令
+// lua-5.3.4/src/ltm.c#luaT_callTM()
 func callMetamethod(a, b luaValue, mmName string, ls *luaState) (luaValue, bool) {
 	var mm luaValue
 	if mm = getMetafield(a, mmName, ls); mm == nil {
@@ -0,0 +1,144 @@
+package state
+
+import (
+	"unsafe"
+
+	"luago/binchunk"
+)
+
+// 内存统计：Go没法告诉我们一个Lua环境用了多少内存，所以在创建字符串、表、闭包、用户数据、线程，
+// 给表增加新的键以及扩展栈的地方，按照估算的大小把内存计入g.totalBytes；标记阶段再从所有可达的对象重新计算用量，
+// 不可达的对象交给Go回收，不再计入。调用帧随着函数返回释放，只在标记阶段计算，创建时不计入。
+// 设置了内存上限时，分配之前先检查：超过上限就执行一次紧急回收（只标记），仍然超过（或者回收得太少）就抛出内存错误，
+// 保护调用返回LUA_ERRMEM，错误对象是"not enough memory"。和C实现一样，内存错误不调用消息处理函数
+// lua-5.3.4/src/lmem.c
+
+// 估算的对象大小（字节），大致是64位平台上Go的内存布局
+const (
+	valueSize   = 16  // 一个luaValue（接口值），也是栈和数组部分每个元素的大小
+	stringSize  = 16  // 字符串头，再加上内容的长度
+	tableSize   = 96  // 空表
+	nodeSize    = 48  // 哈希部分的一个键值对，包括Go map的开销
+	closureSize = 48  // 没有upvalue的闭包
+	upvalSize   = 32  // 每个upvalue和它引用的值
+	udataSize   = 48  // 完全用户数据，NewUserData分配的内存另算
+	threadSize  = 128 // 线程，调用帧另算
+	frameSize   = 192 // 调用帧，栈另算
+	protoSize   = 160 // 函数原型，指令和常量另算
+	longString  = 64  // 长字符串在标记阶段按照内容的地址去重
+)
+
+// 内存不足时抛出的错误
+type memoryError struct{}
+
+// lua-5.3.4/src/lstate.h#MEMERRMSG
+const memErrMsg = "not enough memory"
+
+// [-0, +0, –]
+func (s *luaState) SetMemoryLimit(n int) {
+	s.g.memLimit = n
+}
+
+// 宿主程序或者库函数分配大块内存之前调用，内存用量再增加n字节就超过上限时抛出内存错误。
+// n并不计入用量，分配出来的对象推入栈的时候才计入
+// [-0, +0, m]
+func (s *luaState) CheckMemory(n int) {
+	if !s.hasMemory(n) {
+		panic(memoryError{})
+	}
+}
+
+// 内存用量再增加n字节是否还在上限以内。超过上限时先执行一次紧急回收，重新计算用量再比较。
+// 紧急回收要标记所有可达的对象，如果它回收的内存不到上限的1/8，也当作内存不足：
+// 否则可达的对象接近上限时，之后的每次分配都要再标记一遍
+// lua-5.3.4/src/lmem.c#luaM_realloc_()
+func (s *luaState) hasMemory(n int) bool {
+	g := s.g
+	if g.memLimit <= 0 || n <= g.memLimit-g.totalBytes {
+		return true
+	}
+	if g.ctx != nil && g.graceLeft == 0 { /* 标记之前先看看上下文是不是已经取消了 */
+		if err := g.ctx.Err(); err != nil {
+			s.trip(err.Error(), true)
+		}
+	}
+	before := g.totalBytes
+	s.emergencyGC() /* try to free some memory... */
+	return before-g.totalBytes >= g.memLimit/8 && n <= g.memLimit-g.totalBytes
+}
+
+// 在分配n字节内存之前调用：检查内存上限，然后计入内存用量和回收债务
+func (s *luaState) allocate(n int) {
+	s.CheckMemory(n)
+	s.g.charge(n)
+}
+
+func (g *globalState) charge(n int) {
+	g.totalBytes += n
+	g.gcDebt -= n
+}
+
+// 紧急回收只执行标记，重新计算内存用量：不清除弱表，也不分离需要终结的对象，
+// 所以可以在任何分配内存的地方执行，不会影响只保存在Go变量里的对象
+// lua-5.3.4/src/lgc.c#luaC_fullgc()
+func (s *luaState) emergencyGC() {
+	s.g.totalBytes = s.mark().bytes
+}
+
+// 给表增加键key需要的内存：紧接着数组部分的整数键追加到数组里，其他的键放进哈希部分
+func (t *luaTable) entrySize(key luaValue) int {
+	if idx, ok := _floatToInteger(key).(int64); ok && idx == int64(len(t.arr))+1 {
+		return valueSize
+	}
+	return nodeSize
+}
+
+// 标记阶段计算的对象大小，不包括它引用的其他对象
+func sizeOf(val luaValue) int {
+	switch x := val.(type) {
+	case *luaTable:
+		return tableSize + cap(x.arr)*valueSize + (len(x._map)+len(x.keys))*nodeSize
+	case *closure:
+		return closureSize + len(x.upvals)*upvalSize
+	case *luaState:
+		size := threadSize
+		for frame := x.stack; frame != nil; frame = frame.prev {
+			size += frameSize + (len(frame.slots)+len(frame.varargs)+len(frame.saved))*valueSize
+		}
+		return size
+	case *userdata:
+		if b, ok := x.data.([]byte); ok {
+			return udataSize + len(b)
+		}
+		return udataSize
+	}
+	return 0
+}
+
+// 函数原型的大小，不包括子函数原型（它们在自己的闭包被标记时计算）
+func protoBytes(proto *binchunk.Prototype) int {
+	size := protoSize + (len(proto.Code)+len(proto.LineInfo))*4 +
+		len(proto.Constants)*valueSize + len(proto.Protos)*8 + len(proto.Upvalues)*2
+	for _, c := range proto.Constants {
+		if str, ok := c.(string); ok {
+			size += len(str)
+		}
+	}
+	for _, v := range proto.LocVars {
+		size += stringSize + len(v.VarName) + 8
+	}
+	return size
+}
+
+// 字符串不需要遍历，只计算大小。Go的字符串共享内容，长字符串按照内容的地址去重；
+// 短字符串每次被引用都计算一次，多算的部分和引用它的槽位差不多大
+func (g *gcState) markString(str string) {
+	if len(str) >= longString {
+		p := unsafe.StringData(str)
+		if g.strings[p] {
+			return
+		}
+		g.strings[p] = true
+	}
+	g.bytes += stringSize + len(str)
+}
@@ -0,0 +1,93 @@
+package state
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	. "luago/api"
+)
+
+// 超过内存上限时保护调用返回LUA_ERRMEM，内存错误可以被pcall捕获，但是不调用消息处理函数
+func TestMemoryLimit(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetMemoryLimit(4 << 20)
+	for _, code := range []string{
+		`local t = {} for i = 1, 1e7 do t[i] = {} end`,
+		`local t = {} for i = 1, 1e7 do t[i] = ("x"):rep(100) .. i end`,
+		`local s = "x" while true do s = s .. s end`,
+		`local f = {} for i = 1, 1e7 do f[i] = function() return i end end`,
+	} {
+		if status, msg := runLimited(ls, code); status != LUA_ERRMEM || msg != "not enough memory" {
+			t.Errorf("%s: status = %d, msg = %q", code, status, msg)
+		}
+	}
+	// 拼接结果之前就检查内存上限，不会先分配出几百兆的字符串
+	for _, code := range []string{
+		`local s = ("x"):rep(1 << 20) local t = {} for i = 1, 300 do t[i] = s end return table.concat(t)`,
+		`local s = ("x"):rep(1 << 20) return (("x"):rep(300):gsub(".", s))`,
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		status, msg := runLimited(ls, code)
+		runtime.ReadMemStats(&after)
+		if status != LUA_ERRMEM || msg != "not enough memory" {
+			t.Errorf("%s: status = %d, msg = %q", code, status, msg)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 64<<20 {
+			t.Errorf("%s: allocated %d bytes", code, n)
+		}
+	}
+	status, msg := runLimited(ls, `
+		local ok, err = pcall(string.rep, "x", 1 << 30)
+		assert(not ok and err == "not enough memory")
+		ok, err = xpcall(string.rep, function(m) return "handled" end, "x", 1 << 30)
+		assert(not ok and err == "not enough memory")
+		ok, err = pcall(table.unpack, {}, 1, 900000)
+		assert(not ok and err:find("too many results to unpack"))
+		return "ok"
+	`)
+	if status != LUA_OK || msg != "ok" {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+
+	// 可达的对象接近上限时，不会每次分配都执行一次紧急回收，而是很快报告内存不足
+	ls.SetMemoryLimit(8 << 20)
+	start := time.Now()
+	status, msg = runLimited(ls, `
+		local keep = {}
+		pcall(function() for i = 1, 1e7 do keep[i] = {} end end)
+		for i = 1, 5 do keep[#keep] = nil end
+		for i = 1, 2000 do local x = {} end
+	`)
+	if status != LUA_ERRMEM || time.Since(start) > 2*time.Second {
+		t.Errorf("status = %d, msg = %q, %v", status, msg, time.Since(start))
+	}
+	ls.SetMemoryLimit(0)
+	if status, msg := runLimited(ls, `local t = {} for i = 1, 1e6 do t[i] = {} end`); status != LUA_OK {
+		t.Errorf("limit was not removed: %s", msg)
+	}
+}
+
+// collectgarbage("count")返回估算的内存用量，回收之后不再计入不可达的对象
+func TestMemoryCount(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	status, msg := runLimited(ls, `
+		collectgarbage()
+		local before = collectgarbage("count")
+		local t = {}
+		for i = 1, 10000 do t[i] = {i} end
+		local s = ("x"):rep(1 << 20)
+		local after = collectgarbage("count")
+		assert(after - before > 1024 + 10000 * 0.1, after - before)
+		t, s = nil, nil
+		collectgarbage()
+		assert(collectgarbage("count") - before < 64, collectgarbage("count") - before)
+		return "ok"
+	`)
+	if status != LUA_OK || msg != "ok" {
+		t.Errorf("status = %d, msg = %q", status, msg)
+	}
+}
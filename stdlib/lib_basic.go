@@ -6,27 +6,28 @@ import "strings"
 import . "luago/api"
 
 var baseFuncs = map[string]GoFunction{
-	"print":        basePrint,
-	"assert":       baseAssert,
-	"error":        baseError,
-	"select":       baseSelect,
-	"ipairs":       baseIPairs,
-	"pairs":        basePairs,
-	"next":         baseNext,
-	"load":         baseLoad,
-	"loadfile":     baseLoadFile,
-	"dofile":       baseDoFile,
-	"pcall":        basePCall,
-	"xpcall":       baseXPCall,
-	"getmetatable": baseGetMetatable,
-	"setmetatable": baseSetMetatable,
-	"rawequal":     baseRawEqual,
-	"rawlen":       baseRawLen,
-	"rawget":       baseRawGet,
-	"rawset":       baseRawSet,
-	"type":         baseType,
-	"tostring":     baseToString,
-	"tonumber":     baseToNumber,
+	"print":          basePrint,
+	"assert":         baseAssert,
+	"error":          baseError,
+	"select":         baseSelect,
+	"ipairs":         baseIPairs,
+	"pairs":          basePairs,
+	"next":           baseNext,
+	"load":           baseLoad,
+	"loadfile":       baseLoadFile,
+	"dofile":         baseDoFile,
+	"pcall":          basePCall,
+	"xpcall":         baseXPCall,
+	"getmetatable":   baseGetMetatable,
+	"setmetatable":   baseSetMetatable,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"type":           baseType,
+	"tostring":       baseToString,
+	"tonumber":       baseToNumber,
+	"collectgarbage": baseCollectGarbage,
 	/* placeholders */
 	"_G":       nil,
 	"_VERSION": nil,
@@ -92,9 +93,9 @@ func baseError(ls LuaState) int {
 	level := int(ls.OptInteger(2, 1))
 	ls.SetTop(1)
 	if ls.Type(1) == LUA_TSTRING && level > 0 {
-		// ls.Where(level) /* add extra information */
-		// ls.PushValue(1)
-		// ls.Concat(2)
+		ls.Where(level) /* add extra information */
+		ls.PushValue(1)
+		ls.Concat(2)
 	}
 	return ls.Error()
 }
@@ -185,16 +186,57 @@ func baseLoad(ls LuaState) int {
 		chunkname := ls.OptString(2, chunk)
 		status = ls.Load([]byte(chunk), chunkname, mode)
 	} else { /* loading from a reader function */
-		panic("loading from a reader function") // todo
+		chunkname := ls.OptString(2, "=(load)")
+		ls.CheckType(1, LUA_TFUNCTION)
+		var data []byte
+		if data, status = _readChunk(ls); status == LUA_OK {
+			status = ls.Load(data, chunkname, mode)
+		}
 	}
 	return loadAux(ls, status, env)
 }
 
+/*
+** Reader for generic 'load' function: calls the reader function
+** repeatedly and concatenates the pieces until it returns nil or an
+** empty string. Errors raised by the reader are returned as a status
+** with the message on the top of the stack.
+ */
+// lua-5.3.4/src/lbaselib.c#generic_reader()
+func _readChunk(ls LuaState) ([]byte, int) {
+	var buf []byte
+	for {
+		ls.CheckStack2(2, "too many nested functions")
+		ls.PushValue(1) /* get function */
+		if status := ls.PCall(0, 1, 0); status != LUA_OK {
+			return nil, status
+		}
+		if ls.IsNil(-1) {
+			ls.Pop(1) /* pop result */
+			return buf, LUA_OK
+		}
+		piece, ok := ls.ToStringX(-1)
+		ls.Pop(1)
+		if !ok {
+			ls.PushString("reader function must return a string")
+			return nil, LUA_ERRRUN
+		}
+		if piece == "" {
+			return buf, LUA_OK
+		}
+		buf = append(buf, piece...)
+	}
+}
+
 // lua-5.3.4/src/lbaselib.c#load_aux()
 func loadAux(ls LuaState, status, envIdx int) int {
 	if status == LUA_OK {
 		if envIdx != 0 { /* 'env' parameter? */
-			panic("todo!")
+			/* environment for loaded function */
+			ls.PushValue(envIdx)
+			if _, ok := ls.SetUpvalue(-2, 1); !ok { /* set it as 1st upvalue */
+				ls.Pop(1) /* remove 'env' if not used by previous call */
+			}
 		}
 		return 1
 	} else { /* error (message is on top of the stack) */
@@ -209,7 +251,7 @@ func loadAux(ls LuaState, status, envIdx int) int {
 // lua-5.3.4/src/lbaselib.c#luaB_loadfile()
 func baseLoadFile(ls LuaState) int {
 	fname := ls.OptString(1, "")
-	mode := ls.OptString(1, "bt")
+	mode := ls.OptString(2, "bt")
 	env := 0 /* 'env' index or 0 if no 'env' */
 	if !ls.IsNone(3) {
 		env = 3
@@ -222,29 +264,53 @@ func baseLoadFile(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-dofile
 // lua-5.3.4/src/lbaselib.c#luaB_dofile()
 func baseDoFile(ls LuaState) int {
-	fname := ls.OptString(1, "bt")
+	fname := ls.OptString(1, "")
 	ls.SetTop(1)
 	if ls.LoadFile(fname) != LUA_OK {
 		return ls.Error()
 	}
-	ls.Call(0, LUA_MULTRET)
+	ls.CallK(0, LUA_MULTRET, 0, doFileCont)
+	return doFileCont(ls, 0, 0)
+}
+
+// lua-5.3.4/src/lbaselib.c#dofilecont()
+func doFileCont(ls LuaState, d1 int, d2 KContext) int {
 	return ls.GetTop() - 1
 }
 
 // pcall (f [, arg1, ···])
 // http://www.lua.org/manual/5.3/manual.html#pdf-pcall
 func basePCall(ls LuaState) int {
-	nArgs := ls.GetTop() - 1
-	status := ls.PCall(nArgs, -1, 0)
-	ls.PushBoolean(status == LUA_OK)
-	ls.Insert(1)
-	return ls.GetTop()
+	ls.CheckAny(1)
+	ls.PushBoolean(true) /* first result if no errors */
+	ls.Insert(1)         /* put it in place */
+	status := ls.PCallK(ls.GetTop()-2, LUA_MULTRET, 0, 0, finishPCall)
+	return finishPCall(ls, status, 0)
 }
 
 // xpcall (f, msgh [, arg1, ···])
 // http://www.lua.org/manual/5.3/manual.html#pdf-xpcall
+// lua-5.3.4/src/lbaselib.c#luaB_xpcall()
 func baseXPCall(ls LuaState) int {
-	panic("todo!")
+	n := ls.GetTop()
+	ls.CheckType(2, LUA_TFUNCTION) /* check error function */
+	ls.PushBoolean(true)           /* first result */
+	ls.PushValue(1)                /* function */
+	ls.Rotate(3, 2)                /* move them below function's arguments */
+	status := ls.PCallK(n-2, LUA_MULTRET, 2, 2, finishPCall)
+	return finishPCall(ls, status, 2)
+}
+
+// pcall和xpcall的返回值：出错时返回false和错误对象，否则返回true和被调函数的所有返回值。
+// 被调函数让出过的话，协程恢复后它作为延续函数被调用
+// lua-5.3.4/src/lbaselib.c#finishpcall()
+func finishPCall(ls LuaState, status int, extra KContext) int {
+	if status != LUA_OK && status != LUA_YIELD { /* error? */
+		ls.PushBoolean(false) /* first result (false) */
+		ls.PushValue(-2)      /* error message */
+		return 2              /* return false, msg */
+	}
+	return ls.GetTop() - extra /* return all results */
 }
 
 // getmetatable (object)
@@ -369,3 +435,55 @@ func baseToNumber(ls LuaState) int {
 	ls.PushNil() /* not a number */
 	return 1
 }
+
+// collectgarbage ([opt [, arg]])
+// http://www.lua.org/manual/5.4/manual.html#pdf-collectgarbage
+// lua-5.4.6/src/lbaselib.c#luaB_collectgarbage()
+func baseCollectGarbage(ls LuaState) int {
+	opts := map[string]int{
+		"stop":         LUA_GCSTOP,
+		"restart":      LUA_GCRESTART,
+		"collect":      LUA_GCCOLLECT,
+		"count":        LUA_GCCOUNT,
+		"step":         LUA_GCSTEP,
+		"setpause":     LUA_GCSETPAUSE,
+		"setstepmul":   LUA_GCSETSTEPMUL,
+		"isrunning":    LUA_GCISRUNNING,
+		"generational": LUA_GCGEN,
+		"incremental":  LUA_GCINC,
+	}
+	opt := ls.OptString(1, "collect")
+	o, ok := opts[opt]
+	if !ok {
+		return ls.ArgError(1, fmt.Sprintf("invalid option '%s'", opt))
+	}
+	switch o {
+	case LUA_GCCOUNT:
+		k := ls.GC(o)
+		b := ls.GC(LUA_GCCOUNTB)
+		ls.PushNumber(float64(k) + float64(b)/1024)
+	case LUA_GCSTEP, LUA_GCISRUNNING:
+		ls.PushBoolean(ls.GC(o, int(ls.OptInteger(2, 0))) != 0)
+	case LUA_GCGEN:
+		minormul := int(ls.OptInteger(2, 0))
+		majormul := int(ls.OptInteger(3, 0))
+		pushGCMode(ls, ls.GC(o, minormul, majormul))
+	case LUA_GCINC:
+		pause := int(ls.OptInteger(2, 0))
+		stepmul := int(ls.OptInteger(3, 0))
+		stepsize := int(ls.OptInteger(4, 0))
+		pushGCMode(ls, ls.GC(o, pause, stepmul, stepsize))
+	default:
+		ls.PushInteger(int64(ls.GC(o, int(ls.OptInteger(2, 0)))))
+	}
+	return 1
+}
+
+// lua-5.4.6/src/lbaselib.c#pushmode()
+func pushGCMode(ls LuaState, oldMode int) {
+	if oldMode == LUA_GCINC {
+		ls.PushString("incremental")
+	} else {
+		ls.PushString("generational")
+	}
+}
@@ -0,0 +1,509 @@
+package stdlib_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "luago/api"
+	"luago/state"
+)
+
+func TestLoadSyntaxError(t *testing.T) {
+	runLua(t, `
+		local f, err = load("x = ")
+		assert(f == nil and err == [[[string "x = "]:1: syntax error near <eof>]], err)
+		f, err = load("x = = 1", "=chunk")
+		assert(f == nil and err == "chunk:1: syntax error near '='", err)
+		f, err = loadfile("/nonexistent/file.lua")
+		assert(f == nil and string.find(err, "cannot open /nonexistent/file.lua", 1, true), err)
+		local ok, err = pcall(dofile, "/nonexistent/file.lua")
+		assert(not ok and string.find(err, "cannot open /nonexistent/file.lua", 1, true), err)
+		assert(load("return 1 + 1")() == 2)
+	`)
+}
+
+func TestLoadMode(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "text.lua")
+	if err := os.WriteFile(text, []byte("#!/usr/bin/lua\nreturn 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runLua(t, fmt.Sprintf(`
+		local text = %q
+		local bin = string.dump(function() return 42 end)
+		local f, err = load(bin, "bin", "t")
+		assert(f == nil and err == "attempt to load a binary chunk (mode is 't')", err)
+		f, err = load("return 42", "txt", "b")
+		assert(f == nil and err == "attempt to load a text chunk (mode is 'b')", err)
+		assert(load(bin, "bin", "b")() == 42)
+		assert(load(bin, "bin", "bt")() == 42)
+		assert(load("return 42", "txt", "t")() == 42)
+		assert(load("return 42")() == 42)
+		for _, n in ipairs({5, 20, #bin - 1}) do -- 被截断的二进制chunk
+			f, err = load(bin:sub(1, n))
+			assert(f == nil and err == "binary string: truncated precompiled chunk", err)
+		end
+		f, err = load(bin:sub(1, 4) .. "\x52" .. bin:sub(6), "=bad")
+		assert(f == nil and err == "bad: version mismatch in precompiled chunk", err)
+
+		f, err = loadfile(text, "b")
+		assert(f == nil and err == "attempt to load a text chunk (mode is 'b')", err)
+		assert(loadfile(text, "t")() == 42)
+		assert(loadfile(text)() == 42)
+		assert(dofile(text) == 42)
+	`, text))
+
+	ls := state.New()
+	if ls.LoadFileX(text, "b") != LUA_ERRSYNTAX {
+		t.Errorf("LoadFileX(mode 'b') loaded a text chunk")
+	} else if msg := ls.ToString(-1); msg != "attempt to load a text chunk (mode is 'b')" {
+		t.Errorf("got %q", msg)
+	}
+	if ls.Load([]byte("\x1bLua\x53\x00"), "@x.luac", "b") != LUA_ERRSYNTAX {
+		t.Errorf("Load accepted a truncated chunk")
+	} else if msg := ls.ToString(-1); msg != "x.luac: truncated precompiled chunk" {
+		t.Errorf("got %q", msg)
+	}
+}
+
+func TestLoadEnvAndReader(t *testing.T) {
+	runLua(t, `
+		-- env
+		local env = {y = 10}
+		local f = load("x = y * 2; return x", "chunk", "t", env)
+		assert(f() == 20 and env.x == 20 and x == nil)
+		f = load("return ...", "chunk", "t", env) -- 'env' is ignored only if there is no upvalue
+		assert(f(1) == 1)
+		f = load("return _ENV", nil, nil, nil)
+		assert(f() == nil)
+		local bin = string.dump(function() return a end)
+		assert(load(bin, nil, "b", {a = 5})() == 5)
+
+		-- reader
+		local pieces = {"local a", " = 1", "; return a ", "+ 1"}
+		local i = 0
+		f = load(function() i = i + 1; return pieces[i] end)
+		assert(f() == 2 and i == #pieces + 1)
+		i = 0
+		pieces = {"return ", "'x'", "", "error()"}
+		assert(load(function() i = i + 1; return pieces[i] end)() == "x")
+		local big = {}
+		for j = 1, 1000 do big[j] = "x = (x or 0) + 1\n" end
+		big[#big + 1] = "return x"
+		i = 0
+		assert(load(function() i = i + 1; return big[i] end, "=big", "t", {})() == 1000)
+
+		-- errors
+		local err
+		f, err = load(function() error("reader failed") end)
+		assert(f == nil and string.find(err, "reader failed", 1, true), err)
+		f, err = load(function() return {} end)
+		assert(f == nil and err == "reader function must return a string", err)
+		local done = false
+		f, err = load(function() if not done then done = true; return "x = " end end, "=rd")
+		assert(f == nil and err == "rd:1: syntax error near <eof>", err)
+		assert(not pcall(load, {}))
+	`)
+}
+
+func TestGoto(t *testing.T) {
+	runLua(t, `
+		-- 嵌套循环里的continue
+		local out = {}
+		for i = 1, 3 do
+			for j = 1, 3 do
+				if j == 2 then goto continue end
+				out[#out+1] = i .. j
+				::continue::
+			end
+		end
+		assert(table.concat(out, ",") == "11,13,21,23,31,33")
+
+		-- 跳出多层循环
+		local found
+		for i = 1, 3 do
+			for j = 1, 3 do
+				if i * j == 6 then found = i .. j; goto done end
+			end
+		end
+		::done::
+		assert(found == "23")
+
+		-- 向后跳转时关闭被闭包捕获的局部变量
+		local fs = {}
+		do
+			local i = 1
+			::top::
+			local x = i
+			fs[#fs+1] = function() return x end
+			i = i + 1
+			if i <= 3 then goto top end
+		end
+		assert(fs[1]() == 1 and fs[2]() == 2 and fs[3]() == 3)
+
+		-- 从内层块break也要关闭upvalue
+		local gs = {}
+		for i = 1, 3 do
+			do
+				local y = i * 10
+				gs[i] = function() return y end
+				if i == 2 then break end
+			end
+		end
+		assert(gs[1]() == 10 and gs[2]() == 20 and gs[3] == nil)
+
+		-- 块末尾的标签在局部变量的作用域之外
+		do
+			goto l
+			local z = 1
+			::l::
+		end
+
+		-- 同名标签可以出现在嵌套的块里
+		local n = 0
+		::l::
+		do
+			n = n + 1
+			if n < 3 then goto l end
+			::l::
+		end
+		assert(n == 1)
+	`)
+}
+
+// 尾调用复用调用帧，百万层的尾递归既不会让调用帧链表变长，也不会让Go的调用栈变深
+func TestTailCall(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	var heap [2]uint64
+	var goStack [2]int
+	ls.Register("probe", func(ls LuaState) int {
+		i := ls.CheckInteger(1)
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		heap[i] = m.HeapInuse
+		goStack[i] = runtime.Stack(make([]byte, 1<<20), false)
+		return 0
+	})
+	code := `
+		local function loop(n)
+			if n == 0 then return probe(1) end
+			return loop(n - 1)
+		end
+		loop(1) probe(0)
+		loop(1000000)
+
+		local t = setmetatable({}, {__call = function(self, a, b) return a + b end})
+		local function f(...) return t(...) end
+		assert(f(1, 2) == 3)
+
+		local function g(...) return select("#", ...) end
+		local function h() return g(1, nil, nil) end
+		assert(h() == 3)
+
+		local fs = {}
+		local function mk(i)
+			local x = i
+			fs[i] = function() return x end
+			if i < 3 then return mk(i + 1) end
+		end
+		mk(1)
+		assert(fs[1]() == 1 and fs[2]() == 2 and fs[3]() == 3)
+	`
+	if ls.LoadString(code) != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	if heap[1] > heap[0]+16<<20 {
+		t.Errorf("heap grew from %d to %d bytes", heap[0], heap[1])
+	}
+	if goStack[1] > goStack[0]+4096 {
+		t.Errorf("go stack grew from %d to %d bytes", goStack[0], goStack[1])
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	tests := []struct{ code, msg string }{
+		{"foo()", "t.lua:1: attempt to call a nil value (global 'foo')"},
+		{"local t\nt.x = 1", "t.lua:2: attempt to index a nil value (local 't')"},
+		{"local t = {}\nreturn t.a.b", "t.lua:2: attempt to index a nil value (field 'a')"},
+		{"local up\nreturn (function() return up[1] end)()", "t.lua:2: attempt to index a nil value (upvalue 'up')"},
+		{"local o = {}\no:m()", "t.lua:2: attempt to call a nil value (method 'm')"},
+		{"local s = 'x'\nreturn s + 1", "t.lua:2: attempt to perform arithmetic on a string value (local 's')"},
+		{"local n = 0/0\nreturn n | 1", "t.lua:2: number (local 'n') has no integer representation"},
+		{"return 1 & 1.5", "t.lua:1: number has no integer representation"},
+		{"return {} | 1", "t.lua:1: attempt to perform bitwise operation on a table value"},
+		{"local a = {}\nreturn 'x' .. a", "t.lua:2: attempt to concatenate a table value (local 'a')"},
+		{"return #print", "t.lua:1: attempt to get length of a function value (global 'print')"},
+		{"return 1 < 'x'", "t.lua:1: attempt to compare number with string"},
+		{"return {} <= {}", "t.lua:1: attempt to compare two table values"},
+		{"local n = 0\nreturn 1 // n", "t.lua:2: attempt to perform 'n//0'"},
+		{"local n = 0\nreturn 1 % n", "t.lua:2: attempt to perform 'n%0'"},
+		{"local t = {}\nt[0/0] = 1", "t.lua:2: table index is NaN"},
+		{"for i = 1, {} do end", "t.lua:1: 'for' limit must be a number"},
+		{"local t = setmetatable({}, {__name = 'MyType'})\nreturn t()", "t.lua:2: attempt to call a MyType value (local 't')"},
+		{"error('boom')", "t.lua:1: boom"},
+		{"local function f() error('boom', 2) end\nf()", "t.lua:2: boom"},
+		{"error('boom', 0)", "boom"},
+		{"assert(false)", "t.lua:1: assertion failed!"},
+		{"string.rep()", "t.lua:1: bad argument #1 to 'rep' (string expected, got no value)"},
+		{"('x'):rep({})", "t.lua:1: bad argument #1 to 'rep' (number expected, got table)"},
+	}
+	for _, test := range tests {
+		if ls.Load([]byte(test.code), "@t.lua", "t") != LUA_OK {
+			t.Fatal(ls.ToString(-1))
+		}
+		if ls.PCall(0, 0, 0) != LUA_ERRRUN {
+			t.Errorf("%q: expected an error", test.code)
+		} else if msg := ls.ToString(-1); msg != test.msg {
+			t.Errorf("%q: got %q, want %q", test.code, msg, test.msg)
+		}
+		ls.SetTop(0)
+	}
+}
+
+func TestMessageHandler(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.PushGoFunction(func(ls LuaState) int {
+		ls.Traceback(ls, ls.ToString(1), 1)
+		return 1
+	})
+	ls.Load([]byte("local function f() local x; return x.y end\nf()"), "@t.lua", "t")
+	if ls.PCall(0, 0, 1) != LUA_ERRRUN {
+		t.Fatal("expected an error")
+	}
+	expected := "t.lua:1: attempt to index a nil value (local 'x')\n" +
+		"stack traceback:\n\tt.lua:1: in local 'f'\n\tt.lua:2: in main chunk"
+	if msg := ls.ToString(-1); msg != expected {
+		t.Errorf("got %q, want %q", msg, expected)
+	}
+}
+
+func TestXPCall(t *testing.T) {
+	runLua(t, `
+		local function handler(msg)
+			return debug.traceback(msg, 2)
+		end
+		local function f() error("boom") end
+		local ok, msg = xpcall(f, handler)
+		assert(not ok and msg:find("boom", 1, true) and msg:find("in function 'error'", 1, true), msg)
+
+		local ok, a, b = xpcall(function(x, y) return y, x end, print, 1, 2)
+		assert(ok and a == 2 and b == 1)
+		assert(select("#", pcall(error)) == 2)
+		assert(not pcall(xpcall, print))
+
+		local ok, msg = xpcall(error, error)
+		assert(not ok and msg == "error in error handling", msg)
+
+		local co = coroutine.create(function() error("dead") end)
+		assert(not coroutine.resume(co))
+		local ok, msg = coroutine.resume(co)
+		assert(not ok and msg == "cannot resume dead coroutine", msg)
+		co = coroutine.create(function() return coroutine.resume(coroutine.running()) end)
+		local _, ok, msg = coroutine.resume(co)
+		assert(not ok and msg == "cannot resume non-suspended coroutine", msg)
+	`)
+}
+
+func TestGoPanic(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.PushInteger(42)
+	ls.PushGoFunction(func(ls LuaState) int {
+		var p *struct{ x int }
+		ls.PushInteger(int64(p.x))
+		return 1
+	})
+	if status := ls.PCall(0, 1, 0); status != LUA_ERRRUN {
+		t.Fatalf("got status %d, want LUA_ERRRUN", status)
+	}
+	if msg, ok := ls.ToStringX(-1); !ok || !strings.Contains(msg, "nil pointer") {
+		t.Errorf("unexpected error object: %q", msg)
+	}
+	if ls.GetTop() != 2 || ls.ToInteger(1) != 42 {
+		t.Errorf("stack not restored, top = %d", ls.GetTop())
+	}
+}
+
+func TestWeakTables(t *testing.T) {
+	runLua(t, `
+		local function count(t)
+			local n = 0
+			for _ in pairs(t) do n = n + 1 end
+			return n
+		end
+		local keep = {}
+		local wk = setmetatable({}, {__mode = "k"})
+		wk[keep] = 1; wk[{}] = 2; wk.str = {}
+		local wv = setmetatable({}, {__mode = "v"})
+		wv[1] = {}; wv[2] = keep; wv.s = "s"; wv.f = function() end
+		local kv = setmetatable({}, {__mode = "kv"})
+		kv[keep] = {}; kv[{}] = keep; kv[1] = keep
+		local e = setmetatable({}, {__mode = "k"})
+		do local k = {}; e[k] = {ref = k} end -- 值引用了键，仍然可以回收
+		local k2 = {}; e[k2] = {ref = k2}
+
+		collectgarbage("collect")
+		assert(count(wk) == 2 and wk[keep] == 1 and wk.str)
+		assert(count(wv) == 2 and wv[1] == nil and wv[2] == keep and wv.s == "s")
+		assert(count(kv) == 1 and kv[1] == keep)
+		assert(count(e) == 1 and e[k2].ref == k2)
+
+		local cache = setmetatable({}, {__mode = "k"})
+		for i = 1, 100000 do cache[{}] = i end
+		assert(count(cache) < 100000) -- 自动回收
+
+		local t = {a = 1}
+		assert(count(t) == 1)
+		t.b = 2
+		assert(count(t) == 2)
+		t.a = nil
+		assert(next(t) == "b" and next(t, "b") == nil)
+
+		-- 遍历时删除当前的键，同时从头开始别的遍历
+		t = {1, 2, 3, a = 1, b = 2, c = 3}
+		for k in pairs(t) do
+			t[k] = nil
+			for k2 in pairs(t) do assert(t[k2]) end
+			if next(t) == nil then break end
+		end
+		assert(next(t) == nil)
+	`)
+}
+
+func TestCollectGarbage(t *testing.T) {
+	runLua(t, `
+		local log = {}
+		local function new(name)
+			local o = setmetatable({name = name}, {__gc = function(o) log[#log + 1] = o.name end})
+			o.self = o -- 有环的对象也会被终结
+			return o
+		end
+		local a = new("a"); new("b"); new("c")
+		collectgarbage()
+		assert(table.concat(log, ",") == "c,b")
+		a = nil
+		collectgarbage()
+		assert(table.concat(log, ",") == "c,b,a")
+
+		local saved
+		local wk = setmetatable({}, {__mode = "k"})
+		local wv = setmetatable({}, {__mode = "v"})
+		;(function()
+			local o = setmetatable({}, {__gc = function(o) saved = o end})
+			wk[o] = 1; wv[1] = o
+		end)()
+		collectgarbage()
+		assert(saved and wv[1] == nil and wk[saved] == 1) -- 复活的对象从弱值表里删除，弱键表里保留
+
+		setmetatable({}, {__gc = function() error("ignored") end})
+		setmetatable({}, {__gc = function() coroutine.yield() end})
+		assert(collectgarbage("collect") == 0)
+		local n = 0
+		for i = 1, 100000 do setmetatable({}, {__gc = function() n = n + 1 end}) end
+		assert(n > 0) -- 自动回收
+
+		assert(math.type(collectgarbage("count")) == "float" and collectgarbage("count") > 0)
+		assert(collectgarbage("step") == true and collectgarbage("isrunning") == true)
+		collectgarbage("stop")
+		assert(collectgarbage("isrunning") == false)
+		collectgarbage("restart")
+		assert(collectgarbage("incremental") == "incremental")
+		assert(collectgarbage("generational") == "incremental")
+		assert(collectgarbage("incremental") == "generational")
+		assert(collectgarbage("setpause", 100) == 200 and collectgarbage("setpause", 200) == 100)
+		local ok, msg = pcall(collectgarbage, "bogus")
+		assert(not ok and msg:find("invalid option 'bogus'", 1, true))
+	`)
+}
+
+func TestToBeClosed(t *testing.T) {
+	runLua(t, `
+		local log = {}
+		local function res(name)
+			return setmetatable({}, {__close = function(_, err)
+				log[#log + 1] = err and name .. "!" .. err or name
+			end})
+		end
+		local function flush()
+			local s = table.concat(log, ",")
+			log = {}
+			return s
+		end
+
+		do
+			local a <close> = res("a")
+			local b <close> = res("b")
+			local c <close> = nil
+		end
+		assert(flush() == "b,a")
+		for i = 1, 3 do
+			local x <close> = res(i)
+			if i == 2 then break end
+		end
+		assert(flush() == "1,2")
+		local i = 0
+		::again::
+		do
+			local y <close> = res("y")
+			i = i + 1
+			if i < 2 then goto again end
+		end
+		assert(flush() == "y,y")
+		local function f(...)
+			local r <close> = res("r")
+			return ...
+		end
+		local a, b = f(1, 2)
+		assert(a == 1 and b == 2 and flush() == "r")
+		local function g()
+			local r <close> = res("g")
+			return f() -- 不是尾调用
+		end
+		g()
+		assert(flush() == "r,g")
+
+		local ok, err = pcall(function()
+			local e <close> = res("e")
+			local e2 <close> = setmetatable({}, {__close = function() error("in close", 0) end})
+			error("boom", 0)
+		end)
+		assert(not ok and err == "in close" and flush() == "e!in close")
+		local ok, err = pcall(function() local x <close> = {} end)
+		assert(not ok and err:find("variable 'x' got a non-closable value", 1, true))
+
+		local co = coroutine.create(function()
+			local r <close> = res("co")
+			coroutine.yield()
+		end)
+		coroutine.resume(co)
+		assert(coroutine.close(co) and flush() == "co")
+		co = coroutine.create(function()
+			local r <close> = res("dead")
+			error("e", 0)
+		end)
+		assert(not coroutine.resume(co) and flush() == "")
+		assert(select(2, coroutine.close(co)) == "e" and flush() == "dead!e")
+	`)
+}
+
+func TestConstLocals(t *testing.T) {
+	runLua(t, `
+		local N <const> = 10
+		local S <const>, T <const> = "s", {}
+		local M <const> = N
+		assert(N * 2 == 20 and S .. "x" == "sx" and M == 10 and type(T) == "table")
+		assert((function() return N + M end)() == 20)
+		local N = 1 -- 遮蔽
+		N = N + 1
+		assert(N == 2)
+	`)
+}
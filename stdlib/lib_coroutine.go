@@ -10,8 +10,19 @@ var coFuncs = map[string]GoFunction{
 	"isyieldable": coYieldable,
 	"running":     coRunning,
 	"wrap":        coWrap,
+	"close":       coClose,
 }
 
+/* 协程的状态 */
+const (
+	cosRun   = iota /* running */
+	cosDead         /* dead */
+	cosYield        /* suspended */
+	cosNorm         /* normal */
+)
+
+var statName = []string{"running", "dead", "suspended", "normal"}
+
 func OpenCoroutineLib(ls LuaState) int {
 	ls.NewLib(coFuncs)
 	return 1
@@ -32,8 +43,7 @@ func coCreate(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.resume
 // lua-5.3.4/src/lcorolib.c#luaB_coresume()
 func coResume(ls LuaState) int {
-	co := ls.ToThread(1)
-	ls.ArgCheck(co != nil, 1, "thread expected")
+	co := getCo(ls)
 
 	if r := _auxResume(ls, co, ls.GetTop()-1); r < 0 {
 		ls.PushBoolean(false)
@@ -83,28 +93,56 @@ func coYield(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.status
 // lua-5.3.4/src/lcorolib.c#luaB_costatus()
 func coStatus(ls LuaState) int {
+	co := getCo(ls)
+	ls.PushString(statName[auxStatus(ls, co)])
+	return 1
+}
+
+func getCo(ls LuaState) LuaState {
 	co := ls.ToThread(1)
 	ls.ArgCheck(co != nil, 1, "thread expected")
+	return co
+}
+
+// lua-5.4.6/src/lcorolib.c#auxstatus()
+func auxStatus(ls, co LuaState) int {
 	if ls == co {
-		ls.PushString("running")
-	} else {
-		switch co.Status() {
-		case LUA_YIELD:
-			ls.PushString("suspended")
-		case LUA_OK:
-			if co.GetStack() { /* does it have frames? */
-				ls.PushString("normal") /* it is running */
-			} else if co.GetTop() == 0 {
-				ls.PushString("dead")
-			} else {
-				ls.PushString("suspended")
-			}
-		default: /* some error occurred */
-			ls.PushString("dead")
+		return cosRun
+	}
+	switch co.Status() {
+	case LUA_YIELD:
+		return cosYield
+	case LUA_OK:
+		var ar DebugInfo
+		if co.GetStack(0, &ar) { /* does it have frames? */
+			return cosNorm /* it is running */
+		} else if co.GetTop() == 0 {
+			return cosDead
+		} else {
+			return cosYield /* initial state */
 		}
+	default: /* some error occurred */
+		return cosDead
 	}
+}
 
-	return 1
+// coroutine.close (co)
+// http://www.lua.org/manual/5.4/manual.html#pdf-coroutine.close
+// lua-5.4.6/src/lcorolib.c#luaB_close()
+func coClose(ls LuaState) int {
+	co := getCo(ls)
+	switch status := auxStatus(ls, co); status {
+	case cosDead, cosYield:
+		if co.CloseThread(ls) == LUA_OK {
+			ls.PushBoolean(true)
+			return 1
+		}
+		ls.PushBoolean(false)
+		co.XMove(ls, 1) /* move error message */
+		return 2
+	default: /* normal or running coroutine */
+		return ls.Error2("cannot close a %s coroutine", statName[status])
+	}
 }
 
 // coroutine.isyieldable ()
@@ -124,6 +162,29 @@ func coRunning(ls LuaState) int {
 
 // coroutine.wrap (f)
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.wrap
+// lua-5.3.4/src/lcorolib.c#luaB_cowrap()
 func coWrap(ls LuaState) int {
-	panic("todo: coWrap!")
+	coCreate(ls)
+	ls.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+// 协程出错结束时先关闭它，再把错误继续抛出，字符串错误加上位置信息
+// lua-5.4.6/src/lcorolib.c#auxwrap()
+func auxWrap(ls LuaState) int {
+	co := ls.ToThread(LuaUpvalueIndex(1))
+	r := _auxResume(ls, co, ls.GetTop())
+	if r < 0 { /* error? */
+		if co.Status() != LUA_OK && co.Status() != LUA_YIELD { /* error in the coroutine? */
+			co.CloseThread(ls) /* close its tbc variables */
+			co.XMove(ls, 1)    /* move error message to the caller */
+		}
+		if ls.Type(-1) == LUA_TSTRING { /* error object is a string? */
+			ls.Where(1) /* get extra info, if available */
+			ls.Insert(-2)
+			ls.Concat(2)
+		}
+		return ls.Error() /* propagate error */
+	}
+	return r
 }
@@ -0,0 +1,249 @@
+package stdlib_test
+
+import (
+	"runtime"
+	"testing"
+
+	. "luago/api"
+	"luago/state"
+)
+
+func TestCoroutineYield(t *testing.T) {
+	runLua(t, `
+		local co = coroutine.create(function(a, b)
+			local function deep(n)
+				if n == 0 then return coroutine.yield(a + b) end
+				return (deep(n - 1))
+			end
+			local c = deep(10)
+			for i in function(_, i) if i < 3 then return coroutine.yield(i) or i + 1 end end, nil, 0 do end
+			return coroutine.yield(c)
+		end)
+		assert(select(2, coroutine.resume(co, 1, 2)) == 3)
+		assert(select(2, coroutine.resume(co, "c")) == 0)
+		coroutine.resume(co); coroutine.resume(co)
+		assert(select(2, coroutine.resume(co)) == "c")
+		local ok, x, y = coroutine.resume(co, "x", "y")
+		assert(ok and x == "x" and y == "y" and coroutine.status(co) == "dead")
+		local ok, msg = coroutine.resume(co)
+		assert(not ok and msg == "cannot resume dead coroutine")
+
+		co = coroutine.create(function()
+			local ok, err = pcall(function() coroutine.yield(1); error("boom", 0) end)
+			assert(not ok and err == "boom")
+			local ok, v = pcall(coroutine.yield, 2)
+			assert(ok and v == "v")
+			local ok, err = xpcall(function() coroutine.yield(3); error("e", 0) end,
+				function(m) return m .. "!" end)
+			assert(not ok and err == "e!")
+			error("dead", 0)
+		end)
+		for i = 1, 3 do assert(select(2, coroutine.resume(co, "v")) == i) end
+		local ok, err = coroutine.resume(co)
+		assert(not ok and err == "dead" and coroutine.status(co) == "dead")
+		assert(debug.traceback(co):find("in function <", 1, true))
+
+		-- 元方法可以让出，恢复之后完成被打断的指令
+		local y = coroutine.yield
+		local mt = {
+			__index = function(t, k) return y("index") end,
+			__newindex = function(t, k, v) rawset(t, k, y("newindex") .. v) end,
+			__add = function(a, b) return y("add") end,
+			__unm = function(a) return y("unm") end,
+			__len = function(a) return y("len") end,
+			__concat = function(a, b) return y("concat") end,
+			__eq = function(a, b) return y("eq") end,
+			__lt = function(a, b) return y("lt") end,
+			__call = function(self, x) return y("call") end,
+		}
+		co = coroutine.wrap(function()
+			local a, b = setmetatable({}, mt), setmetatable({}, mt)
+			local r = {a.x, a + 1, -a, #a, "x" .. a .. "y" .. b, a == b, a < b, a <= b, a(1)}
+			a.z = 1
+			r[#r + 1] = rawget(a, "z")
+			local obj = setmetatable({}, {__index = function(t, k) return y("self") end})
+			r[#r + 1] = obj:m()
+			if a < b then r[#r + 1] = "then" end
+			for i = 1, #r do r[i] = tostring(r[i]) end
+			return r
+		end)
+		local values = {index = "i", add = 2, unm = "u", len = 4, concat = "c", eq = true,
+			lt = false, call = "f", newindex = "n", self = function() return "m" end}
+		local log = {}
+		local v = co()
+		while type(v) == "string" do
+			log[#log + 1] = v
+			v = co(values[v])
+		end
+		assert(table.concat(v, ",") == "i,2,u,4,xc,true,false,true,f,n1,m")
+		assert(table.concat(log, " ") == "index add unm len concat concat eq lt lt call newindex self lt")
+		co = coroutine.create(function() -- Go函数里调用的元方法仍然不能让出
+			return table.unpack(setmetatable({}, {__index = function() return y() end}), 1, 1)
+		end)
+		local ok, err = coroutine.resume(co)
+		assert(not ok and err:find("attempt to yield across a C-call boundary", 1, true))
+		local ok, err = pcall(coroutine.yield)
+		assert(not ok and err == "attempt to yield from outside a coroutine")
+		assert(not coroutine.isyieldable())
+		assert(coroutine.resume(coroutine.create(coroutine.isyieldable)))
+		local ok, err = coroutine.resume(coroutine.running())
+		assert(not ok and err == "cannot resume non-suspended coroutine")
+	`)
+}
+
+func TestYieldK(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	var k KFunction = func(ls LuaState, status int, ctx KContext) int {
+		if status != LUA_YIELD || ctx != 42 {
+			ls.PushString("bad continuation")
+			return ls.Error()
+		}
+		ls.PushInteger(ls.ToInteger(-1) * 10)
+		return 1
+	}
+	// callk(f, x) 调用f(x)，把结果乘以10
+	ls.Register("callk", func(ls LuaState) int {
+		ls.CallK(1, 1, 42, k)
+		return k(ls, LUA_YIELD, 42)
+	})
+	// yieldk(x) 让出x，恢复后把传入的值乘以10
+	ls.Register("yieldk", func(ls LuaState) int {
+		return ls.YieldK(1, 42, k)
+	})
+	code := `
+		local co = coroutine.create(function(x)
+			local a = callk(function(y) return coroutine.yield(y) + 1 end, x)
+			local b = yieldk(a)
+			return a, b
+		end)
+		assert(select(2, coroutine.resume(co, 5)) == 5)
+		assert(select(2, coroutine.resume(co, 6)) == 70)
+		local ok, a, b = coroutine.resume(co, 8)
+		assert(ok and a == 70 and b == 80)
+		assert(callk(function(y) return y end, 3) == 30) -- 主线程里直接调用
+	`
+	if ls.LoadString(code) != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+func TestCoroutineClose(t *testing.T) {
+	runLua(t, `
+		local co = coroutine.create(error)
+		assert(not coroutine.resume(co, 100))
+		local ok, msg = coroutine.close(co)
+		assert(not ok and msg == 100 and coroutine.status(co) == "dead")
+		assert(coroutine.close(co) == true)
+
+		co = coroutine.create(function() coroutine.yield() end)
+		assert(coroutine.status(co) == "suspended" and coroutine.resume(co))
+		assert(coroutine.close(co) and coroutine.status(co) == "dead")
+		assert(select(2, coroutine.resume(co)) == "cannot resume dead coroutine")
+
+		local ok, msg = pcall(coroutine.close, coroutine.running())
+		assert(not ok and msg:find("cannot close a running coroutine", 1, true))
+		local a, b
+		a = coroutine.create(function() return coroutine.status(b), pcall(coroutine.close, b) end)
+		b = coroutine.create(function() return coroutine.resume(a) end)
+		local _, _, st, ok, msg = coroutine.resume(b)
+		assert(st == "normal" and not ok and msg:find("cannot close a normal coroutine", 1, true))
+		assert(coroutine.status(a) == "dead" and coroutine.status(b) == "dead")
+
+		local gen = coroutine.wrap(function(n) for i = 1, n do coroutine.yield(i) end return "end" end)
+		assert(gen(2) == 1 and gen() == 2 and gen() == "end")
+		assert(select(2, pcall(gen)) == "cannot resume dead coroutine")
+		local ok, msg = pcall(coroutine.wrap(function() error("oops") end))
+		assert(not ok and msg:find(":%d+: oops$"))
+	`)
+}
+
+// 宿主程序可以取消挂起的协程，然后复用这个线程
+func TestCloseThread(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	co := ls.NewThread()
+	co.LoadString("coroutine.yield(1); error('unreachable')")
+	if co.Resume(ls, 0) != LUA_YIELD {
+		t.Fatal(co.ToString(-1))
+	}
+	if status := co.ResetThread(); status != LUA_OK || co.GetTop() != 0 || co.Status() != LUA_OK {
+		t.Fatalf("status = %d, top = %d", status, co.GetTop())
+	}
+	co.LoadString("error('failed', 0)")
+	if co.Resume(ls, 0) != LUA_ERRRUN {
+		t.Fatal("expected an error")
+	}
+	co.Pop(1)
+	if status := co.CloseThread(ls); status != LUA_ERRRUN || co.ToString(-1) != "failed" {
+		t.Fatalf("status = %d, error = %q", status, co.ToString(-1))
+	}
+	co.SetTop(0)
+	co.LoadString("return 42")
+	if co.Resume(ls, 0) != LUA_OK || co.ToInteger(-1) != 42 {
+		t.Fatal("thread cannot be reused")
+	}
+}
+
+// 被丢弃的协程应该和其他Lua值一样被回收
+func TestCoroutineLeak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	ls := state.New()
+	ls.OpenLibs()
+	ls.LoadString("return function(a) coroutine.yield(a) end")
+	ls.Call(0, 1)
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 1000000; i++ {
+		co := ls.NewThread()
+		ls.PushValue(1)
+		ls.XMove(co, 1)
+		ls.PushInteger(int64(i))
+		ls.XMove(co, 1)
+		if status := co.Resume(ls, 1); status != LUA_YIELD {
+			t.Fatalf("unexpected status %d", status)
+		}
+		ls.Pop(1)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("%d goroutines leaked", n-goroutines)
+	}
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.HeapAlloc > 64<<20 {
+		t.Errorf("%d bytes still in use", m.HeapAlloc)
+	}
+}
+
+func BenchmarkResumeYield(b *testing.B) {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.LoadString("return coroutine.create(function() while true do coroutine.yield() end end)")
+	ls.Call(0, 1)
+	co := ls.ToThread(-1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if co.Resume(ls, 0) != LUA_YIELD {
+			b.Fatal(co.ToString(-1))
+		}
+	}
+}
+
+// go test -bench Unfinished -benchtime 1000000x 创建一百万个没有结束的协程
+func BenchmarkUnfinishedCoroutines(b *testing.B) {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.LoadString("return function() coroutine.yield() end")
+	ls.Call(0, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		co := ls.NewThread()
+		ls.PushValue(1)
+		ls.XMove(co, 1)
+		co.Resume(ls, 0)
+		ls.Pop(1)
+	}
+}
@@ -0,0 +1,464 @@
+package stdlib
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	. "luago/api"
+)
+
+var dbLib = map[string]GoFunction{
+	"debug":        dbDebug,
+	"getuservalue": dbGetUserValue,
+	"gethook":      dbGetHook,
+	"getinfo":      dbGetInfo,
+	"getlocal":     dbGetLocal,
+	"getregistry":  dbGetRegistry,
+	"getmetatable": dbGetMetatable,
+	"getupvalue":   dbGetUpvalue,
+	"upvaluejoin":  dbUpvalueJoin,
+	"upvalueid":    dbUpvalueId,
+	"setuservalue": dbSetUserValue,
+	"sethook":      dbSetHook,
+	"setlocal":     dbSetLocal,
+	"setmetatable": dbSetMetatable,
+	"setupvalue":   dbSetUpvalue,
+	"traceback":    dbTraceback,
+}
+
+// 注册表里保存钩子表的键，钩子表以线程为键，以Lua钩子函数为值
+const hookKey = "_HKEY"
+
+func OpenDebugLib(ls LuaState) int {
+	ls.NewLib(dbLib)
+	return 1
+}
+
+// debug.getregistry ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getregistry
+// lua-5.3.4/src/ldblib.c#db_getregistry()
+func dbGetRegistry(ls LuaState) int {
+	ls.PushValue(LUA_REGISTRYINDEX)
+	return 1
+}
+
+// debug.getmetatable (value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getmetatable
+// lua-5.3.4/src/ldblib.c#db_getmetatable()
+func dbGetMetatable(ls LuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetatable(1) {
+		ls.PushNil() /* no metatable */
+	}
+	return 1
+}
+
+// debug.setmetatable (value, table)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setmetatable
+// lua-5.3.4/src/ldblib.c#db_setmetatable()
+func dbSetMetatable(ls LuaState) int {
+	t := ls.Type(2)
+	ls.ArgCheck(t == LUA_TNIL || t == LUA_TTABLE, 2, "nil or table expected")
+	ls.SetTop(2)
+	ls.SetMetatable(1)
+	return 1 /* return 1st argument */
+}
+
+// debug.getuservalue (u)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getuservalue
+// lua-5.3.4/src/ldblib.c#db_getuservalue()
+func dbGetUserValue(ls LuaState) int {
+	if ls.Type(1) != LUA_TUSERDATA {
+		ls.PushNil()
+	} else {
+		ls.GetUserValue(1)
+	}
+	return 1
+}
+
+// debug.setuservalue (udata, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setuservalue
+// lua-5.3.4/src/ldblib.c#db_setuservalue()
+func dbSetUserValue(ls LuaState) int {
+	ls.CheckType(1, LUA_TUSERDATA)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.SetUserValue(1)
+	return 1
+}
+
+// 很多函数的第一个参数是可选的线程，返回要操作的线程和其余参数的偏移量
+// lua-5.3.4/src/ldblib.c#getthread()
+func getThread(ls LuaState) (LuaState, int) {
+	if ls.IsThread(1) {
+		return ls.ToThread(1), 1
+	}
+	return ls, 0 /* function will operate over current thread */
+}
+
+// 线程l1和当前线程不同时，确保l1的栈里还有n个空位
+// lua-5.3.4/src/ldblib.c#checkstack()
+func checkStack(ls, l1 LuaState, n int) {
+	if ls != l1 && !l1.CheckStack(n) {
+		ls.Error2("stack overflow")
+	}
+}
+
+// debug.getinfo ([thread,] f [, what])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getinfo
+// lua-5.3.4/src/ldblib.c#db_getinfo()
+func dbGetInfo(ls LuaState) int {
+	var ar DebugInfo
+	l1, arg := getThread(ls)
+	options := ls.OptString(arg+2, "flnStu")
+	ls.ArgCheck(!strings.HasPrefix(options, ">"), arg+2, "invalid option '>'")
+	checkStack(ls, l1, 3)
+	if ls.IsFunction(arg + 1) { /* info about a function? */
+		options = ">" + options /* add '>' to 'options' */
+		ls.PushValue(arg + 1)   /* move function to 'L1' stack */
+		ls.XMove(l1, 1)
+	} else { /* stack level */
+		if !l1.GetStack(int(ls.CheckInteger(arg+1)), &ar) {
+			ls.PushNil() /* level out of range */
+			return 1
+		}
+	}
+	if !l1.GetInfo(options, &ar) {
+		return ls.ArgError(arg+2, "invalid option")
+	}
+	ls.NewTable() /* table to collect results */
+	if strings.ContainsRune(options, 'S') {
+		setTabSS(ls, "source", ar.Source)
+		setTabSS(ls, "short_src", ar.ShortSrc)
+		setTabSI(ls, "linedefined", ar.LineDefined)
+		setTabSI(ls, "lastlinedefined", ar.LastLineDefined)
+		setTabSS(ls, "what", ar.What)
+	}
+	if strings.ContainsRune(options, 'l') {
+		setTabSI(ls, "currentline", ar.CurrentLine)
+	}
+	if strings.ContainsRune(options, 'u') {
+		setTabSI(ls, "nups", ar.NUps)
+		setTabSI(ls, "nparams", ar.NParams)
+		setTabSB(ls, "isvararg", ar.IsVararg)
+	}
+	if strings.ContainsRune(options, 'n') {
+		if ar.Name != "" {
+			setTabSS(ls, "name", ar.Name)
+		}
+		setTabSS(ls, "namewhat", ar.NameWhat)
+	}
+	if strings.ContainsRune(options, 't') {
+		setTabSB(ls, "istailcall", ar.IsTailCall)
+	}
+	if strings.ContainsRune(options, 'L') {
+		treatStackOption(ls, l1, "activelines")
+	}
+	if strings.ContainsRune(options, 'f') {
+		treatStackOption(ls, l1, "func")
+	}
+	return 1 /* return table */
+}
+
+func setTabSS(ls LuaState, k, v string) {
+	ls.PushString(v)
+	ls.SetField(-2, k)
+}
+
+func setTabSI(ls LuaState, k string, v int) {
+	ls.PushInteger(int64(v))
+	ls.SetField(-2, k)
+}
+
+func setTabSB(ls LuaState, k string, v bool) {
+	ls.PushBoolean(v)
+	ls.SetField(-2, k)
+}
+
+// GetInfo把'f'和'L'选项的结果留在了l1的栈顶，把它们移到结果表里
+// lua-5.3.4/src/ldblib.c#treatstackoption()
+func treatStackOption(ls, l1 LuaState, fname string) {
+	if ls == l1 {
+		ls.Rotate(-2, 1) /* exchange object and table */
+	} else {
+		l1.XMove(ls, 1) /* move object to the "main" stack */
+	}
+	ls.SetField(-2, fname) /* put object into table */
+}
+
+// debug.getlocal ([thread,] f, local)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getlocal
+// lua-5.3.4/src/ldblib.c#db_getlocal()
+func dbGetLocal(ls LuaState) int {
+	var ar DebugInfo
+	l1, arg := getThread(ls)
+	nvar := int(ls.CheckInteger(arg + 2)) /* local-variable index */
+	if ls.IsFunction(arg + 1) {           /* function argument? */
+		ls.PushValue(arg + 1) /* push function */
+		if name := ls.GetLocal(nil, nvar); name != "" {
+			ls.PushString(name) /* push local name */
+		} else {
+			ls.PushNil()
+		}
+		return 1 /* return only name (there is no value) */
+	}
+	/* stack-level argument */
+	level := int(ls.CheckInteger(arg + 1))
+	if !l1.GetStack(level, &ar) { /* out of range? */
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	checkStack(ls, l1, 1)
+	if name := l1.GetLocal(&ar, nvar); name != "" {
+		l1.XMove(ls, 1)     /* move local value */
+		ls.PushString(name) /* push name */
+		ls.Rotate(-2, 1)    /* re-order */
+		return 2
+	}
+	ls.PushNil() /* no name (nor value) */
+	return 1
+}
+
+// debug.setlocal ([thread,] level, local, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setlocal
+// lua-5.3.4/src/ldblib.c#db_setlocal()
+func dbSetLocal(ls LuaState) int {
+	var ar DebugInfo
+	l1, arg := getThread(ls)
+	level := int(ls.CheckInteger(arg + 1))
+	nvar := int(ls.CheckInteger(arg + 2))
+	if !l1.GetStack(level, &ar) { /* out of range? */
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	ls.CheckAny(arg + 3)
+	ls.SetTop(arg + 3)
+	checkStack(ls, l1, 1)
+	ls.XMove(l1, 1)
+	if name := l1.SetLocal(&ar, nvar); name != "" {
+		ls.PushString(name)
+	} else {
+		l1.Pop(1) /* pop value (if not popped by 'SetLocal') */
+		ls.PushNil()
+	}
+	return 1
+}
+
+// get为true时获取upvalue的值，否则设置upvalue的值
+// lua-5.3.4/src/ldblib.c#auxupvalue()
+func auxUpvalue(ls LuaState, get bool) int {
+	n := int(ls.CheckInteger(2))   /* upvalue index */
+	ls.CheckType(1, LUA_TFUNCTION) /* closure */
+	var name string
+	var ok bool
+	if get {
+		name, ok = ls.GetUpvalue(1, n)
+	} else {
+		name, ok = ls.SetUpvalue(1, n)
+	}
+	if !ok {
+		return 0
+	}
+	ls.PushString(name)
+	if get {
+		ls.Insert(-2)
+		return 2
+	}
+	return 1
+}
+
+// debug.getupvalue (f, up)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getupvalue
+// lua-5.3.4/src/ldblib.c#db_getupvalue()
+func dbGetUpvalue(ls LuaState) int {
+	return auxUpvalue(ls, true)
+}
+
+// debug.setupvalue (f, up, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setupvalue
+// lua-5.3.4/src/ldblib.c#db_setupvalue()
+func dbSetUpvalue(ls LuaState) int {
+	ls.CheckAny(3)
+	return auxUpvalue(ls, false)
+}
+
+// 检查argf处的函数是否有第argnup个参数指定的upvalue，返回upvalue索引
+// lua-5.3.4/src/ldblib.c#checkupval()
+func checkUpval(ls LuaState, argf, argnup int) int {
+	nup := int(ls.CheckInteger(argnup)) /* upvalue index */
+	ls.CheckType(argf, LUA_TFUNCTION)   /* closure */
+	_, ok := ls.GetUpvalue(argf, nup)
+	ls.ArgCheck(ok, argnup, "invalid upvalue index")
+	ls.Pop(1)
+	return nup
+}
+
+// debug.upvalueid (f, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvalueid
+// lua-5.3.4/src/ldblib.c#db_upvalueid()
+func dbUpvalueId(ls LuaState) int {
+	n := checkUpval(ls, 1, 2)
+	ls.PushLightUserData(ls.UpvalueId(1, n))
+	return 1
+}
+
+// debug.upvaluejoin (f1, n1, f2, n2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvaluejoin
+// lua-5.3.4/src/ldblib.c#db_upvaluejoin()
+func dbUpvalueJoin(ls LuaState) int {
+	n1 := checkUpval(ls, 1, 2)
+	n2 := checkUpval(ls, 3, 4)
+	ls.ArgCheck(!ls.IsGoFunction(1), 1, "Lua function expected")
+	ls.ArgCheck(!ls.IsGoFunction(3), 3, "Lua function expected")
+	ls.UpvalueJoin(1, n1, 3, n2)
+	return 0
+}
+
+var hookNames = []string{"call", "return", "line", "count", "tail call"}
+
+// 所有Lua钩子共用的Go钩子函数，它从钩子表里找到当前线程的Lua钩子并调用
+// lua-5.3.4/src/ldblib.c#hookf()
+func hookF(ls LuaState, ar *DebugInfo) {
+	ls.GetField(LUA_REGISTRYINDEX, hookKey)
+	ls.PushThread()
+	if ls.RawGet(-2) == LUA_TFUNCTION { /* is there a hook function? */
+		ls.PushString(hookNames[ar.Event]) /* push event name */
+		if ar.CurrentLine >= 0 {
+			ls.PushInteger(int64(ar.CurrentLine)) /* push current line */
+		} else {
+			ls.PushNil()
+		}
+		ls.Call(2, 0) /* call hook function */
+	}
+}
+
+// 把字符串形式的掩码转换成整数形式
+// lua-5.3.4/src/ldblib.c#makemask()
+func makeMask(smask string, count int) int {
+	mask := 0
+	if strings.ContainsRune(smask, 'c') {
+		mask |= LUA_MASKCALL
+	}
+	if strings.ContainsRune(smask, 'r') {
+		mask |= LUA_MASKRET
+	}
+	if strings.ContainsRune(smask, 'l') {
+		mask |= LUA_MASKLINE
+	}
+	if count > 0 {
+		mask |= LUA_MASKCOUNT
+	}
+	return mask
+}
+
+// 把整数形式的掩码转换成字符串形式
+// lua-5.3.4/src/ldblib.c#unmakemask()
+func unmakeMask(mask int) string {
+	smask := ""
+	if mask&LUA_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&LUA_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&LUA_MASKLINE != 0 {
+		smask += "l"
+	}
+	return smask
+}
+
+// debug.sethook ([thread,] hook, mask [, count])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.sethook
+// lua-5.3.4/src/ldblib.c#db_sethook()
+func dbSetHook(ls LuaState) int {
+	var mask, count int
+	var fn Hook
+	l1, arg := getThread(ls)
+	if ls.IsNoneOrNil(arg + 1) { /* no hook? */
+		ls.SetTop(arg + 1)
+		fn, mask, count = nil, 0, 0 /* turn off hooks */
+	} else {
+		smask := ls.CheckString(arg + 2)
+		ls.CheckType(arg+1, LUA_TFUNCTION)
+		count = int(ls.OptInteger(arg+3, 0))
+		fn, mask = hookF, makeMask(smask, count)
+	}
+	if ls.GetField(LUA_REGISTRYINDEX, hookKey) == LUA_TNIL {
+		ls.Pop(1)
+		ls.CreateTable(0, 2) /* create a hook table */
+		ls.PushValue(-1)
+		ls.SetField(LUA_REGISTRYINDEX, hookKey) /* set it in position */
+		ls.PushString("k")
+		ls.SetField(-2, "__mode") /** hooktable.__mode = "k" */
+		ls.PushValue(-1)
+		ls.SetMetatable(-2) /* setmetatable(hooktable) = hooktable */
+	}
+	checkStack(ls, l1, 1)
+	l1.PushThread()
+	l1.XMove(ls, 1)       /* key (thread) */
+	ls.PushValue(arg + 1) /* value (hook function) */
+	ls.RawSet(-3)         /* hooktable[L1] = new Lua hook */
+	l1.SetHook(fn, mask, count)
+	return 0
+}
+
+// debug.gethook ([thread])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.gethook
+// lua-5.3.4/src/ldblib.c#db_gethook()
+func dbGetHook(ls LuaState) int {
+	l1, _ := getThread(ls)
+	mask := l1.GetHookMask()
+	hook := l1.GetHook()
+	if hook == nil { /* no hook? */
+		ls.PushNil()
+	} else if reflect.ValueOf(hook).Pointer() != reflect.ValueOf(hookF).Pointer() { /* external hook? */
+		ls.PushString("external hook")
+	} else { /* hook table must exist */
+		ls.GetField(LUA_REGISTRYINDEX, hookKey)
+		checkStack(ls, l1, 1)
+		l1.PushThread()
+		l1.XMove(ls, 1)
+		ls.RawGet(-2) /* 1st result = hooktable[L1] */
+		ls.Remove(-2) /* remove hook table */
+	}
+	ls.PushString(unmakeMask(mask))          /* 2nd result = mask */
+	ls.PushInteger(int64(l1.GetHookCount())) /* 3rd result = count */
+	return 3
+}
+
+// debug.debug ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.debug
+// lua-5.3.4/src/ldblib.c#db_debug()
+func dbDebug(ls LuaState) int {
+	for {
+		fmt.Fprint(os.Stderr, "lua_debug> ")
+		line, err := Stdin.ReadString('\n') /* 和io.stdin共用读缓冲 */
+		if err != nil || line == "cont\n" {
+			return 0
+		}
+		if ls.Load([]byte(line), "=(debug command)", "bt") != LUA_OK ||
+			ls.PCall(0, 0, 0) != LUA_OK {
+			fmt.Fprintf(os.Stderr, "%s\n", ls.ToString(-1))
+		}
+		ls.SetTop(0) /* remove eventual returns */
+	}
+}
+
+// debug.traceback ([thread,] [message [, level]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+// lua-5.3.4/src/ldblib.c#db_traceback()
+func dbTraceback(ls LuaState) int {
+	l1, arg := getThread(ls)
+	msg, ok := ls.ToStringX(arg + 1)
+	if !ok && !ls.IsNoneOrNil(arg+1) { /* non-string 'msg'? */
+		ls.PushValue(arg + 1) /* return it untouched */
+	} else {
+		level := 0
+		if ls == l1 {
+			level = 1
+		}
+		level = int(ls.OptInteger(arg+2, int64(level)))
+		ls.Traceback(l1, msg, level)
+	}
+	return 1
+}
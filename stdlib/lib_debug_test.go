@@ -0,0 +1,148 @@
+package stdlib_test
+
+import (
+	"strings"
+	"testing"
+
+	. "luago/api"
+	"luago/state"
+)
+
+func TestDebugInfo(t *testing.T) {
+	runLua(t, `
+		local function f(a, b)
+			local c = a + b
+			local info = debug.getinfo(1, "nSlu")
+			assert(info.name == "f" and info.namewhat == "local")
+			assert(info.what == "Lua" and info.currentline == 4)
+			assert(info.linedefined == 2 and info.lastlinedefined == 14)
+			assert(info.nparams == 2 and not info.isvararg and info.nups == 1)
+			assert(debug.getlocal(1, 1) == "a")
+			local name, val = debug.getlocal(1, 3)
+			assert(name == "c" and val == a + b)
+			assert(debug.setlocal(1, 3, 100) == "c" and c == 100)
+			return c
+		end
+		assert(f(1, 2) == 100)
+		assert(debug.getlocal(f, 2) == "b" and debug.getlocal(f, 3) == nil)
+
+		local info = debug.getinfo(print)
+		assert(info.what == "C" and info.short_src == "[C]" and info.func == print)
+		info = debug.getinfo(1, "SL")
+		assert(info.what == "main" and info.activelines[debug.getinfo(1, "l").currentline])
+		assert(debug.getinfo(100) == nil)
+		assert(not pcall(debug.getinfo, 1, "X"))
+
+		local function v(...) return debug.getlocal(1, -2) end
+		local name, val = v(7, 8)
+		assert(name == "(*vararg)" and val == 8)
+
+		local t = {}
+		t.m = function() return debug.getinfo(1, "n") end
+		assert(t.m().name == "m" and t.m().namewhat == "field")
+		local mt = setmetatable({}, {__index = function() return debug.getinfo(1, "n") end})
+		assert(mt.x.name == "index" and mt.x.namewhat == "metamethod")
+		local function tail() return debug.getinfo(1, "nt") end
+		local function caller() return tail() end
+		info = caller()
+		assert(info.istailcall and info.name == nil)
+	`)
+}
+
+func TestDebugUpvalues(t *testing.T) {
+	runLua(t, `
+		local x, y = 1, 2
+		local function g() return x end
+		local function h() return y end
+		assert(debug.getupvalue(g, 1) == "x")
+		assert(select(2, debug.getupvalue(g, 1)) == 1)
+		assert(debug.getupvalue(g, 2) == nil)
+		assert(debug.setupvalue(g, 1, 10) == "x" and x == 10)
+		assert(debug.upvalueid(g, 1) ~= debug.upvalueid(h, 1))
+		debug.upvaluejoin(g, 1, h, 1)
+		assert(g() == 2 and debug.upvalueid(g, 1) == debug.upvalueid(h, 1))
+		assert(not pcall(debug.upvalueid, g, 2))
+		assert(not pcall(debug.upvaluejoin, print, 1, h, 1))
+
+		local t = setmetatable({}, {__metatable = "locked"})
+		assert(getmetatable(t) == "locked" and type(debug.getmetatable(t)) == "table")
+		debug.setmetatable(t, nil)
+		assert(getmetatable(t) == nil)
+		assert(type(debug.getregistry()) == "table")
+	`)
+}
+
+func TestDebugHooks(t *testing.T) {
+	runLua(t, `
+		local events = {}
+		local function hook(ev, line)
+			events[#events + 1] = ev .. ":" .. tostring(line)
+		end
+		local function f() return 1 end
+		debug.sethook(hook, "crl")
+		f()
+		debug.sethook()
+		assert(table.concat(events, " ") ==
+			"return:nil line:8 call:nil line:6 return:nil line:9 call:nil", table.concat(events, " "))
+
+		local h, mask, count = debug.gethook()
+		assert(h == nil and mask == "" and count == 0)
+		debug.sethook(hook, "l", 5)
+		h, mask, count = debug.gethook()
+		debug.sethook()
+		assert(h == hook and mask == "l" and count == 5)
+
+		local n = 0
+		debug.sethook(function(ev) n = n + 1 end, "", 1)
+		for i = 1, 10 do end
+		debug.sethook()
+		assert(n > 10)
+
+		local co = coroutine.create(function(a) local z = a; coroutine.yield(z) end)
+		coroutine.resume(co, 5)
+		assert(select(2, debug.getlocal(co, 1, 1)) == 5)
+		assert(debug.traceback(co):find("coroutine.yield", 1, true))
+	`)
+}
+
+func TestTraceback(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	code := `
+		local function lvl3() return debug.traceback("oops") end
+		local function lvl2() local s = lvl3() return s end
+		function lvl1() local s = lvl2() return s end
+		return lvl1()
+	`
+	if ls.LoadString(code) != LUA_OK || ls.PCall(0, 1, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	expected := strings.Join([]string{
+		"oops",
+		"stack traceback:",
+		"\t[string \"...\"]:2: in upvalue 'lvl3'",
+		"\t[string \"...\"]:3: in upvalue 'lvl2'",
+		"\t[string \"...\"]:4: in function 'lvl1'",
+		"\t(...tail calls...)",
+	}, "\n")
+	if got := ls.ToString(-1); got != expected {
+		t.Errorf("got %q, want %q", got, expected)
+	}
+}
+
+func TestGetInfoAPI(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	ls.LoadString("local a, b = ...\nreturn a")
+	var ar DebugInfo
+	ls.PushValue(-1)
+	if !ls.GetInfo(">Su", &ar) || ar.What != "main" || !ar.IsVararg || ar.NParams != 0 {
+		t.Errorf("unexpected debug info: %+v", ar)
+	}
+	if name := ls.GetLocal(nil, 1); name != "" {
+		t.Errorf("main chunk has no parameters, got %q", name)
+	}
+	if ls.GetStack(0, &ar) {
+		t.Error("no function is running")
+	}
+}
@@ -0,0 +1,641 @@
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"syscall"
+
+	. "luago/api"
+)
+
+const (
+	_LUA_FILEHANDLE  = "FILE*"
+	_IO_PREFIX       = "_IO_"
+	_IO_INPUT        = _IO_PREFIX + "input"
+	_IO_OUTPUT       = _IO_PREFIX + "output"
+	_L_MAXLENNUM     = 200  /* maximum length of a numeral */
+	_MAXARGLINE      = 250  /* maximum number of arguments to 'f:lines'/'io.lines' */
+	_LUAL_BUFFERSIZE = 8192 /* default buffer size for 'setvbuf' */
+)
+
+var ioFuncs = map[string]GoFunction{
+	"close":   ioClose,
+	"flush":   ioFlush,
+	"input":   ioInput,
+	"lines":   ioLines,
+	"open":    ioOpen,
+	"output":  ioOutput,
+	"popen":   ioPopen,
+	"read":    ioRead,
+	"tmpfile": ioTmpFile,
+	"type":    ioType,
+	"write":   ioWrite,
+}
+
+// 文件句柄的方法和元方法
+var fileFuncs = map[string]GoFunction{
+	"close":      fileClose,
+	"flush":      fileFlush,
+	"lines":      fileLines,
+	"read":       fileRead,
+	"seek":       fileSeek,
+	"setvbuf":    fileSetvbuf,
+	"write":      fileWrite,
+	"__gc":       fileGC,
+	"__close":    fileGC,
+	"__tostring": fileToString,
+}
+
+// 合法的打开模式
+// lua-5.3.4/src/liolib.c#l_checkmode()
+var reMode = regexp.MustCompile(`^[rwa]\+?b*$`)
+
+// lua-5.3.4/src/liolib.c#luaopen_io()
+func OpenIOLib(ls LuaState) int {
+	ls.NewLib(ioFuncs) /* new module */
+	createFileMeta(ls)
+	/* create (and set) default files */
+	createStdFile(ls, os.Stdin, _IO_INPUT, "stdin")
+	createStdFile(ls, os.Stdout, _IO_OUTPUT, "stdout")
+	createStdFile(ls, os.Stderr, "", "stderr")
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#createmeta()
+func createFileMeta(ls LuaState) {
+	ls.NewMetatable(_LUA_FILEHANDLE) /* create metatable for file handles */
+	ls.PushValue(-1)                 /* push metatable */
+	ls.SetField(-2, "__index")       /* metatable.__index = metatable */
+	ls.SetFuncs(fileFuncs, 0)        /* add file methods to new metatable */
+	ls.Pop(1)                        /* pop new metatable */
+}
+
+// lua-5.3.4/src/liolib.c#createstdfile()
+func createStdFile(ls LuaState, f *os.File, k, fname string) {
+	p := newFile(ls, f)
+	p.closef = ioNoClose
+	if k != "" {
+		ls.PushValue(-1)
+		ls.SetField(LUA_REGISTRYINDEX, k) /* add file to registry */
+	}
+	ls.SetField(-2, fname) /* add file to module */
+}
+
+// 创建文件句柄（带有元表的用户数据），推入栈顶
+// lua-5.3.4/src/liolib.c#newprefile()
+func newFile(ls LuaState, f *os.File) *luaStream {
+	p := newLuaStream(f)
+	ls.PushUserData(p)
+	ls.SetMetatable2(_LUA_FILEHANDLE)
+	return p
+}
+
+func toStream(ls LuaState) *luaStream {
+	return ls.CheckUData(1, _LUA_FILEHANDLE).(*luaStream)
+}
+
+// lua-5.3.4/src/liolib.c#tofile()
+func toFile(ls LuaState) *luaStream {
+	p := toStream(ls)
+	if p.isClosed() {
+		ls.Error2("attempt to use a closed file")
+	}
+	return p
+}
+
+// 成功时返回true，失败时返回nil、错误信息和错误码
+// lua-5.3.4/src/lauxlib.c#luaL_fileresult()
+func fileResult(ls LuaState, err error, fname string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+	ls.PushNil()
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	if fname != "" {
+		ls.PushFString("%s: %s", fname, err.Error())
+	} else {
+		ls.PushString(err.Error())
+	}
+	var en syscall.Errno
+	if errors.As(err, &en) {
+		ls.PushInteger(int64(en))
+	} else {
+		ls.PushInteger(0)
+	}
+	return 3
+}
+
+func openFile(filename, mode string) (*os.File, error) {
+	flag := 0
+	switch mode[0] {
+	case 'r':
+		flag = os.O_RDONLY
+	case 'w':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case 'a':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	if len(mode) > 1 && mode[1] == '+' {
+		flag = flag&^os.O_WRONLY | os.O_RDWR
+	}
+	return os.OpenFile(filename, flag, 0666)
+}
+
+// lua-5.3.4/src/liolib.c#opencheckfile()
+func openCheckFile(ls LuaState, fname, mode string) {
+	f, err := openFile(fname, mode)
+	if err != nil {
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			err = pe.Err
+		}
+		ls.Error2("cannot open file '%s' (%s)", fname, err.Error())
+	}
+	newFile(ls, f).closef = ioFClose
+}
+
+// lua-5.3.4/src/liolib.c#aux_close()
+func auxClose(ls LuaState) int {
+	p := toStream(ls)
+	cf := p.closef
+	p.closef = nil /* mark stream as closed */
+	return cf(ls)  /* close it */
+}
+
+// 普通文件的关闭函数
+// lua-5.3.4/src/liolib.c#io_fclose()
+func ioFClose(ls LuaState) int {
+	p := toStream(ls)
+	return fileResult(ls, p.close(), "")
+}
+
+// 标准文件不能被关闭
+// lua-5.3.4/src/liolib.c#io_noclose()
+func ioNoClose(ls LuaState) int {
+	p := toStream(ls)
+	p.closef = ioNoClose /* keep file opened */
+	ls.PushNil()
+	ls.PushString("cannot close standard file")
+	return 2
+}
+
+// lua-5.3.4/src/liolib.c#getiofile()
+func getIOFile(ls LuaState, findex string) *luaStream {
+	ls.GetField(LUA_REGISTRYINDEX, findex)
+	p := ls.ToUserData(-1).(*luaStream)
+	if p.isClosed() {
+		ls.Error2("standard %s file is closed", findex[len(_IO_PREFIX):])
+	}
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#g_iofile()
+func gIOFile(ls LuaState, f, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if filename, ok := ls.ToStringX(1); ok {
+			openCheckFile(ls, filename, mode)
+		} else {
+			toFile(ls) /* check that it's a valid file handle */
+			ls.PushValue(1)
+		}
+		ls.SetField(LUA_REGISTRYINDEX, f)
+	}
+	/* return current value */
+	ls.GetField(LUA_REGISTRYINDEX, f)
+	return 1
+}
+
+// io.close ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.close
+// lua-5.3.4/src/liolib.c#io_close()
+func ioClose(ls LuaState) int {
+	if ls.IsNone(1) { /* no argument? */
+		ls.GetField(LUA_REGISTRYINDEX, _IO_OUTPUT) /* use standard output */
+	}
+	return fileClose(ls)
+}
+
+// io.flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.flush
+func ioFlush(ls LuaState) int {
+	return fileResult(ls, getIOFile(ls, _IO_OUTPUT).flush(), "")
+}
+
+// io.input ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.input
+func ioInput(ls LuaState) int {
+	return gIOFile(ls, _IO_INPUT, "r")
+}
+
+// io.output ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.output
+func ioOutput(ls LuaState) int {
+	return gIOFile(ls, _IO_OUTPUT, "w")
+}
+
+// io.lines ([filename, ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.lines
+// lua-5.3.4/src/liolib.c#io_lines()
+func ioLines(ls LuaState) int {
+	toClose := false
+	if ls.IsNone(1) {
+		ls.PushNil() /* at least one argument */
+	}
+	if ls.IsNil(1) { /* no file name? */
+		ls.GetField(LUA_REGISTRYINDEX, _IO_INPUT) /* get default input */
+		ls.Replace(1)                             /* put it at index 1 */
+		toFile(ls)                                /* check that it's a valid file handle */
+	} else { /* open a new file */
+		filename := ls.CheckString(1)
+		openCheckFile(ls, filename, "r")
+		ls.Replace(1)  /* put file at index 1 */
+		toClose = true /* close it after iteration */
+	}
+	auxLines(ls, toClose)
+	return 1
+}
+
+// io.open (filename [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.open
+// lua-5.3.4/src/liolib.c#io_open()
+func ioOpen(ls LuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	ls.ArgCheck(reMode.MatchString(mode), 2, "invalid mode")
+	f, err := openFile(filename, mode)
+	if err != nil {
+		return fileResult(ls, err, filename)
+	}
+	newFile(ls, f).closef = ioFClose
+	return 1
+}
+
+// io.popen (prog [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.popen
+func ioPopen(ls LuaState) int {
+	ls.CheckString(1)
+	return ls.Error2("'popen' not supported")
+}
+
+// io.read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.read
+func ioRead(ls LuaState) int {
+	return gRead(ls, getIOFile(ls, _IO_INPUT), 1)
+}
+
+// io.tmpfile ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.tmpfile
+// 临时文件创建之后立即删除，关闭之后就不复存在
+func ioTmpFile(ls LuaState) int {
+	f, err := os.CreateTemp("", "lua_")
+	if err != nil {
+		return fileResult(ls, err, "")
+	}
+	os.Remove(f.Name())
+	newFile(ls, f).closef = ioFClose
+	return 1
+}
+
+// io.type (obj)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.type
+// lua-5.3.4/src/liolib.c#io_type()
+func ioType(ls LuaState) int {
+	ls.CheckAny(1)
+	if p, ok := ls.TestUData(1, _LUA_FILEHANDLE).(*luaStream); !ok {
+		ls.PushNil() /* not a file */
+	} else if p.isClosed() {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+// io.write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.write
+func ioWrite(ls LuaState) int {
+	return gWrite(ls, getIOFile(ls, _IO_OUTPUT), 1)
+}
+
+// file:close ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:close
+func fileClose(ls LuaState) int {
+	toFile(ls) /* make sure argument is an open stream */
+	return auxClose(ls)
+}
+
+// file:flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:flush
+func fileFlush(ls LuaState) int {
+	return fileResult(ls, toFile(ls).flush(), "")
+}
+
+// file:lines (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:lines
+func fileLines(ls LuaState) int {
+	toFile(ls) /* check that it's a valid file handle */
+	auxLines(ls, false)
+	return 1
+}
+
+// file:read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:read
+func fileRead(ls LuaState) int {
+	return gRead(ls, toFile(ls), 2)
+}
+
+// file:seek ([whence [, offset]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:seek
+// lua-5.3.4/src/liolib.c#f_seek()
+func fileSeek(ls LuaState) int {
+	p := toFile(ls)
+	whence := map[string]int{"set": io.SeekStart, "cur": io.SeekCurrent, "end": io.SeekEnd}
+	op, ok := whence[ls.OptString(2, "cur")]
+	if !ok {
+		return ls.ArgError(2, fmt.Sprintf("invalid option '%s'", ls.ToString(2)))
+	}
+	offset := ls.OptInteger(3, 0)
+	pos, err := p.seek(offset, op)
+	if err != nil {
+		return fileResult(ls, err, "") /* error */
+	}
+	ls.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf (mode [, size])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:setvbuf
+// lua-5.3.4/src/liolib.c#f_setvbuf()
+func fileSetvbuf(ls LuaState) int {
+	p := toFile(ls)
+	mode := ls.CheckString(2)
+	if mode != "no" && mode != "full" && mode != "line" {
+		return ls.ArgError(2, fmt.Sprintf("invalid option '%s'", mode))
+	}
+	size := ls.OptInteger(3, _LUAL_BUFFERSIZE)
+	return fileResult(ls, p.setvbuf(mode, int(size)), "")
+}
+
+// file:write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:write
+func fileWrite(ls LuaState) int {
+	p := toFile(ls)
+	ls.PushValue(1) /* push file at the stack top (to be returned) */
+	return gWrite(ls, p, 2)
+}
+
+// lua-5.3.4/src/liolib.c#f_gc()
+func fileGC(ls LuaState) int {
+	if p := toStream(ls); !p.isClosed() {
+		auxClose(ls) /* ignore closed and incompletely open files */
+	}
+	return 0
+}
+
+// lua-5.3.4/src/liolib.c#f_tostring()
+func fileToString(ls LuaState) int {
+	if p := toStream(ls); p.isClosed() {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushFString("file (%p)", p)
+	}
+	return 1
+}
+
+// 返回一个迭代器，每次调用都按照给定的格式读取文件；
+// 格式和文件本身都保存在upvalue里：1是文件，2是格式数量，3表示读完之后是否关闭文件
+// lua-5.3.4/src/liolib.c#aux_lines()
+func auxLines(ls LuaState, toClose bool) {
+	n := ls.GetTop() - 1 /* number of arguments to read */
+	ls.ArgCheck(n <= _MAXARGLINE, _MAXARGLINE+2, "too many arguments")
+	ls.PushInteger(int64(n)) /* number of arguments to read */
+	ls.PushBoolean(toClose)  /* close/not close file when finished */
+	ls.Rotate(2, 2)          /* move 'n' and 'toClose' to their positions */
+	ls.PushGoClosure(ioReadLine, 3+n)
+}
+
+// lua-5.3.4/src/liolib.c#io_readline()
+func ioReadLine(ls LuaState) int {
+	p := ls.ToUserData(LuaUpvalueIndex(1)).(*luaStream)
+	n := int(ls.ToInteger(LuaUpvalueIndex(2)))
+	if p.isClosed() { /* file is already closed? */
+		return ls.Error2("file is already closed")
+	}
+	ls.SetTop(1)
+	ls.CheckStack2(n, "too many arguments")
+	for i := 1; i <= n; i++ { /* push arguments to 'gRead' */
+		ls.PushValue(LuaUpvalueIndex(3 + i))
+	}
+	n = gRead(ls, p, 2)   /* 'n' is number of results */
+	if ls.ToBoolean(-n) { /* read at least one value? */
+		return n /* return them */
+	}
+	/* first result is nil: EOF or error */
+	if n > 1 { /* is there error information? */
+		/* 2nd result is error message */
+		return ls.Error2("%s", ls.ToString(-n+1))
+	}
+	if ls.ToBoolean(LuaUpvalueIndex(3)) { /* generate error? */
+		ls.SetTop(0)
+		ls.PushValue(LuaUpvalueIndex(1))
+		auxClose(ls) /* close it */
+	}
+	return 0
+}
+
+// lua-5.3.4/src/liolib.c#g_read()
+func gRead(ls LuaState, p *luaStream, first int) int {
+	nargs := ls.GetTop() - 1
+	success := true
+	n := first
+	p.err = nil     /* clearerr */
+	if nargs == 0 { /* no arguments? */
+		success = readLine(ls, p, true)
+		n = first + 1 /* to return 1 result */
+	} else { /* ensure stack space for all results */
+		ls.CheckStack2(nargs+LUA_MINSTACK, "too many arguments")
+		for ; nargs > 0 && success; n++ {
+			nargs--
+			if ls.Type(n) == LUA_TNUMBER {
+				l := ls.CheckInteger(n)
+				if l == 0 {
+					success = testEOF(ls, p)
+				} else {
+					success = readChars(ls, p, l)
+				}
+			} else {
+				format := ls.CheckString(n)
+				if len(format) > 0 && format[0] == '*' {
+					format = format[1:] /* skip optional '*' (for compatibility) */
+				}
+				if format == "" {
+					return ls.ArgError(n, "invalid format")
+				}
+				switch format[0] {
+				case 'n': /* number */
+					success = readNumber(ls, p)
+				case 'l': /* line */
+					success = readLine(ls, p, true)
+				case 'L': /* line with end-of-line */
+					success = readLine(ls, p, false)
+				case 'a': /* file */
+					ls.PushString(p.readAll()) /* read entire file */
+					success = true             /* always success */
+				default:
+					return ls.ArgError(n, "invalid format")
+				}
+			}
+		}
+	}
+	if p.err != nil {
+		return fileResult(ls, p.err, "")
+	}
+	if !success {
+		ls.Pop(1)    /* remove last result */
+		ls.PushNil() /* push nil instead */
+	}
+	return n - first
+}
+
+// lua-5.3.4/src/liolib.c#read_line()
+func readLine(ls LuaState, p *luaStream, chop bool) bool {
+	line, hasNewline := p.readLine()
+	if hasNewline && chop {
+		ls.PushString(line[:len(line)-1])
+	} else {
+		ls.PushString(line)
+	}
+	return hasNewline || len(line) > 0
+}
+
+// lua-5.3.4/src/liolib.c#read_chars()
+func readChars(ls LuaState, p *luaStream, n int64) bool {
+	s := p.readChars(n)
+	ls.PushString(s)
+	return len(s) > 0
+}
+
+// lua-5.3.4/src/liolib.c#test_eof()
+func testEOF(ls LuaState, p *luaStream) bool {
+	ls.PushString("")
+	return !p.atEOF()
+}
+
+// 读取数字时的状态：c是向前看的字符，buff保存已经读到的字符
+// lua-5.3.4/src/liolib.c#RN
+type rn struct {
+	p    *luaStream
+	c    int
+	buff []byte
+	ok   bool
+}
+
+// lua-5.3.4/src/liolib.c#nextc()
+func (r *rn) nextc() bool {
+	if len(r.buff) >= _L_MAXLENNUM { /* buffer overflow? */
+		r.ok = false /* invalidate result */
+		return false /* fail */
+	}
+	r.buff = append(r.buff, byte(r.c)) /* save current char */
+	r.c = r.p.getc()                   /* read next one */
+	return true
+}
+
+// lua-5.3.4/src/liolib.c#test2()
+func (r *rn) test2(set string) bool {
+	if r.c >= 0 && (r.c == int(set[0]) || r.c == int(set[1])) {
+		return r.nextc()
+	}
+	return false
+}
+
+// lua-5.3.4/src/liolib.c#readdigits()
+func (r *rn) readDigits(hex bool) int {
+	count := 0
+	for (hex && isXDigit(r.c) || !hex && r.c >= 0 && isDigit(byte(r.c))) && r.nextc() {
+		count++
+	}
+	return count
+}
+
+// lua-5.3.4/src/liolib.c#read_number()
+func readNumber(ls LuaState, p *luaStream) bool {
+	r := &rn{p: p, ok: true}
+	count := 0
+	hex := false
+	r.c = p.getc()
+	for isSpace(r.c) { /* skip spaces */
+		r.c = p.getc()
+	}
+	r.test2("-+") /* optional signal */
+	if r.test2("00") {
+		if r.test2("xX") {
+			hex = true /* numeral is hexadecimal */
+		} else {
+			count = 1 /* count initial '0' as a valid digit */
+		}
+	}
+	count += r.readDigits(hex) /* integral part */
+	if r.test2("..") {         /* decimal point? */
+		count += r.readDigits(hex) /* fractional part */
+	}
+	if count > 0 {
+		exp := "eE"
+		if hex {
+			exp = "pP"
+		}
+		if r.test2(exp) { /* exponent mark? */
+			r.test2("-+")       /* exponent signal */
+			r.readDigits(false) /* exponent digits */
+		}
+	}
+	p.ungetc(r.c) /* unread look-ahead char */
+	if r.ok && ls.StringToNumber(string(r.buff)) {
+		return true /* ok */
+	}
+	/* invalid format */
+	ls.PushNil() /* "result" to be removed */
+	return false /* read fails */
+}
+
+// lua-5.3.4/src/liolib.c#g_write()
+func gWrite(ls LuaState, p *luaStream, arg int) int {
+	nargs := ls.GetTop() - arg
+	var err error
+	for ; nargs > 0; nargs-- {
+		var s string
+		if ls.Type(arg) == LUA_TNUMBER {
+			if ls.IsInteger(arg) {
+				s = fmt.Sprintf("%d", ls.ToInteger(arg))
+			} else {
+				s = fmt.Sprintf("%.14g", ls.ToNumber(arg))
+			}
+		} else {
+			s = ls.CheckString(arg)
+		}
+		if err == nil {
+			err = p.write([]byte(s))
+		}
+		arg++
+	}
+	if err == nil {
+		return 1 /* file handle already on stack top */
+	}
+	return fileResult(ls, err, "")
+}
+
+// c为-1表示文件结束
+func isXDigit(c int) bool {
+	return c >= 0 && (isDigit(byte(c)) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F')
+}
+
+func isSpace(c int) bool {
+	return c == ' ' || c >= '\t' && c <= '\r'
+}
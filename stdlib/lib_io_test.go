@@ -0,0 +1,72 @@
+package stdlib_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestIO(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "io.txt")
+	runLua(t, fmt.Sprintf(`
+		local name = %q
+		local f = assert(io.open(name, "w"))
+		assert(io.type(f) == "file" and tostring(f):find("^file %%("))
+		assert(f:write("line1\n", 42, " ", 3.5, "\n", "0x10 -7.5e1 nan\n", "last") == f)
+		assert(f:close())
+		assert(io.type(f) == "closed file" and tostring(f) == "file (closed)")
+		assert(not pcall(f.read, f))
+
+		f = assert(io.open(name))
+		assert(f:read() == "line1")
+		local a, b = f:read("n", "n")
+		assert(a == 42 and math.type(a) == "integer" and b == 3.5)
+		assert(f:read("L") == "\n")
+		local x, y, z = f:read("n", "*n", "l")
+		assert(x == 16 and y == -75 and z == " nan")
+		assert(f:read(2) == "la" and f:read(0) == "" and f:read("a") == "st")
+		assert(f:read(0) == nil and f:read("a") == "" and f:read("l") == nil)
+		assert(f:seek("set", 2) == 2 and f:read(3) == "ne1" and f:seek() == 5)
+		assert(f:seek("end") == 33)
+		assert(not pcall(f.seek, f, "bad"))
+		f:close()
+
+		local lines = {}
+		for l in io.lines(name) do lines[#lines+1] = l end
+		assert(#lines == 4 and lines[4] == "last")
+		lines = {}
+		for c, rest in io.lines(name, 1, "l") do lines[#lines+1] = c .. "|" .. rest end
+		assert(lines[2] == "4|2 3.5")
+
+		local g = assert(io.open(name, "a+"))
+		g:write("\nappended")
+		g:seek("set")
+		assert(select(2, g:read("a"):gsub("\n", "")) == 4)
+		g:close()
+
+		local nf, msg, code = io.open(name .. ".missing")
+		assert(nf == nil and msg:find("io.txt.missing: ") and code > 0)
+		assert(not pcall(io.open, name, "rw"))
+		assert(not pcall(io.lines, name .. ".missing"))
+
+		local tmp = io.tmpfile()
+		assert(tmp:setvbuf("full"))
+		tmp:write("tmp")
+		tmp:seek("set")
+		assert(tmp:read("a") == "tmp")
+		tmp:close()
+
+		io.output(name)
+		io.write("via ", "output")
+		io.close()
+		io.output(io.stdout)
+		io.input(name)
+		assert(io.read("a") == "via output")
+		io.input():close()
+		assert(not pcall(io.read))
+		io.input(io.stdin)
+
+		assert(io.stdout:close() == nil)
+		assert(io.type(io.stderr) == "file" and io.type(42) == nil)
+	`, name))
+}
@@ -1,10 +1,13 @@
 package stdlib
 
 import (
+	"encoding/binary"
 	"fmt"
+	"math"
 	"strings"
 
 	. "luago/api"
+	"luago/pattern"
 )
 
 var strLib = map[string]GoFunction{
@@ -63,16 +66,21 @@ func strRep(ls LuaState) int {
 	n := ls.CheckInteger(2)
 	sep := ls.OptString(3, "")
 
-	if n <= 0 {
+	if l, lsep := int64(len(s)), int64(len(sep)); n <= 0 || l+lsep == 0 {
 		ls.PushString("")
-	} else if n == 1 {
-		ls.PushString(s)
+	} else if l+lsep > _MAXSIZE/n {
+		return ls.Error2("resulting string too large")
 	} else {
-		a := make([]string, n)
-		for i := 0; i < int(n); i++ {
-			a[i] = s
+		totalSize := int(n*l + (n-1)*lsep)
+		ls.CheckMemory(totalSize) /* 先检查内存上限，再分配结果 */
+		var b strings.Builder
+		b.Grow(totalSize)
+		for ; n > 1; n-- { /* first n-1 copies (followed by separator) */
+			b.WriteString(s)
+			b.WriteString(sep)
 		}
-		ls.PushString(strings.Join(a, sep))
+		b.WriteString(s) /* last copy (not followed by separator) */
+		ls.PushString(b.String())
 	}
 
 	return 1
@@ -191,38 +199,161 @@ func strChar(ls LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.dump
 // lua-5.3.4/src/lstrlib.c#str_dump()
 func strDump(ls LuaState) int {
-	// strip := ls.ToBoolean(2)
-	// ls.CheckType(1, LUA_TFUNCTION)
-	// ls.SetTop(1)
-	// ls.PushString(string(ls.Dump(strip)))
-	// return 1
-	panic("todo: strDump!")
+	strip := ls.ToBoolean(2)
+	ls.CheckType(1, LUA_TFUNCTION)
+	ls.SetTop(1)
+	chunk := ls.Dump(strip)
+	if chunk == nil {
+		return ls.Error2("unable to dump given function")
+	}
+	ls.PushString(string(chunk))
+	return 1
 }
 
 /* PACK/UNPACK */
 
 // string.packsize (fmt)
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.packsize
+// lua-5.3.4/src/lstrlib.c#str_packsize()
 func strPackSize(ls LuaState) int {
-	fmt := ls.CheckString(1)
-	if fmt == "j" {
-		ls.PushInteger(8) // todo
-	} else {
-		panic("todo: strPackSize!")
+	h := newPackHeader(ls, ls.CheckString(1))
+	totalSize := 0 /* accumulate total size of result */
+	for h.fmt != "" {
+		opt, size, ntoalign := h.getDetails(totalSize)
+		size += ntoalign /* total space used by option */
+		ls.ArgCheck(totalSize <= _MAXSIZE-size, 1, "format result too large")
+		totalSize += size
+		if opt == _Kstring || opt == _Kzstr {
+			ls.ArgError(1, "variable-length format")
+		}
 	}
+	ls.PushInteger(int64(totalSize))
 	return 1
 }
 
 // string.pack (fmt, v1, v2, ···)
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.pack
+// lua-5.3.4/src/lstrlib.c#str_pack()
 func strPack(ls LuaState) int {
-	panic("todo: strPack!")
+	h := newPackHeader(ls, ls.CheckString(1))
+	var buf strings.Builder
+	arg := 1       /* current argument to pack */
+	totalSize := 0 /* accumulate total size of result */
+	for h.fmt != "" {
+		opt, size, ntoalign := h.getDetails(totalSize)
+		totalSize += ntoalign + size
+		for ; ntoalign > 0; ntoalign-- {
+			buf.WriteByte(_PACKPADBYTE) /* fill alignment */
+		}
+		arg++
+		switch opt {
+		case _Kint: /* signed integers */
+			n := ls.CheckInteger(arg)
+			if size < _PACK_SZINT { /* need overflow check? */
+				lim := int64(1) << (size*_PACK_NB - 1)
+				ls.ArgCheck(-lim <= n && n < lim, arg, "integer overflow")
+			}
+			packInt(&buf, uint64(n), h.isLittle, size, n < 0)
+		case _Kuint: /* unsigned integers */
+			n := ls.CheckInteger(arg)
+			if size < _PACK_SZINT { /* need overflow check? */
+				ls.ArgCheck(uint64(n) < uint64(1)<<(size*_PACK_NB), arg, "unsigned overflow")
+			}
+			packInt(&buf, uint64(n), h.isLittle, size, false)
+		case _Kfloat: /* floating-point options */
+			n := ls.CheckNumber(arg)
+			b := make([]byte, size)
+			if size == 4 {
+				binary.LittleEndian.PutUint32(b, math.Float32bits(float32(n)))
+			} else {
+				binary.LittleEndian.PutUint64(b, math.Float64bits(n))
+			}
+			copyWithEndian(b, append([]byte(nil), b...), h.isLittle)
+			buf.Write(b)
+		case _Kchar: /* fixed-size string */
+			s := ls.CheckString(arg)
+			ls.ArgCheck(len(s) <= size, arg, "string longer than given size")
+			_prepBuffer(ls, &buf, size)
+			buf.WriteString(s)               /* add string */
+			for i := len(s); i < size; i++ { /* pad extra space */
+				buf.WriteByte(_PACKPADBYTE)
+			}
+		case _Kstring: /* strings with length count */
+			s := ls.CheckString(arg)
+			ls.ArgCheck(size >= 8 || uint64(len(s)) < uint64(1)<<(size*_PACK_NB),
+				arg, "string length does not fit in given size")
+			_prepBuffer(ls, &buf, size+len(s))
+			packInt(&buf, uint64(len(s)), h.isLittle, size, false) /* pack length */
+			buf.WriteString(s)
+			totalSize += len(s)
+		case _Kzstr: /* zero-terminated string */
+			s := ls.CheckString(arg)
+			ls.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+			_prepBuffer(ls, &buf, len(s)+1)
+			buf.WriteString(s)
+			buf.WriteByte(0) /* add zero at the end */
+			totalSize += len(s) + 1
+		case _Kpadding:
+			buf.WriteByte(_PACKPADBYTE)
+			arg-- /* undo increment */
+		case _Kpaddalign, _Knop:
+			arg-- /* undo increment */
+		}
+	}
+	ls.PushString(buf.String())
+	return 1
 }
 
 // string.unpack (fmt, s [, pos])
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.unpack
+// lua-5.3.4/src/lstrlib.c#str_unpack()
 func strUnpack(ls LuaState) int {
-	panic("todo: strUnpack!")
+	h := newPackHeader(ls, ls.CheckString(1))
+	data := ls.CheckString(2)
+	ld := len(data)
+	pos := posRelat(ls.OptInteger(3, 1), ld) - 1
+	n := 0 /* number of results */
+	ls.ArgCheck(pos >= 0 && pos <= ld, 3, "initial position out of string")
+	for h.fmt != "" {
+		opt, size, ntoalign := h.getDetails(pos)
+		if pos+ntoalign+size > ld {
+			ls.ArgError(2, "data string too short")
+		}
+		pos += ntoalign /* skip alignment */
+		/* stack space for item + next position */
+		ls.CheckStack2(2, "too many results")
+		n++
+		switch opt {
+		case _Kint, _Kuint:
+			res := unpackInt(ls, data[pos:], h.isLittle, size, opt == _Kint)
+			ls.PushInteger(res)
+		case _Kfloat:
+			b := make([]byte, size)
+			copyWithEndian(b, []byte(data[pos:pos+size]), h.isLittle)
+			if size == 4 {
+				ls.PushNumber(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+			} else {
+				ls.PushNumber(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+			}
+		case _Kchar:
+			ls.PushString(data[pos : pos+size])
+		case _Kstring:
+			l := uint64(unpackInt(ls, data[pos:], h.isLittle, size, false))
+			ls.ArgCheck(l <= uint64(ld-pos-size), 2, "data string too short")
+			ls.PushString(data[pos+size : pos+size+int(l)])
+			pos += int(l) /* skip string */
+		case _Kzstr:
+			l := strings.IndexByte(data[pos:], 0)
+			ls.ArgCheck(l >= 0, 2, "unfinished string for format 'z'")
+			ls.PushString(data[pos : pos+l])
+			pos += l + 1 /* skip string plus final '\0' */
+		case _Kpaddalign, _Kpadding, _Knop:
+			n-- /* undo increment */
+		}
+		pos += size
+	}
+	ls.PushInteger(int64(pos + 1)) /* next position */
+	return n + 1
 }
 
 /* STRING FORMAT */
@@ -237,6 +368,7 @@ func strFormat(ls LuaState) int {
 	}
 
 	argIdx := 1
+	size := 0
 	arr := parseFmtStr(fmtStr)
 	for i, s := range arr {
 		if s[0] == '%' {
@@ -247,8 +379,10 @@ func strFormat(ls LuaState) int {
 				arr[i] = _fmtArg(s, ls, argIdx)
 			}
 		}
+		size += len(arr[i])
 	}
 
+	ls.CheckMemory(size) /* 先检查内存上限，再拼接结果 */
 	ls.PushString(strings.Join(arr, ""))
 	return 1
 }
@@ -281,97 +415,244 @@ func _fmtArg(tag string, ls LuaState, argIdx int) string {
 // string.find (s, pattern [, init [, plain]])
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.find
 func strFind(ls LuaState) int {
-	s := ls.CheckString(1)
-	sLen := len(s)
-	pattern := ls.CheckString(2)
-	init := posRelat(ls.OptInteger(3, 1), sLen)
-	if init < 1 {
-		init = 1
-	} else if init > sLen+1 { /* start after string's end? */
-		ls.PushNil()
-		return 1
-	}
-	plain := ls.ToBoolean(4)
-
-	start, end := find(s, pattern, init, plain)
-
-	if start < 0 {
-		ls.PushNil()
-		return 1
-	}
-	ls.PushInteger(int64(start))
-	ls.PushInteger(int64(end))
-	return 2
+	return _strFindAux(ls, true)
 }
 
 // string.match (s, pattern [, init])
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.match
 func strMatch(ls LuaState) int {
+	return _strFindAux(ls, false)
+}
+
+// lua-5.3.4/src/lstrlib.c#str_find_aux()
+func _strFindAux(ls LuaState, find bool) int {
 	s := ls.CheckString(1)
 	sLen := len(s)
-	pattern := ls.CheckString(2)
+	p := ls.CheckString(2)
 	init := posRelat(ls.OptInteger(3, 1), sLen)
 	if init < 1 {
 		init = 1
 	} else if init > sLen+1 { /* start after string's end? */
-		ls.PushNil()
+		ls.PushNil() /* cannot find anything */
 		return 1
 	}
 
-	captures := match(s, pattern, init)
-
-	if captures == nil {
-		ls.PushNil()
-		return 1
+	/* explicit request or no special characters? */
+	if find && (ls.ToBoolean(4) || _noSpecials(p)) {
+		/* do a plain search */
+		if idx := strings.Index(s[init-1:], p); idx >= 0 {
+			ls.PushInteger(int64(init + idx))
+			ls.PushInteger(int64(init + idx + len(p) - 1))
+			return 2
+		}
 	} else {
-		for i := 0; i < len(captures); i += 2 {
-			capture := s[captures[i]:captures[i+1]]
-			ls.PushString(capture)
+		pat := _checkPattern(ls, p)
+		m, err := pat.Find(s, init-1)
+		if err != nil {
+			return ls.Error2("%s", err.Error())
+		}
+		if m != nil {
+			if find {
+				ls.PushInteger(int64(m.Start + 1)) /* start */
+				ls.PushInteger(int64(m.End))       /* end */
+				return _pushCaptures(ls, s, m, false) + 2
+			}
+			return _pushCaptures(ls, s, m, true)
 		}
-		return len(captures) / 2
 	}
+	ls.PushNil() /* not found */
+	return 1
+}
+
+// string.gmatch (s, pattern)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.gmatch
+// lua-5.3.4/src/lstrlib.c#gmatch()
+func strGmatch(ls LuaState) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	if p != "" && p[0] == '^' { /* gmatch does not anchor: '^' is an ordinary char here */
+		p = "%" + p
+	}
+	pat := _checkPattern(ls, p)
+
+	src, lastMatch := 0, -1
+	gmatchAux := func(ls LuaState) int {
+		for ; src <= len(s); src++ {
+			m, err := pat.MatchAt(s, src)
+			if err != nil {
+				return ls.Error2("%s", err.Error())
+			}
+			if m != nil && m.End != lastMatch {
+				src, lastMatch = m.End, m.End
+				return _pushCaptures(ls, s, m, true)
+			}
+		}
+		return 0 /* not found */
+	}
+
+	ls.PushGoFunction(gmatchAux)
+	return 1
 }
 
 // string.gsub (s, pattern, repl [, n])
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.gsub
+// lua-5.3.4/src/lstrlib.c#str_gsub()
 func strGsub(ls LuaState) int {
 	s := ls.CheckString(1)
-	pattern := ls.CheckString(2)
-	repl := ls.CheckString(3) // todo
-	n := int(ls.OptInteger(4, -1))
-
-	newStr, nMatches := gsub(s, pattern, repl, n)
-	ls.PushString(newStr)
-	ls.PushInteger(int64(nMatches))
+	p := ls.CheckString(2)
+	tr := ls.Type(3) /* replacement type */
+	maxS := ls.OptInteger(4, int64(len(s)+1))
+	ls.ArgCheck(tr == LUA_TNUMBER || tr == LUA_TSTRING ||
+		tr == LUA_TFUNCTION || tr == LUA_TTABLE, 3,
+		"string/function/table expected")
+	pat := _checkPattern(ls, p)
+
+	var buf strings.Builder
+	src, lastMatch := 0, -1
+	n := int64(0)
+	for n < maxS {
+		m, err := pat.MatchAt(s, src)
+		if err != nil {
+			return ls.Error2("%s", err.Error())
+		}
+		if m != nil && m.End != lastMatch { /* match? */
+			n++
+			_addValue(ls, &buf, s, m, tr) /* add replacement to buffer */
+			src, lastMatch = m.End, m.End
+		} else if src < len(s) { /* otherwise, skip one character */
+			_prepBuffer(ls, &buf, 1)
+			buf.WriteByte(s[src])
+			src++
+		} else {
+			break /* end of subject */
+		}
+		if pat.Anchored() {
+			break
+		}
+	}
+	_prepBuffer(ls, &buf, len(s)-src)
+	buf.WriteString(s[src:])
+	ls.PushString(buf.String())
+	ls.PushInteger(n) /* number of substitutions */
 	return 2
 }
 
-// string.gmatch (s, pattern)
-// http://www.lua.org/manual/5.3/manual.html#pdf-string.gmatch
-func strGmatch(ls LuaState) int {
-	s := ls.CheckString(1)
-	pattern := ls.CheckString(2)
+// lua-5.3.4/src/lstrlib.c#add_value()
+func _addValue(ls LuaState, buf *strings.Builder, s string, m *pattern.Match, tr LuaType) {
+	switch tr {
+	case LUA_TFUNCTION:
+		ls.PushValue(3)
+		n := _pushCaptures(ls, s, m, true)
+		ls.Call(n, 1) /* call it */
+	case LUA_TTABLE:
+		_pushOneCapture(ls, s, m, 0)
+		ls.GetTable(3)
+	default: /* LUA_TNUMBER or LUA_TSTRING */
+		_addString(ls, buf, s, m)
+		return
+	}
+	if !ls.ToBoolean(-1) { /* nil or false? */
+		ls.Pop(1)
+		_prepBuffer(ls, buf, m.End-m.Start)
+		buf.WriteString(s[m.Start:m.End]) /* keep original text */
+		return
+	} else if !ls.IsString(-1) {
+		ls.Error2("invalid replacement value (a %s)", ls.TypeName2(-1))
+	}
+	repl := ls.ToString(-1)
+	_prepBuffer(ls, buf, len(repl))
+	buf.WriteString(repl) /* add result to accumulator */
+	ls.Pop(1)
+}
 
-	gmatchAux := func(ls LuaState) int {
-		captures := match(s, pattern, 1)
-		if captures != nil {
-			for i := 0; i < len(captures); i += 2 {
-				capture := s[captures[i]:captures[i+1]]
-				ls.PushString(capture)
+// lua-5.3.4/src/lstrlib.c#add_s()
+func _addString(ls LuaState, buf *strings.Builder, s string, m *pattern.Match) {
+	repl := ls.ToString(3)
+	for i := 0; i < len(repl); i++ {
+		if repl[i] != '%' {
+			_prepBuffer(ls, buf, 1)
+			buf.WriteByte(repl[i])
+			continue
+		}
+		i++ /* skip ESC */
+		if i >= len(repl) || !isDigit(repl[i]) {
+			if i >= len(repl) || repl[i] != '%' {
+				ls.Error2("invalid use of '%%' in replacement string")
 			}
-			s = s[captures[len(captures)-1]:]
-			return len(captures) / 2
+			_prepBuffer(ls, buf, 1)
+			buf.WriteByte('%')
+		} else if repl[i] == '0' {
+			_prepBuffer(ls, buf, m.End-m.Start)
+			buf.WriteString(s[m.Start:m.End])
 		} else {
-			return 0
+			_pushOneCapture(ls, s, m, int(repl[i]-'1'))
+			capture := ls.ToString2(-1)
+			_prepBuffer(ls, buf, len(capture))
+			buf.WriteString(capture) /* add capture to accumulated result */
+			ls.Pop(2)
 		}
 	}
+}
 
-	ls.PushGoFunction(gmatchAux)
-	return 1
+// 缓冲区再写入n字节之前调用：空间不够时先按照扩容之后的大小检查内存上限，再扩容
+// lua-5.3.4/src/lauxlib.c#luaL_prepbuffsize()
+func _prepBuffer(ls LuaState, buf *strings.Builder, n int) {
+	if buf.Cap()-buf.Len() < n { /* not enough space? */
+		newSize := buf.Cap() * 2   /* double buffer size */
+		if newSize-buf.Len() < n { /* not big enough? */
+			newSize = buf.Len() + n
+		}
+		ls.CheckMemory(newSize)
+		buf.Grow(newSize - buf.Len())
+	}
+}
+
+// 模式里没有特殊字符时可以直接做普通查找
+// lua-5.3.4/src/lstrlib.c#nospecials()
+func _noSpecials(p string) bool {
+	return !strings.ContainsAny(p, pattern.SPECIALS)
+}
+
+func _checkPattern(ls LuaState, p string) *pattern.Pattern {
+	pat, err := pattern.Cached(p)
+	if err != nil {
+		ls.Error2("%s", err.Error())
+	}
+	return pat
+}
+
+// lua-5.3.4/src/lstrlib.c#push_onecapture()
+func _pushOneCapture(ls LuaState, s string, m *pattern.Match, i int) {
+	if i >= m.NumCaptures() && i != 0 {
+		ls.Error2("invalid capture index %%%d", i+1)
+	}
+	str, pos, isPos := m.Capture(s, i)
+	if isPos {
+		ls.PushInteger(int64(pos))
+	} else {
+		ls.PushString(str)
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#push_captures()
+func _pushCaptures(ls LuaState, s string, m *pattern.Match, wholeIfNone bool) int {
+	nLevels := m.NumCaptures()
+	if nLevels == 0 && wholeIfNone {
+		nLevels = 1
+	}
+	ls.CheckStack2(nLevels, "too many captures")
+	for i := 0; i < nLevels; i++ {
+		_pushOneCapture(ls, s, m, i)
+	}
+	return nLevels /* number of strings pushed */
 }
 
 /* helper */
 
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
 /* translate a relative string position: negative means back from end */
 func posRelat(pos int64, _len int) int {
 	_pos := int(pos)
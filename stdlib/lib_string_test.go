@@ -0,0 +1,174 @@
+package stdlib_test
+
+import (
+	"testing"
+
+	. "luago/api"
+	"luago/state"
+)
+
+func runLua(t *testing.T, code string) {
+	t.Helper()
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.LoadString(code) != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+// 参考手册 §6.4 中 string.find/match/gmatch/gsub 的示例
+func TestStringPatterns(t *testing.T) {
+	runLua(t, `
+		assert(string.find("hello Lua user", "Lua") == 7)
+		assert(string.find("hello Lua user", "l+") == 3)
+		assert(string.find("a+b", "+", 1, true) == 2)
+		local s, e = string.find("a%b", "%", 1, true) -- 普通查找不检查模式
+		assert(s == 2 and e == 2)
+		local s, e, c = string.find("key = value", "(%w+)")
+		assert(s == 1 and e == 3 and c == "key")
+		assert(string.find("abc", "b", -1) == nil)
+		assert(string.match("  x", "^%s*()") == 3)
+		assert(string.match("today is 17/7/1990", "(%d+)/(%d+)/(%d+)") == "17")
+
+		local x = string.gsub("hello world", "(%w+)", "%1 %1")
+		assert(x == "hello hello world world")
+		local x, n = string.gsub("hello world", "%w+", "%0 %0", 1)
+		assert(x == "hello hello world" and n == 1)
+		x = string.gsub("hello world from Lua", "(%w+)%s*(%w+)", "%2 %1")
+		assert(x == "world hello Lua from")
+		x = string.gsub("home = $HOME, user = $USER", "%$(%w+)", function(v)
+			return v == "HOME" and "/home/roberto" or nil
+		end)
+		assert(x == "home = /home/roberto, user = $USER")
+		x = string.gsub("4+5 = $return 4+5$", "%$(.-)%$", function(s) return 9 end)
+		assert(x == "4+5 = 9")
+		local t = {name="lua", version="5.3"}
+		x = string.gsub("$name-$version.tar.gz", "%$(%w+)", t)
+		assert(x == "lua-5.3.tar.gz")
+		x, n = string.gsub("abc", "", "-")
+		assert(x == "-a-b-c-" and n == 4)
+		x, n = string.gsub("abc", "^", "x")
+		assert(x == "xabc" and n == 1)
+		x = string.gsub("hello", "()l", "%1")
+		assert(x == "he34o")
+		assert(not pcall(string.gsub, "alo", ".", "%2"))
+		assert(not pcall(string.gsub, "alo", ".", "%x"))
+		assert(not pcall(string.gsub, "alo", ".", {a = {}}))
+
+		local words = {}
+		for w in string.gmatch("one two  three", "%a+") do words[#words + 1] = w end
+		assert(#words == 3 and words[3] == "three")
+		local kv = {}
+		for k, v in string.gmatch("from=world, to=Lua", "(%w+)=(%w+)") do kv[k] = v end
+		assert(kv.from == "world" and kv.to == "Lua")
+		local n = 0
+		for _ in string.gmatch("abc", "") do n = n + 1 end
+		assert(n == 4)
+		n = 0
+		for c in string.gmatch("añb", utf8.charpattern) do n = n + 1 end
+		assert(n == 3)
+
+		assert(not pcall(string.find, "a", "%"))
+		assert(not pcall(string.match, "a", "[a"))
+	`)
+}
+
+// 期望的字节串来自参考实现（Lua 5.3，x86_64）
+func TestStringPack(t *testing.T) {
+	runLua(t, `
+		local pack, unpack, packsize = string.pack, string.unpack, string.packsize
+		local cases = {
+			{"i4", "\x64\0\0\0", 100},
+			{">i4", "\0\0\0\x64", 100},
+			{"<i2", "\xfe\xff", -2},
+			{">I3", "\1\2\3", 0x010203},
+			{"b", "\xff", -1},
+			{"B", "\xff", 255},
+			{"h", "\x34\x12", 0x1234},
+			{"=H", "\x34\x12", 0x1234},
+			{"j", ("\xff"):rep(8), -1},
+			{"J", "\1\0\0\0\0\0\0\0", 1},
+			{"l", "\0\0\0\0\0\0\0\x80", math.mininteger},
+			{"i16", ("\xff"):rep(16), -1},
+			{">i16", ("\0"):rep(15) .. "\1", 1},
+			{"I9", "\xff\xff\xff\xff\xff\xff\xff\x7f\0", math.maxinteger},
+			{"d", "\0\0\0\0\0\0\xf0\x3f", 1.0},
+			{">f", "\x3f\xc0\0\0", 1.5},
+			{"n", "\0\0\0\0\0\0\xe0\xbf", -0.5},
+			{"z", "hi\0", "hi"},
+			{"s1", "\3abc", "abc"},
+			{">s2", "\0\2ab", "ab"},
+			{"s", "\1\0\0\0\0\0\0\0a", "a"},
+			{"c5", "ab\0\0\0", "ab\0\0\0"},
+		}
+		for _, c in ipairs(cases) do
+			local fmt, bytes, v = c[1], c[2], c[3]
+			local s = pack(fmt, v)
+			assert(s == bytes, fmt)
+			local v2, next = unpack(fmt, s)
+			assert(v2 == v and math.type(v2) == math.type(v), fmt)
+			assert(next == #s + 1, fmt)
+		end
+
+		assert(pack("!4 i1 i4", 1, 2) == "\1\0\0\0\2\0\0\0")
+		assert(pack("!i1 i8", 1, 2) == "\1" .. ("\0"):rep(7) .. "\2" .. ("\0"):rep(7))
+		assert(pack("i1 x i2", 1, 2) == "\1\0\2\0")
+		assert(pack("!4 i1 Xi4 i1", 1, 2) == "\1\0\0\0\2")
+		assert(pack("<i2 >i2", 1, 1) == "\1\0\0\1")
+
+		assert(packsize("i4i8") == 12)
+		assert(packsize("!i4i8") == 16)
+		assert(packsize("!4 i1 Xi4") == 4)
+		assert(packsize("!2 b h") == 4)
+		assert(packsize("c10 d T") == 26)
+
+		local a, b, c, n = unpack("z c2 B", "hi\0ab\7")
+		assert(a == "hi" and b == "ab" and c == 7 and n == 7)
+		assert(unpack("i2", "\0\0\1\0", 3) == 1)
+		assert(unpack("i2", "\0\0\1\0", -2) == 1)
+		assert(unpack("i9", ("\xff"):rep(9)) == -1)
+		assert(select("#", unpack("!4 i1 Xi4 i1", "\1\0\0\0\2")) == 3)
+
+		local function checkerror(msg, f, ...)
+			local ok, err = pcall(f, ...)
+			assert(not ok and string.find(err, msg, 1, true), err)
+		end
+		checkerror("integral size (17) out of limits [1,16]", pack, "i17", 1)
+		checkerror("integral size (0) out of limits [1,16]", pack, "I0", 1)
+		checkerror("integer overflow", pack, "i1", 128)
+		checkerror("integer overflow", pack, "i2", -32769)
+		checkerror("unsigned overflow", pack, "I1", 256)
+		checkerror("format asks for alignment not power of 2", pack, "!3 i1 i4", 1, 2)
+		checkerror("invalid next option for option 'X'", pack, "X")
+		checkerror("invalid next option for option 'X'", pack, "Xc1")
+		checkerror("missing size for format option 'c'", pack, "c", "a")
+		checkerror("invalid format option 'y'", pack, "y")
+		checkerror("string longer than given size", pack, "c2", "abc")
+		checkerror("string length does not fit in given size", pack, "s1", ("x"):rep(256))
+		checkerror("string contains zeros", pack, "z", "a\0b")
+		checkerror("variable-length format", packsize, "s")
+		checkerror("variable-length format", packsize, "z")
+		checkerror("data string too short", unpack, "i4", "abc")
+		checkerror("data string too short", unpack, "s1", "\5abc")
+		checkerror("unfinished string for format 'z'", unpack, "z", "abc")
+		checkerror("initial position out of string", unpack, "i1", "abc", 5)
+		checkerror("9-byte integer does not fit into Lua Integer", unpack, "I9", ("\xff"):rep(9))
+	`)
+}
+
+func TestStringDump(t *testing.T) {
+	runLua(t, `
+		local function f(a, b) return a * b + 1, "k" end
+		for _, strip in ipairs({false, true}) do
+			local s = string.dump(f, strip)
+			assert(s:sub(1, 4) == "\27Lua")
+			local g = load(s)
+			local x, y = g(3, 4)
+			assert(x == 13 and y == "k")
+			assert(string.dump(g, strip) == s)
+		end
+		assert(#string.dump(f, true) < #string.dump(f))
+		local ok, err = pcall(string.dump, print)
+		assert(not ok and string.find(err, "unable to dump given function", 1, true))
+	`)
+}
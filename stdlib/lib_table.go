@@ -125,6 +125,7 @@ func tabConcat(ls LuaState) int {
 	}
 
 	buf := make([]string, j-i+1)
+	size := len(sep) * (len(buf) - 1)
 	for k := i; k > 0 && k <= j; k++ {
 		ls.GetI(1, k)
 		if !ls.IsString(-1) {
@@ -132,8 +133,10 @@ func tabConcat(ls LuaState) int {
 				ls.TypeName2(-1), i)
 		}
 		buf[k-i] = ls.ToString(-1)
+		size += len(buf[k-i])
 		ls.Pop(1)
 	}
+	ls.CheckMemory(size) /* 先检查内存上限，再拼接结果 */
 	ls.PushString(strings.Join(buf, sep))
 
 	return 1
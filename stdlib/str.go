@@ -2,6 +2,7 @@ package stdlib
 
 import "regexp"
 import "strings"
+import . "luago/api"
 
 // tag = %[flags][width][.precision]specifier
 var tagPattern = regexp.MustCompile(`%[ #+-0]?[0-9]*(\.[0-9]+)?[cdeEfgGioqsuxX%]`)
@@ -36,80 +37,223 @@ func parseFmtStr(fmt string) []string {
 	return parsed
 }
 
-func find(s, pattern string, init int, plain bool) (start, end int) {
-	tail := s
-	if init > 1 {
-		tail = s[init-1:]
+/* pack/unpack */
+
+const (
+	_PACK_NB     = 8                   /* number of bits in a character */
+	_PACK_MC     = (1 << _PACK_NB) - 1 /* mask for one character */
+	_PACK_SZINT  = 8                   /* size of a lua_Integer */
+	_MAXINTSIZE  = 16                  /* maximum size for the binary representation of an integer */
+	_MAXALIGN    = 8                   /* maximum alignment */
+	_MAXSIZE     = 1<<31 - 1           /* maximum size of a packed result */
+	_PACKPADBYTE = 0x00                /* value used for padding */
+)
+
+/* options for pack/unpack */
+const (
+	_Kint       = iota /* signed integers */
+	_Kuint             /* unsigned integers */
+	_Kfloat            /* floating-point numbers */
+	_Kchar             /* fixed-length strings */
+	_Kstring           /* strings with prefixed length */
+	_Kzstr             /* zero-terminated strings */
+	_Kpadding          /* padding */
+	_Kpaddalign        /* padding for alignment */
+	_Knop              /* no-op (configuration or spaces) */
+)
+
+/* information to pack/unpack stuff */
+// lua-5.3.4/src/lstrlib.c#Header
+type packHeader struct {
+	ls       LuaState
+	fmt      string /* remaining format */
+	isLittle bool
+	maxAlign int
+}
+
+func newPackHeader(ls LuaState, fmt string) *packHeader {
+	return &packHeader{ls: ls, fmt: fmt, isLittle: true, maxAlign: 1}
+}
+
+/* read an integer numeral from string 'fmt' or return 'df' if there is no numeral */
+func (h *packHeader) getNum(df int) int {
+	if h.fmt == "" || !isDigit(h.fmt[0]) { /* no number? */
+		return df /* return default value */
+	}
+	a := 0
+	for h.fmt != "" && isDigit(h.fmt[0]) && a <= (_MAXSIZE-9)/10 {
+		a = a*10 + int(h.fmt[0]-'0')
+		h.fmt = h.fmt[1:]
 	}
+	return a
+}
 
-	if plain {
-		start = strings.Index(tail, pattern)
-		end = start + len(pattern) - 1
-	} else {
-		re, err := _compile(pattern)
-		if err != "" {
-			panic(err) // todo
+/* read an integer numeral and raise an error if it is larger than the maximum size for integers */
+func (h *packHeader) getNumLimit(df int) int {
+	sz := h.getNum(df)
+	if sz > _MAXINTSIZE || sz <= 0 {
+		h.ls.Error2("integral size (%d) out of limits [1,%d]", sz, _MAXINTSIZE)
+	}
+	return sz
+}
+
+/* read and classify next option. 'size' is filled with option's size */
+// lua-5.3.4/src/lstrlib.c#getoption()
+func (h *packHeader) getOption() (opt, size int) {
+	c := h.fmt[0]
+	h.fmt = h.fmt[1:]
+	switch c {
+	case 'b':
+		return _Kint, 1
+	case 'B':
+		return _Kuint, 1
+	case 'h':
+		return _Kint, 2
+	case 'H':
+		return _Kuint, 2
+	case 'l', 'j':
+		return _Kint, 8
+	case 'L', 'J', 'T':
+		return _Kuint, 8
+	case 'f':
+		return _Kfloat, 4
+	case 'd', 'n':
+		return _Kfloat, 8
+	case 'i':
+		return _Kint, h.getNumLimit(4)
+	case 'I':
+		return _Kuint, h.getNumLimit(4)
+	case 's':
+		return _Kstring, h.getNumLimit(8)
+	case 'c':
+		size = h.getNum(-1)
+		if size == -1 {
+			h.ls.Error2("missing size for format option 'c'")
+		}
+		return _Kchar, size
+	case 'z':
+		return _Kzstr, 0
+	case 'x':
+		return _Kpadding, 1
+	case 'X':
+		return _Kpaddalign, 0
+	case ' ':
+	case '<':
+		h.isLittle = true
+	case '>':
+		h.isLittle = false
+	case '=':
+		h.isLittle = true /* native endianness */
+	case '!':
+		h.maxAlign = h.getNumLimit(_MAXALIGN)
+	default:
+		h.ls.Error2("invalid format option '%c'", c)
+	}
+	return _Knop, 0
+}
+
+/*
+** Read, classify, and fill other details about the next option.
+** 'ntoalign' is filled with the number of bytes needed to align the
+** option at 'totalSize'.
+ */
+// lua-5.3.4/src/lstrlib.c#getdetails()
+func (h *packHeader) getDetails(totalSize int) (opt, size, ntoalign int) {
+	opt, size = h.getOption()
+	align := size           /* usually, alignment follows size */
+	if opt == _Kpaddalign { /* 'X' gets alignment from following option */
+		if h.fmt == "" {
+			h.ls.ArgError(1, "invalid next option for option 'X'")
 		} else {
-			loc := re.FindStringIndex(tail)
-			if loc == nil {
-				start, end = -1, -1
-			} else {
-				start, end = loc[0], loc[1]-1
+			var nextOpt int
+			if nextOpt, align = h.getOption(); nextOpt == _Kchar || align == 0 {
+				h.ls.ArgError(1, "invalid next option for option 'X'")
 			}
 		}
 	}
-	if start >= 0 {
-		start += len(s) - len(tail) + 1
-		end += len(s) - len(tail) + 1
+	if align <= 1 || opt == _Kchar { /* need no alignment? */
+		ntoalign = 0
+	} else {
+		if align > h.maxAlign { /* enforce maximum alignment */
+			align = h.maxAlign
+		}
+		if align&(align-1) != 0 { /* is 'align' not a power of 2? */
+			h.ls.ArgError(1, "format asks for alignment not power of 2")
+		}
+		ntoalign = (align - totalSize&(align-1)) & (align - 1)
 	}
-
 	return
 }
 
-func match(s, pattern string, init int) []int {
-	tail := s
-	if init > 1 {
-		tail = s[init-1:]
-	}
-
-	re, err := _compile(pattern)
-	if err != "" {
-		panic(err) // todo
-	} else {
-		found := re.FindStringSubmatchIndex(tail)
-		if len(found) > 2 {
-			return found[2:]
+/* pack integer 'n' with 'size' bytes and 'isLittle' endianness */
+// lua-5.3.4/src/lstrlib.c#packint()
+func packInt(buf *strings.Builder, n uint64, isLittle bool, size int, neg bool) {
+	b := make([]byte, size)
+	for i := 0; i < size; i++ {
+		var c byte
+		if i < _PACK_SZINT {
+			c = byte(n & _PACK_MC)
+			n >>= _PACK_NB
+		} else if neg { /* sign extension for sizes larger than lua_Integer */
+			c = _PACK_MC
+		}
+		if isLittle {
+			b[i] = c
 		} else {
-			return found
+			b[size-1-i] = c
 		}
 	}
+	buf.Write(b)
 }
 
-// todo
-func gsub(s, pattern, repl string, n int) (string, int) {
-	re, err := _compile(pattern)
-	if err != "" {
-		panic(err) // todo
-	} else {
-		indexes := re.FindAllStringIndex(s, n)
-		if indexes == nil {
-			return s, 0
+/* unpack an integer with 'size' bytes and 'isLittle' endianness */
+// lua-5.3.4/src/lstrlib.c#unpackint()
+func unpackInt(ls LuaState, str string, isLittle bool, size int, isSigned bool) int64 {
+	var res uint64
+	limit := size
+	if limit > _PACK_SZINT {
+		limit = _PACK_SZINT
+	}
+	for i := limit - 1; i >= 0; i-- {
+		res <<= _PACK_NB
+		if isLittle {
+			res |= uint64(str[i])
+		} else {
+			res |= uint64(str[size-1-i])
 		}
-
-		nMatches := len(indexes)
-		lastEnd := indexes[nMatches-1][1]
-		head, tail := s[:lastEnd], s[lastEnd:]
-
-		newHead := re.ReplaceAllString(head, repl)
-		return newHead + tail, nMatches
 	}
+	if size < _PACK_SZINT { /* real size smaller than lua_Integer? */
+		if isSigned { /* needs sign extension? */
+			mask := uint64(1) << (size*_PACK_NB - 1)
+			res = (res ^ mask) - mask /* do sign extension */
+		}
+	} else if size > _PACK_SZINT { /* must check unread bytes */
+		var mask byte
+		if isSigned && int64(res) < 0 {
+			mask = _PACK_MC
+		}
+		for i := limit; i < size; i++ {
+			var c byte
+			if isLittle {
+				c = str[i]
+			} else {
+				c = str[size-1-i]
+			}
+			if c != mask {
+				ls.Error2("%d-byte integer does not fit into Lua Integer", size)
+			}
+		}
+	}
+	return int64(res)
 }
 
-func _compile(pattern string) (*regexp.Regexp, string) {
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return nil, err.Error() // todo
+/* copy 'size' bytes of the native (little endian) representation with the requested endianness */
+func copyWithEndian(dest, src []byte, isLittle bool) {
+	if isLittle {
+		copy(dest, src)
 	} else {
-		return re, ""
+		for i, n := 0, len(src); i < n; i++ {
+			dest[i] = src[n-1-i]
+		}
 	}
 }
@@ -0,0 +1,168 @@
+package stdlib
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+
+	. "luago/api"
+)
+
+// 文件句柄，对应C语言的FILE*
+// lua-5.3.4/src/lauxlib.h#luaL_Stream
+type luaStream struct {
+	f      *os.File
+	r      *bufio.Reader // 读缓冲
+	w      *bufio.Writer // 写缓冲，只有调用setvbuf("full")或者setvbuf("line")之后才有
+	line   bool          // 行缓冲：写入换行符时刷新
+	err    error         // 最近一次读写错误，相当于ferror()
+	closef GoFunction    // 关闭函数，nil表示文件已经关闭
+}
+
+// 标准输入的读缓冲。io.stdin、解释器的交互模式和加载标准输入的LoadFile共用它，
+// 一方预读的内容另一方还能读到（C语言里它们共用同一个FILE*）
+var Stdin = bufio.NewReader(os.Stdin)
+
+func newLuaStream(f *os.File) *luaStream {
+	if f == os.Stdin {
+		return &luaStream{f: f, r: Stdin}
+	}
+	return &luaStream{f: f, r: bufio.NewReader(f)}
+}
+
+func (s *luaStream) isClosed() bool {
+	return s.closef == nil
+}
+
+// 返回下一个字节，文件结束或者出错时返回-1
+func (s *luaStream) getc() int {
+	s.flushWrite()
+	b, err := s.r.ReadByte()
+	if err != nil {
+		s.setErr(err)
+		return -1
+	}
+	return int(b)
+}
+
+func (s *luaStream) ungetc(c int) {
+	if c >= 0 {
+		s.r.UnreadByte()
+	}
+}
+
+func (s *luaStream) readLine() (string, bool) {
+	s.flushWrite()
+	line, err := s.r.ReadString('\n')
+	s.setErr(err)
+	return line, err == nil
+}
+
+func (s *luaStream) readAll() string {
+	s.flushWrite()
+	b, err := io.ReadAll(s.r)
+	s.setErr(err)
+	return string(b)
+}
+
+func (s *luaStream) readChars(n int64) string {
+	s.flushWrite()
+	buf := &bytes.Buffer{}
+	_, err := io.CopyN(buf, s.r, n)
+	s.setErr(err)
+	return buf.String()
+}
+
+func (s *luaStream) atEOF() bool {
+	s.flushWrite()
+	_, err := s.r.Peek(1)
+	s.setErr(err)
+	return err != nil
+}
+
+func (s *luaStream) write(p []byte) error {
+	s.dropReadBuffer()
+	var err error
+	if s.w == nil {
+		_, err = s.f.Write(p)
+	} else if _, err = s.w.Write(p); err == nil && s.line && bytes.IndexByte(p, '\n') >= 0 {
+		err = s.w.Flush()
+	}
+	s.setErr(err)
+	return err
+}
+
+func (s *luaStream) flush() error {
+	s.dropReadBuffer()
+	return s.flushWrite()
+}
+
+func (s *luaStream) flushWrite() error {
+	if s.w != nil && s.w.Buffered() > 0 {
+		return s.w.Flush()
+	}
+	return nil
+}
+
+// 丢弃已经预读的数据，并把文件位置退回到实际读到的地方。
+// 管道和终端没法退回，预读的数据留给下一次读取
+func (s *luaStream) dropReadBuffer() {
+	if n := s.r.Buffered(); n > 0 && !s.isPipe() {
+		if _, err := s.f.Seek(int64(-n), io.SeekCurrent); err == nil {
+			s.r.Reset(s.f)
+		}
+	}
+}
+
+func (s *luaStream) seek(offset int64, whence int) (int64, error) {
+	if err := s.flushWrite(); err != nil {
+		return 0, err
+	}
+	if whence == io.SeekCurrent {
+		offset -= int64(s.r.Buffered())
+	}
+	pos, err := s.f.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	if !s.isPipe() {
+		s.r.Reset(s.f)
+	}
+	return pos, nil
+}
+
+// 管道、套接字和终端不能移动位置（在终端上Seek也可能"成功"），它们的读缓冲不能丢弃
+func (s *luaStream) isPipe() bool {
+	fi, err := s.f.Stat()
+	return err != nil || fi.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeCharDevice) != 0
+}
+
+// mode: "no"、"full"或者"line"
+func (s *luaStream) setvbuf(mode string, size int) error {
+	if err := s.flushWrite(); err != nil {
+		return err
+	}
+	if mode == "no" {
+		s.w = nil
+	} else {
+		s.w = bufio.NewWriterSize(s.f, size)
+	}
+	s.line = mode == "line"
+	return nil
+}
+
+func (s *luaStream) close() error {
+	err := s.flushWrite()
+	if err2 := s.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// io.EOF不算错误
+func (s *luaStream) setErr(err error) {
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+}
@@ -0,0 +1,32 @@
+package stdlib
+
+import (
+	"os"
+	"testing"
+)
+
+// 管道上的seek和flush失败，已经预读的输入仍然可以读到
+func TestStreamPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString("a\nb\nc\n")
+	w.Close()
+
+	s := newLuaStream(r)
+	if line, _ := s.readLine(); line != "a\n" {
+		t.Fatalf("line = %q", line)
+	}
+	if _, err := s.seek(0, 1); err == nil {
+		t.Error("seek on a pipe succeeded")
+	}
+	if line, _ := s.readLine(); line != "b\n" {
+		t.Errorf("after seek: line = %q", line)
+	}
+	s.flush()
+	if line, _ := s.readLine(); line != "c\n" {
+		t.Errorf("after flush: line = %q", line)
+	}
+}
@@ -122,10 +122,10 @@ func tailCall(i Instruction, vm LuaVM) {
 	a, b, _ := i.ABC()
 	a += 1
 
-	c := 0
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	_popResults(a, c, vm)
+	if !vm.TailCall(nArgs) { // 被调函数是Go函数，返回值交给后面的RETURN指令处理
+		_popResults(a, 0, vm)
+	}
 }
 
 // SELF指令（iABC模式）把对象和方法拷贝到相邻的两个目标寄存器中。对象在寄存器中，索引由操作数B指定。方法名在常量表里，索引由操作数C指定。目标寄存器索引由操作数A指定。
@@ -10,6 +10,16 @@ func forPrep(i Instruction, vm LuaVM) {
 	a, sBx := i.AsBx()
 	a += 1
 
+	if !vm.IsNumber(a + 1) {
+		vm.RunError("'for' limit must be a number")
+	}
+	if !vm.IsNumber(a + 2) {
+		vm.RunError("'for' step must be a number")
+	}
+	if !vm.IsNumber(a) {
+		vm.RunError("'for' initial value must be a number")
+	}
+
 	// R(A) -= R(A+2)
 	vm.PushValue(a)
 	vm.PushValue(a + 2)
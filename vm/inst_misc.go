@@ -14,11 +14,20 @@ func move(i Instruction, vm LuaVM) {
 
 // JMP指令除了可以进行无条件跳转之外，还兼顾着闭合处于开启状态的Upvalue的责任。
 // 如果某个块内部定义的局部变量已经被嵌套函数捕获，那么当这些局部变量退出作用域（也就是块结束）时，编译器会生成一条JMP指令，指示虚拟机闭合相应的Upvalue。
+// 待关闭变量也在这里关闭，所以要先关闭再跳转，__close元方法出错时报告的是JMP指令所在的行
 // pc+=sBx; if (A) close all upvalues >= R(A - 1)
 func jmp(i Instruction, vm LuaVM) {
 	a, sBx := i.AsBx()
-	vm.AddPC(sBx)
 	if a != 0 {
 		vm.CloseUpvalues(a)
 	}
+	vm.AddPC(sBx)
+}
+
+// TBC指令（iABC模式）把局部变量标记为待关闭变量，离开作用域时调用它的__close元方法
+// mark variable A "to be closed"
+// lua-5.4.6/src/lvm.c#OP_TBC
+func tbc(i Instruction, vm LuaVM) {
+	a, _, _ := i.ABC()
+	vm.ToClose(a + 1)
 }
@@ -63,6 +63,11 @@ func (i Instruction) CMode() byte {
 	return opcodes[i.Opcode()].argCMode
 }
 
+// 指令是否修改了寄存器A
+func (i Instruction) TestAMode() bool {
+	return opcodes[i.Opcode()].setAFlag == 1
+}
+
 func (i Instruction) Execute(vm api.LuaVM) {
 	action := opcodes[i.Opcode()].action
 	if action != nil {
@@ -71,3 +76,37 @@ func (i Instruction) Execute(vm api.LuaVM) {
 		panic(i.OpName())
 	}
 }
+
+// 协程恢复时完成被让出打断的指令：被调函数（或者元方法）的返回值已经在栈顶，按照指令把它们移动到寄存器
+// lua-5.3.4/src/lvm.c#luaV_finishOp()
+func (i Instruction) Finish(vm api.LuaVM) {
+	switch i.Opcode() {
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR,
+		OP_MOD, OP_POW, OP_UNM, OP_BNOT, OP_LEN,
+		OP_GETTABUP, OP_GETTABLE, OP_SELF:
+		a, _, _ := i.ABC()
+		vm.Replace(a + 1)
+	case OP_CONCAT:
+		a, _, _ := i.ABC()
+		vm.Concat(vm.GetTop() - vm.RegisterCount()) /* concat them (may yield again) */
+		vm.Replace(a + 1)
+	case OP_EQ, OP_LT, OP_LE:
+		a, _, _ := i.ABC()
+		res := vm.ToBoolean(-1)
+		vm.Pop(3) /* 元方法的返回值和两个操作数 */
+		if res != (a != 0) {
+			vm.AddPC(1) /* skip jump instruction */
+		}
+	case OP_SETTABUP, OP_SETTABLE: /* no op */
+	case OP_CALL:
+		a, _, c := i.ABC()
+		_popResults(a+1, c, vm)
+	case OP_TAILCALL: /* 被调函数是Go函数，返回值交给后面的RETURN指令处理 */
+		a, _, _ := i.ABC()
+		_popResults(a+1, 0, vm)
+	case OP_TFORCALL:
+		a, _, c := i.ABC()
+		_popResults(a+4, c+1, vm)
+	}
+}
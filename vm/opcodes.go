@@ -64,6 +64,7 @@ const (
 	OP_CLOSURE
 	OP_VARARG
 	OP_EXTRAARG
+	OP_TBC // Lua 5.4的待关闭变量，放在5.3的指令之后，不影响原有指令的编号
 )
 
 const (
@@ -132,4 +133,5 @@ var opcodes = []opcode{
 	opcode{0, 1, OpArgU, OpArgN, IABx, "CLOSURE ", closure},
 	opcode{0, 1, OpArgU, OpArgN, IABC, "VARARG  ", vararg},
 	opcode{0, 0, OpArgU, OpArgU, IAx, "EXTRAARG", nil},
+	opcode{0, 0, OpArgN, OpArgN, IABC, "TBC     ", tbc},
 }